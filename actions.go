@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/color"
+	"image/png"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/widget"
+
+	afs "github.com/BieHDC/fic/archivefs"
+	md "github.com/BieHDC/fic/mediadata"
+)
+
+// fileAction is an entry of the context menu on files
+type fileAction struct {
+	label     string
+	available func(uri fyne.URI) bool // nil if it always works
+	slow      bool                    // runs in the background, so no ui in there
+	run       func(v *Viewer, uri fyne.URI) error
+}
+
+var fileActions []fileAction
+
+// registerFileAction adds an entry to the context menu, they are shown
+// in the order they were registered in
+func registerFileAction(label string, available func(fyne.URI) bool, slow bool, run func(*Viewer, fyne.URI) error) {
+	fileActions = append(fileActions, fileAction{label, available, slow, run})
+}
+
+func init() {
+	// archive members have no path anything else could open
+	registerFileAction("Copy Path", isOnDisk, false, copyPath)
+	registerFileAction("Copy Image", nil, true, copyImage)
+	registerFileAction("Open Containing Folder", nil, false, openContainingFolder)
+	registerFileAction("Open With Command", isOnDisk, true, openWithCommand)
+	registerFileAction("Reveal in Tree", nil, false, func(v *Viewer, uri fyne.URI) error {
+		v.revealInTree(uri)
+		return nil
+	})
+	registerFileAction("Properties", nil, false, showProperties)
+	registerFileAction("Move to Trash", isOnDisk, false, func(v *Viewer, uri fyne.URI) error {
+		return v.confirmTrash(uri)
+	})
+}
+
+func (v *Viewer) showFileMenu(uri fyne.URI, on fyne.CanvasObject, pe *fyne.PointEvent) {
+	items := make([]*fyne.MenuItem, 0, len(fileActions))
+	for _, action := range fileActions {
+		if action.available != nil && !action.available(uri) {
+			continue
+		}
+		action := action
+		items = append(items, fyne.NewMenuItem(action.label, func() {
+			v.runFileAction(action, uri)
+		}))
+	}
+	widget.ShowPopUpMenuAtPosition(fyne.NewMenu("", items...), fyne.CurrentApp().Driver().CanvasForObject(on), pe.AbsolutePosition)
+}
+
+// runFileAction runs the action on the file, failures end up in the statusbar.
+// the menu only has the available actions, but checking again is cheap.
+func (v *Viewer) runFileAction(action fileAction, uri fyne.URI) {
+	if action.available != nil && !action.available(uri) {
+		v.setStatus(action.label + " is not possible for " + uri.Name())
+		return
+	}
+	run := func() {
+		if err := action.run(v, uri); err != nil {
+			v.setStatus(action.label + " failed: " + err.Error())
+		}
+	}
+	if action.slow {
+		// like copying a huge image
+		go run()
+	} else {
+		run()
+	}
+}
+
+// contextMenuArea goes on top of the displayed image. it only takes
+// the right clicks, everything else goes through to what is below.
+type contextMenuArea struct {
+	widget.BaseWidget
+	onMenu func(*fyne.PointEvent)
+}
+
+var _ fyne.SecondaryTappable = (*contextMenuArea)(nil)
+
+func newContextMenuArea(onMenu func(*fyne.PointEvent)) *contextMenuArea {
+	a := &contextMenuArea{onMenu: onMenu}
+	a.ExtendBaseWidget(a)
+	return a
+}
+
+func (a *contextMenuArea) TappedSecondary(pe *fyne.PointEvent) {
+	a.onMenu(pe)
+}
+
+func (a *contextMenuArea) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(canvas.NewRectangle(color.Transparent))
+}
+
+func isOnDisk(uri fyne.URI) bool {
+	return !afs.IsArchiveURI(uri)
+}
+
+// diskPath is the file on disk, which is the archive for its members
+func diskPath(uri fyne.URI) string {
+	if archive, ok := afs.ArchivePath(uri); ok {
+		return archive
+	}
+	return uri.Path()
+}
+
+func copyPath(v *Viewer, uri fyne.URI) error {
+	v.window.Clipboard().SetContent(uri.Path())
+	v.setStatus("Copied the path of " + uri.Name())
+	return nil
+}
+
+func copyImage(v *Viewer, uri fyne.URI) error {
+	img, err := v.LoadFullResolution(uri)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err = png.Encode(&buf, img)
+	if err != nil {
+		return err
+	}
+	err = copyImageToClipboard(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	v.setStatus("Copied " + uri.Name())
+	return nil
+}
+
+func openContainingFolder(v *Viewer, uri fyne.URI) error {
+	folder, err := url.Parse(storage.NewFileURI(filepath.Dir(diskPath(uri))).String())
+	if err != nil {
+		return err
+	}
+	return fyne.CurrentApp().OpenURL(folder)
+}
+
+func openWithCommand(v *Viewer, uri fyne.URI) error {
+	args, err := splitCommand(v.opencommand)
+	if err != nil {
+		return err
+	}
+	if len(args) < 1 {
+		return errors.New("set a command in the settings first")
+	}
+	replaced := false
+	for i, arg := range args {
+		if strings.Contains(arg, "{}") {
+			args[i] = strings.ReplaceAll(arg, "{}", uri.Path())
+			replaced = true
+		}
+	}
+	if !replaced {
+		args = append(args, uri.Path())
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	// we do not care how it went, but it should not linger around
+	go cmd.Wait()
+	v.setStatus("Opened " + uri.Name() + " with " + args[0])
+	return nil
+}
+
+// splitCommand splits at spaces except in single or double quotes,
+// like a shell would but without escapes so windows paths work
+func splitCommand(command string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inarg := false
+	var quote rune
+	for _, r := range command {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inarg = true
+		case unicode.IsSpace(r):
+			if inarg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inarg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inarg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("the command has an unterminated %c", quote)
+	}
+	if inarg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// revealInTree opens all folders above the file and selects it
+func (v *Viewer) revealInTree(uri fyne.URI) {
+	parents := parentsfromfile(v.rootdir, uri)
+	for _, parent := range parents {
+		v.filetree.OpenBranch(parent.String())
+	}
+	v.filetree.ScrollTo(uri.String())
+	v.filetree.Select(uri.String())
+}
+
+func showProperties(v *Viewer, uri fyne.URI) error {
+	items := []*widget.FormItem{
+		widget.NewFormItem("Name", widget.NewLabel(uri.Name())),
+	}
+	if member, ok := afs.MemberPath(uri); ok {
+		// the path of a member is made up, nothing else knows about it
+		items = append(items,
+			widget.NewFormItem("Location", widget.NewLabel(diskPath(uri))),
+			widget.NewFormItem("Member", widget.NewLabel(member)),
+		)
+		if size, err := afs.Size(uri); err == nil {
+			items = append(items, widget.NewFormItem("Size", widget.NewLabel(formatSize(size))))
+		}
+	} else {
+		items = append(items, widget.NewFormItem("Location", widget.NewLabel(filepath.Dir(uri.Path()))))
+		if info, err := os.Stat(uri.Path()); err == nil {
+			items = append(items,
+				widget.NewFormItem("Size", widget.NewLabel(formatSize(info.Size()))),
+				widget.NewFormItem("Modified", widget.NewLabel(info.ModTime().Format("2006-01-02 15:04:05"))),
+			)
+		}
+	}
+	items = append(items, widget.NewFormItem("Type", widget.NewLabel(uri.MimeType())))
+	if info, err := md.ReadImageInfo(uri); err == nil {
+		items = append(items, widget.NewFormItem("Dimensions", widget.NewLabel(fmt.Sprintf("%dx%d", info.Width, info.Height))))
+		if !info.Captured.IsZero() {
+			items = append(items, widget.NewFormItem("Captured", widget.NewLabel(info.Captured.Format("2006-01-02 15:04:05"))))
+		}
+	}
+	dialog.ShowCustom("Properties", "Close", widget.NewForm(items...), v.window)
+	return nil
+}
+
+func formatSize(size int64) string {
+	if size < 1024*1024 {
+		return fmt.Sprintf("%0.1f KB", float64(size)/1024)
+	}
+	return fmt.Sprintf("%0.1f MB", float64(size)/1024/1024)
+}
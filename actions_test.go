@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/data/binding"
+	"fyne.io/fyne/v2/storage"
+
+	afs "github.com/BieHDC/fic/archivefs"
+)
+
+func availableActions(uri fyne.URI) []string {
+	var labels []string
+	for _, action := range fileActions {
+		if action.available == nil || action.available(uri) {
+			labels = append(labels, action.label)
+		}
+	}
+	return labels
+}
+
+func TestFileActionsForArchives(t *testing.T) {
+	ondisk := availableActions(storage.NewFileURI("/pictures/a.png"))
+	if len(ondisk) != len(fileActions) {
+		t.Errorf("a file on disk only gets %v", ondisk)
+	}
+
+	// nothing outside can open a path into an archive
+	inarchive := availableActions(afs.NewURI("/pictures/album.zip"))
+	for _, label := range []string{"Copy Path", "Open With Command", "Move to Trash"} {
+		if slices.Contains(inarchive, label) {
+			t.Errorf("%s is offered for an archive", label)
+		}
+	}
+	if !slices.Contains(inarchive, "Open Containing Folder") {
+		t.Error("the folder of the archive can not be opened")
+	}
+	if got := diskPath(afs.NewURI("/pictures/album.zip")); got != "/pictures/album.zip" {
+		t.Errorf("disk path is %s", got)
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	for _, tc := range []struct {
+		command string
+		want    []string
+	}{
+		{"gimp {}", []string{"gimp", "{}"}},
+		{"  gimp   -n  ", []string{"gimp", "-n"}},
+		{`"/opt/my tools/edit" --title 'a "b" c' {}`, []string{"/opt/my tools/edit", "--title", `a "b" c`, "{}"}},
+		{`C:\Program" "Files\gimp.exe ""`, []string{`C:\Program Files\gimp.exe`, ""}},
+		{"--file='{}'", []string{"--file={}"}},
+		{"", nil},
+	} {
+		got, err := splitCommand(tc.command)
+		if err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("%s: got %q %v, want %q", tc.command, got, err, tc.want)
+		}
+	}
+	if _, err := splitCommand(`gimp "{}`); err == nil {
+		t.Error("an unterminated quote is not an error")
+	}
+}
+
+func TestRunFileAction(t *testing.T) {
+	v := &Viewer{statusbar: binding.NewString()}
+	status := func() string {
+		s, _ := v.statusbar.Get()
+		return s
+	}
+
+	release := make(chan struct{})
+	ran := make(chan fyne.URI, 1)
+	slow := fileAction{"Slow", isOnDisk, true, func(v *Viewer, uri fyne.URI) error {
+		<-release
+		ran <- uri
+		return errors.New("too slow")
+	}}
+
+	uri := storage.NewFileURI("/pictures/a.png")
+	returned := make(chan struct{})
+	go func() {
+		v.runFileAction(slow, uri)
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the action does not run in the background")
+	}
+	close(release)
+	if got := <-ran; got != uri {
+		t.Errorf("ran on %s", got)
+	}
+	// the error is set after the action returns
+	for i := 0; status() != "Slow failed: too slow"; i++ {
+		if i > 500 {
+			t.Fatalf("status is %q", status())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// nothing outside can open a path into an archive
+	member, err := storage.ParseURI("archive:///pictures/album.zip!/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.runFileAction(slow, member)
+	if want := "Slow is not possible for a.png"; status() != want {
+		t.Errorf("status is %q, want %q", status(), want)
+	}
+	select {
+	case got := <-ran:
+		t.Errorf("ran on %s", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestOpenWithCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell")
+	}
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "opened")
+	v := &Viewer{
+		statusbar:   binding.NewString(),
+		opencommand: `sh -c 'echo "$1" > "$0"' ` + marker + " {}",
+	}
+	var open fileAction
+	for _, action := range fileActions {
+		if action.label == "Open With Command" {
+			open = action
+		}
+	}
+
+	path := filepath.Join(dir, "a picture.png")
+	v.runFileAction(open, storage.NewFileURI(path))
+	var got []byte
+	for i := 0; len(got) == 0; i++ {
+		if i > 500 {
+			t.Fatal("the command did not run")
+		}
+		time.Sleep(10 * time.Millisecond)
+		got, _ = os.ReadFile(marker)
+	}
+	if string(got) != path+"\n" {
+		t.Errorf("opened %q, want %q", got, path)
+	}
+
+	os.Remove(marker)
+	member, err := storage.ParseURI("archive://" + dir + "/album.zip!/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.runFileAction(open, member)
+	time.Sleep(100 * time.Millisecond)
+	if _, err := os.Stat(marker); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("an archive member was opened: %v", err)
+	}
+}
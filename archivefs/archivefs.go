@@ -0,0 +1,615 @@
+package afs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/storage/repository"
+)
+
+// Archives are exposed as read only folders through their own uri scheme:
+//   archive:///path/to/file.cbz              the archive itself
+//   archive:///path/to/file.cbz!/dir/01.png  a member inside of it
+
+const Scheme = "archive"
+
+const memberSeparator = "!/"
+
+var supportedExtensions = []string{".zip", ".cbz", ".tar", ".cbt", ".tar.gz", ".tgz"}
+
+func init() {
+	repository.Register(Scheme, &archiveRepository{
+		indices: make(map[string]*archiveIndex),
+	})
+}
+
+// IsArchive reports if the file at path can be browsed as a folder
+func IsArchive(path string) bool {
+	lower := strings.ToLower(path)
+	for _, ext := range supportedExtensions {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewURI returns the uri of the archive at path as a folder,
+// without checking if it is a valid archive
+func NewURI(path string) fyne.URI {
+	return &archiveURI{archive: path}
+}
+
+// RootURI returns the folder uri for the archive at path
+func RootURI(path string) (fyne.ListableURI, error) {
+	return storage.ListerForURI(NewURI(path))
+}
+
+func IsArchiveURI(uri fyne.URI) bool {
+	return uri.Scheme() == Scheme
+}
+
+// ArchivePath returns where the archive uri is on disk, for the
+// archive itself as well as its members
+func ArchivePath(uri fyne.URI) (string, bool) {
+	au, ok := asArchiveURI(uri)
+	if !ok {
+		return "", false
+	}
+	return au.archive, true
+}
+
+// MemberPath returns the path of a member inside of its archive,
+// it is empty for the archive itself
+func MemberPath(uri fyne.URI) (string, bool) {
+	au, ok := asArchiveURI(uri)
+	if !ok {
+		return "", false
+	}
+	return au.member, true
+}
+
+func asArchiveURI(uri fyne.URI) (*archiveURI, bool) {
+	if au, ok := uri.(*archiveURI); ok {
+		return au, true
+	}
+	au, err := parseArchiveURI(uri.String())
+	if err != nil {
+		return nil, false
+	}
+	return au, true
+}
+
+// Size returns the uncompressed size of an archive member
+func Size(uri fyne.URI) (int64, error) {
+	repo, err := getRepository()
+	if err != nil {
+		return 0, err
+	}
+	au, err := repo.toArchiveURI(uri)
+	if err != nil {
+		return 0, err
+	}
+	index, err := repo.index(au.archive)
+	if err != nil {
+		return 0, err
+	}
+	member, ok := index.files[au.member]
+	if !ok {
+		return 0, fmt.Errorf("%s: %w", au, os.ErrNotExist)
+	}
+	return member.size, nil
+}
+
+func getRepository() (*archiveRepository, error) {
+	repo, err := repository.ForScheme(Scheme)
+	if err != nil {
+		return nil, err
+	}
+	return repo.(*archiveRepository), nil
+}
+
+type archiveURI struct {
+	archive string // path of the archive on disk
+	member  string // path inside of the archive, empty for the archive itself
+}
+
+var _ fyne.URI = (*archiveURI)(nil)
+
+func (au *archiveURI) Extension() string {
+	return filepath.Ext(au.Name())
+}
+
+func (au *archiveURI) Name() string {
+	if au.member == "" {
+		return filepath.Base(au.archive)
+	}
+	return path.Base(au.member)
+}
+
+func (au *archiveURI) MimeType() string {
+	if au.member == "" {
+		return "inode/directory"
+	}
+	mimetype := mime.TypeByExtension(au.Extension())
+	if mimetype == "" {
+		return "application/octet-stream"
+	}
+	mimetype, _, _ = strings.Cut(mimetype, ";")
+	return mimetype
+}
+
+func (au *archiveURI) Scheme() string {
+	return Scheme
+}
+
+func (au *archiveURI) String() string {
+	if au.member == "" {
+		return Scheme + "://" + au.archive
+	}
+	return Scheme + "://" + au.archive + memberSeparator + au.member
+}
+
+func (au *archiveURI) Authority() string {
+	return ""
+}
+
+// Path pretends the archive is a folder on disk, so the
+// usual path handling also works for the members
+func (au *archiveURI) Path() string {
+	if au.member == "" {
+		return au.archive
+	}
+	return au.archive + "/" + au.member
+}
+
+func (au *archiveURI) Query() string {
+	return ""
+}
+
+func (au *archiveURI) Fragment() string {
+	return ""
+}
+
+func parseArchiveURI(s string) (*archiveURI, error) {
+	rest, ok := strings.CutPrefix(s, Scheme+"://")
+	if !ok {
+		return nil, fmt.Errorf("not an archive uri: %s", s)
+	}
+	// the archive path might contain the separator too,
+	// so we split after the first supported archive name
+	offset := 0
+	for {
+		i := strings.Index(rest[offset:], memberSeparator)
+		if i < 0 {
+			break
+		}
+		i += offset
+		if IsArchive(rest[:i]) {
+			return &archiveURI{archive: rest[:i], member: cleanMember(rest[i+len(memberSeparator):])}, nil
+		}
+		offset = i + len(memberSeparator)
+	}
+	return &archiveURI{archive: rest}, nil
+}
+
+// cleanMember normalises member names, archives are not very strict about them
+func cleanMember(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+type archiveIndex struct {
+	modtime time.Time
+	size    int64
+	dirs    map[string][]string // folder -> full member paths of the children
+	files   map[string]archiveMember
+	// zips are kept open, so the members can be read without
+	// going through the central directory again every time
+	zr *zip.ReadCloser
+}
+
+type archiveMember struct {
+	size   int64 // uncompressed
+	offset int64 // of the data in an uncompressed tar, -1 if we can not seek to it
+	zip    *zip.File
+}
+
+func newArchiveIndex() *archiveIndex {
+	return &archiveIndex{
+		dirs:  map[string][]string{"": nil},
+		files: make(map[string]archiveMember),
+	}
+}
+
+func (ai *archiveIndex) close() {
+	if ai.zr != nil {
+		ai.zr.Close()
+	}
+}
+
+func (ai *archiveIndex) addDir(dir string) {
+	if _, ok := ai.dirs[dir]; ok {
+		return
+	}
+	ai.dirs[dir] = nil
+	parent := path.Dir(dir)
+	if parent == "." {
+		parent = ""
+	}
+	ai.addDir(parent)
+	ai.dirs[parent] = append(ai.dirs[parent], dir)
+}
+
+func (ai *archiveIndex) addFile(name string, member archiveMember) {
+	if _, ok := ai.files[name]; ok {
+		return
+	}
+	parent := path.Dir(name)
+	if parent == "." {
+		parent = ""
+	}
+	ai.addDir(parent)
+	ai.dirs[parent] = append(ai.dirs[parent], name)
+	ai.files[name] = member
+}
+
+func (ai *archiveIndex) finish() {
+	for _, children := range ai.dirs {
+		slices.Sort(children)
+	}
+}
+
+type archiveRepository struct {
+	mu      sync.Mutex
+	indices map[string]*archiveIndex
+}
+
+var _ repository.Repository = (*archiveRepository)(nil)
+var _ repository.CustomURIRepository = (*archiveRepository)(nil)
+var _ repository.ListableRepository = (*archiveRepository)(nil)
+var _ repository.HierarchicalRepository = (*archiveRepository)(nil)
+
+// index returns the content listing of an archive, it is
+// rebuilt when the archive has changed on disk
+func (ar *archiveRepository) index(archive string) (*archiveIndex, error) {
+	stat, err := os.Stat(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	ar.mu.Lock()
+	index, ok := ar.indices[archive]
+	ar.mu.Unlock()
+	if ok && index.modtime.Equal(stat.ModTime()) && index.size == stat.Size() {
+		return index, nil
+	}
+
+	if isZip(archive) {
+		index, err = indexZip(archive)
+	} else {
+		index, err = indexTar(archive)
+	}
+	if err != nil {
+		return nil, err
+	}
+	index.modtime = stat.ModTime()
+	index.size = stat.Size()
+	index.finish()
+
+	ar.mu.Lock()
+	old, ok := ar.indices[archive]
+	if ok && old.modtime.Equal(index.modtime) && old.size == index.size {
+		// someone else was faster, readers might already use theirs
+		ar.mu.Unlock()
+		index.close()
+		return old, nil
+	}
+	ar.indices[archive] = index
+	ar.mu.Unlock()
+	if ok {
+		// the archive changed, reading the old content fails from now on
+		old.close()
+	}
+	return index, nil
+}
+
+func isZip(archive string) bool {
+	lower := strings.ToLower(archive)
+	return strings.HasSuffix(lower, ".zip") || strings.HasSuffix(lower, ".cbz")
+}
+
+func isGzip(archive string) bool {
+	lower := strings.ToLower(archive)
+	return strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz")
+}
+
+func indexZip(archive string) (*archiveIndex, error) {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	index := newArchiveIndex()
+	index.zr = zr
+	for _, file := range zr.File {
+		name := cleanMember(file.Name)
+		if name == "" {
+			continue
+		}
+		if file.FileInfo().IsDir() {
+			index.addDir(name)
+			continue
+		}
+		index.addFile(name, archiveMember{size: int64(file.UncompressedSize64), offset: -1, zip: file})
+	}
+	return index, nil
+}
+
+type tarFile struct {
+	*tar.Reader
+	file    *os.File // nil if it is compressed
+	closers []io.Closer
+}
+
+func (tf *tarFile) Close() error {
+	var err error
+	for i := len(tf.closers) - 1; i >= 0; i-- {
+		err = errors.Join(err, tf.closers[i].Close())
+	}
+	return err
+}
+
+func openTar(archive string) (*tarFile, error) {
+	file, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	tf := &tarFile{closers: []io.Closer{file}}
+
+	var stream io.Reader = file
+	tf.file = file
+	if isGzip(archive) {
+		tf.file = nil
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		tf.closers = append(tf.closers, gz)
+		stream = gz
+	}
+	tf.Reader = tar.NewReader(stream)
+	return tf, nil
+}
+
+func indexTar(archive string) (*archiveIndex, error) {
+	tf, err := openTar(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer tf.Close()
+
+	index := newArchiveIndex()
+	for {
+		header, err := tf.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := cleanMember(header.Name)
+		if name == "" {
+			continue
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			index.addDir(name)
+		case tar.TypeReg:
+			// the reader only ever reads whole blocks from the file,
+			// so right after the header is where the data starts
+			offset := int64(-1)
+			if tf.file != nil {
+				offset, err = tf.file.Seek(0, io.SeekCurrent)
+				if err != nil {
+					return nil, err
+				}
+			}
+			index.addFile(name, archiveMember{size: header.Size, offset: offset})
+		}
+	}
+	return index, nil
+}
+
+type memberReader struct {
+	io.Reader
+	closer io.Closer
+	uri    fyne.URI
+}
+
+func (mr *memberReader) Close() error {
+	return mr.closer.Close()
+}
+
+func (mr *memberReader) URI() fyne.URI {
+	return mr.uri
+}
+
+func openMember(au *archiveURI, index *archiveIndex) (fyne.URIReadCloser, error) {
+	member, ok := index.files[au.member]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", au, os.ErrNotExist)
+	}
+
+	if member.zip != nil {
+		rc, err := member.zip.Open()
+		if err != nil {
+			return nil, err
+		}
+		return &memberReader{Reader: rc, closer: rc, uri: au}, nil
+	}
+
+	if member.offset >= 0 {
+		file, err := os.Open(au.archive)
+		if err != nil {
+			return nil, err
+		}
+		return &memberReader{Reader: io.NewSectionReader(file, member.offset, member.size), closer: file, uri: au}, nil
+	}
+
+	// compressed tars have to be unpacked up to the member every time
+	tf, err := openTar(au.archive)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		header, err := tf.Next()
+		if err != nil {
+			tf.Close()
+			if err == io.EOF {
+				return nil, fmt.Errorf("%s: %w", au, os.ErrNotExist)
+			}
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeReg && cleanMember(header.Name) == au.member {
+			return &memberReader{Reader: tf, closer: tf, uri: au}, nil
+		}
+	}
+}
+
+func (ar *archiveRepository) toArchiveURI(u fyne.URI) (*archiveURI, error) {
+	if au, ok := u.(*archiveURI); ok {
+		return au, nil
+	}
+	return parseArchiveURI(u.String())
+}
+
+func (ar *archiveRepository) Exists(u fyne.URI) (bool, error) {
+	au, err := ar.toArchiveURI(u)
+	if err != nil {
+		return false, err
+	}
+	index, err := ar.index(au.archive)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	_, isfile := index.files[au.member]
+	_, isdir := index.dirs[au.member]
+	return isfile || isdir, nil
+}
+
+func (ar *archiveRepository) Reader(u fyne.URI) (fyne.URIReadCloser, error) {
+	au, err := ar.toArchiveURI(u)
+	if err != nil {
+		return nil, err
+	}
+	index, err := ar.index(au.archive)
+	if err != nil {
+		return nil, err
+	}
+	return openMember(au, index)
+}
+
+func (ar *archiveRepository) CanRead(u fyne.URI) (bool, error) {
+	au, err := ar.toArchiveURI(u)
+	if err != nil {
+		return false, err
+	}
+	index, err := ar.index(au.archive)
+	if err != nil {
+		return false, err
+	}
+	_, isfile := index.files[au.member]
+	return isfile, nil
+}
+
+func (ar *archiveRepository) Destroy(string) {
+	ar.mu.Lock()
+	for _, index := range ar.indices {
+		index.close()
+	}
+	clear(ar.indices)
+	ar.mu.Unlock()
+}
+
+func (ar *archiveRepository) ParseURI(s string) (fyne.URI, error) {
+	return parseArchiveURI(s)
+}
+
+func (ar *archiveRepository) CanList(u fyne.URI) (bool, error) {
+	au, err := ar.toArchiveURI(u)
+	if err != nil {
+		return false, err
+	}
+	index, err := ar.index(au.archive)
+	if err != nil {
+		return false, err
+	}
+	_, isdir := index.dirs[au.member]
+	return isdir, nil
+}
+
+func (ar *archiveRepository) List(u fyne.URI) ([]fyne.URI, error) {
+	au, err := ar.toArchiveURI(u)
+	if err != nil {
+		return nil, err
+	}
+	index, err := ar.index(au.archive)
+	if err != nil {
+		return nil, err
+	}
+	children, ok := index.dirs[au.member]
+	if !ok {
+		return nil, fmt.Errorf("%s: not a folder", au)
+	}
+	uris := make([]fyne.URI, 0, len(children))
+	for _, child := range children {
+		uris = append(uris, &archiveURI{archive: au.archive, member: child})
+	}
+	return uris, nil
+}
+
+func (ar *archiveRepository) CreateListable(u fyne.URI) error {
+	return repository.ErrOperationNotSupported
+}
+
+func (ar *archiveRepository) Parent(u fyne.URI) (fyne.URI, error) {
+	au, err := ar.toArchiveURI(u)
+	if err != nil {
+		return nil, err
+	}
+	if au.member == "" {
+		// leave the archive
+		return storage.NewFileURI(filepath.Dir(au.archive)), nil
+	}
+	parent := path.Dir(au.member)
+	if parent == "." {
+		parent = ""
+	}
+	return &archiveURI{archive: au.archive, member: parent}, nil
+}
+
+func (ar *archiveRepository) Child(u fyne.URI, component string) (fyne.URI, error) {
+	au, err := ar.toArchiveURI(u)
+	if err != nil {
+		return nil, err
+	}
+	return &archiveURI{archive: au.archive, member: cleanMember(path.Join(au.member, component))}, nil
+}
@@ -0,0 +1,277 @@
+package afs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/storage"
+)
+
+// members are the same in every archive, a name ending in a slash is a folder
+var members = []struct {
+	name    string
+	content string
+}{
+	{"b.txt", "bee"},
+	{"a.txt", "a"},
+	{"empty/", ""},
+	{"dir/", ""},
+	// a long name needs an extra header in tars
+	{"dir/" + strings.Repeat("long", 30) + ".txt", "long name"},
+	{"dir/sub/c.txt", strings.Repeat("c", 1500)},
+	// no entry for its folder, some tools do not write them
+	{"implicit/d.txt", "dee"},
+}
+
+func writeZip(t *testing.T, path string) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	zw := zip.NewWriter(file)
+	for _, m := range members {
+		w, err := zw.Create(m.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, m.content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTar(t *testing.T, path string) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var w io.Writer = file
+	if isGzip(path) {
+		gz := gzip.NewWriter(file)
+		defer gz.Close()
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+	for _, m := range members {
+		header := &tar.Header{Name: m.name, Mode: 0644, Size: int64(len(m.content)), Typeflag: tar.TypeReg, ModTime: time.Now()}
+		if strings.HasSuffix(m.name, "/") {
+			header.Typeflag, header.Mode = tar.TypeDir, 0755
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(tw, m.content)
+	}
+}
+
+// testArchives writes the members in every format we read, the folder
+// has the separator in its name to check it is not taken for a member
+func testArchives(t *testing.T) []string {
+	dir := filepath.Join(t.TempDir(), "odd!")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var archives []string
+	for _, name := range []string{"test.zip", "test.cbz", "test.tar", "test.tar.gz", "test.tgz"} {
+		path := filepath.Join(dir, name)
+		if isZip(path) {
+			writeZip(t, path)
+		} else {
+			writeTar(t, path)
+		}
+		archives = append(archives, path)
+	}
+	return archives
+}
+
+func names(uris []fyne.URI) []string {
+	var names []string
+	for _, uri := range uris {
+		names = append(names, uri.Name())
+	}
+	return names
+}
+
+func TestList(t *testing.T) {
+	for _, archive := range testArchives(t) {
+		root, err := RootURI(archive)
+		if err != nil {
+			t.Fatalf("%s: %v", archive, err)
+		}
+		children, err := root.List()
+		if err != nil {
+			t.Fatalf("%s: %v", archive, err)
+		}
+		// empty folders are listed too, leaving them out is up to the tree
+		if got, want := names(children), []string{"a.txt", "b.txt", "dir", "empty", "implicit"}; !slices.Equal(got, want) {
+			t.Errorf("%s: root has %v, want %v", filepath.Base(archive), got, want)
+		}
+
+		dir, err := storage.ListerForURI(children[2])
+		if err != nil {
+			t.Fatalf("%s: %v", archive, err)
+		}
+		children, err = dir.List()
+		if err != nil {
+			t.Fatalf("%s: %v", archive, err)
+		}
+		if got, want := names(children), []string{strings.Repeat("long", 30) + ".txt", "sub"}; !slices.Equal(got, want) {
+			t.Errorf("%s: dir has %v, want %v", filepath.Base(archive), got, want)
+		}
+
+		if ok, _ := storage.CanList(NewURI(archive + "/a.txt")); ok {
+			t.Errorf("%s: a file can be listed", filepath.Base(archive))
+		}
+	}
+}
+
+func TestURIRoundTrip(t *testing.T) {
+	for _, archive := range testArchives(t) {
+		for _, member := range []string{"", "a.txt", "dir/sub/c.txt"} {
+			uri := fyne.URI(&archiveURI{archive: archive, member: member})
+			want := "archive://" + archive
+			if member != "" {
+				want += "!/" + member
+			}
+			if uri.String() != want {
+				t.Errorf("got %s, want %s", uri, want)
+			}
+
+			parsed, err := storage.ParseURI(uri.String())
+			if err != nil {
+				t.Fatalf("%s: %v", uri, err)
+			}
+			au, ok := parsed.(*archiveURI)
+			if !ok || au.archive != archive || au.member != member {
+				t.Errorf("%s: parsed into %#v", uri, parsed)
+			}
+			if path, ok := ArchivePath(parsed); !ok || path != archive {
+				t.Errorf("%s: the archive is %s", uri, path)
+			}
+			if path, ok := MemberPath(parsed); !ok || path != member {
+				t.Errorf("%s: the member is %s", uri, path)
+			}
+		}
+	}
+
+	// members are normalised
+	parsed, err := storage.ParseURI("archive:///x/test.zip!/./dir//sub/../a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if au := parsed.(*archiveURI); au.archive != "/x/test.zip" || au.member != "dir/a.txt" {
+		t.Errorf("parsed into %#v", au)
+	}
+}
+
+func TestParent(t *testing.T) {
+	archive := testArchives(t)[0]
+	tests := []struct {
+		member string
+		want   string
+	}{
+		{"dir/sub/c.txt", "archive://" + archive + "!/dir/sub"},
+		{"dir/sub", "archive://" + archive + "!/dir"},
+		{"a.txt", "archive://" + archive},
+		// leaving the archive goes to the folder it is in
+		{"", storage.NewFileURI(filepath.Dir(archive)).String()},
+	}
+	for _, test := range tests {
+		parent, err := storage.Parent(&archiveURI{archive: archive, member: test.member})
+		if err != nil {
+			t.Fatalf("%q: %v", test.member, err)
+		}
+		if parent.String() != test.want {
+			t.Errorf("%q: got %s, want %s", test.member, parent, test.want)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	for _, archive := range testArchives(t) {
+		// twice, the second time the index is used
+		for range 2 {
+			for _, m := range members {
+				if strings.HasSuffix(m.name, "/") {
+					continue
+				}
+				uri := &archiveURI{archive: archive, member: m.name}
+				reader, err := storage.Reader(uri)
+				if err != nil {
+					t.Fatalf("%s: %v", uri, err)
+				}
+				content, err := io.ReadAll(reader)
+				reader.Close()
+				if err != nil || string(content) != m.content {
+					t.Errorf("%s: read %q, %v, want %q", uri, content, err, m.content)
+				}
+				if size, err := Size(uri); err != nil || size != int64(len(m.content)) {
+					t.Errorf("%s: size %d, %v, want %d", uri, size, err, len(m.content))
+				}
+			}
+		}
+
+		// plain tars are read from where the member is, instead of from the start
+		if !isZip(archive) && !isGzip(archive) {
+			repo, _ := getRepository()
+			index, err := repo.index(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, member := range index.files {
+				if member.offset < 0 {
+					t.Errorf("%s: %s has no offset", filepath.Base(archive), name)
+				}
+			}
+		}
+
+		if _, err := storage.Reader(&archiveURI{archive: archive, member: "missing.txt"}); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s: reading a missing member gave %v", filepath.Base(archive), err)
+		}
+	}
+}
+
+// TestReadChanged reads the new content once the archive was written again
+func TestReadChanged(t *testing.T) {
+	archive := testArchives(t)[0]
+	uri := &archiveURI{archive: archive, member: "a.txt"}
+	if exists, err := storage.Exists(uri); !exists || err != nil {
+		t.Fatalf("a.txt does not exist: %v", err)
+	}
+
+	file, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(file)
+	w, _ := zw.Create("a.txt")
+	io.WriteString(w, "changed")
+	zw.Close()
+	file.Close()
+	// the size alone might not have changed
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(archive, later, later)
+
+	reader, err := storage.Reader(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if content, _ := io.ReadAll(reader); string(content) != "changed" {
+		t.Errorf("read %q, want the new content", content)
+	}
+}
@@ -3,23 +3,39 @@ package main
 import (
 	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
-	"fyne.io/fyne/v2/data/binding"
 	"fyne.io/fyne/v2/storage"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 
 	ft "github.com/BieHDC/fic/filetree"
 	gp "github.com/BieHDC/fic/gifplayer"
+	zv "github.com/BieHDC/fic/zoomviewer"
 )
 
 type Content struct {
 	filetree      *widget.Tree
-	filetreedata  *ft.Filetreemaps
+	filetreedata  atomic.Pointer[ft.Filetreemaps] // see treeData
+	treelock      sync.Mutex                      // guards the walk and the watcher
+	filewatcher   *ft.Watcher
+	treewalk      *ft.TreeWalk // set while walking, stays set if cancelled
+	previews      atomic.Pointer[previewSession]
+	modifiers     fyne.KeyModifier // held down right now, only touched by key events
+	culled        cullHistory
+	trashed       trashHistory
+	ratings       ratingCache
 	mainContainer *fyne.Container
+	presenter     *presentProbe
+	metadatapanel *fyne.Container
+	metadataform  *widget.Form
+	sortinfo      sortInfoCache
+	decode        decodeSettings
 	selected      widget.TreeNodeID
 }
 
@@ -30,14 +46,19 @@ func (v *Viewer) makeViewer() fyne.CanvasObject {
 	r.ScaleMode = canvas.ImageScaleSmooth
 
 	v.mainContainer = container.NewStack(r)
+	v.presenter = newPresentProbe()
 
-	return v.mainContainer
+	return container.NewBorder(nil, nil, nil, v.makeMetadataPanel(),
+		container.NewStack(v.mainContainer, container.NewWithoutLayout(v.presenter.raster)),
+	)
 }
 
 func (v *Viewer) setMainContainer(o fyne.CanvasObject) {
 	// this recursively walks all containers seeking GifPlayers
 	// and telling them to stop playing
 	gp.StopAllCanvasObjectsThatAreGifPlayers(v.mainContainer)
+	// and the zoom viewers from loading the full resolution
+	zv.StopAllCanvasObjectsThatAreZoomViewers(v.mainContainer)
 	v.mainContainer.RemoveAll()
 	v.mainContainer.Add(o)
 	v.mainContainer.Refresh() //needed
@@ -53,7 +74,7 @@ func (v *Viewer) makeLeft() fyne.CanvasObject {
 	parentinfo := func(uri fyne.URI) (int, int) {
 		folders := 0
 		files := 0
-		items := v.filetreedata.Ids[uri.String()]
+		items := v.treeData().Children(uri.String())
 
 		for _, uri := range items {
 			isDir := v.filetree.IsBranch(uri)
@@ -69,7 +90,7 @@ func (v *Viewer) makeLeft() fyne.CanvasObject {
 	v.filetree = widget.NewTree(
 		// childs
 		func(id widget.TreeNodeID) []widget.TreeNodeID {
-			return v.filetreedata.Ids[id]
+			return v.treeData().Children(id)
 		},
 		// is parent
 		func(id widget.TreeNodeID) bool {
@@ -82,9 +103,11 @@ func (v *Viewer) makeLeft() fyne.CanvasObject {
 		// update
 		func(id widget.TreeNodeID, isBranch bool, obj fyne.CanvasObject) {
 			l := obj.(*widget.Label)
-			uri, ok := v.filetreedata.Values[id]
+			uri, ok := v.treeData().URI(id)
 			if !ok {
-				panic("bad id")
+				// removed while drawing, the refresh is on its way
+				l.SetText("")
+				return
 			}
 			if isBranch {
 				folders, files := parentinfo(uri)
@@ -97,7 +120,7 @@ func (v *Viewer) makeLeft() fyne.CanvasObject {
 
 	v.filetree.OnSelected = func(id widget.TreeNodeID) {
 		v.selected = id
-		uri, ok := v.filetreedata.Values[id]
+		uri, ok := v.treeData().URI(id)
 		if !ok {
 			v.setStatus("error getting uri")
 			return
@@ -124,7 +147,7 @@ func (v *Viewer) makeLeft() fyne.CanvasObject {
 
 	searchbutton, searchcontent := v.makeSearchbar()
 	return container.NewBorder(
-		searchbutton,
+		container.NewBorder(nil, nil, nil, container.NewHBox(v.makeRatingFilter(), v.makeSortControls()), searchbutton),
 		nil, nil, nil,
 		container.NewStack(v.filetree, searchcontent),
 	)
@@ -158,13 +181,13 @@ func (v *Viewer) makeSearchbar() (fyne.CanvasObject, fyne.CanvasObject) {
 		results_display = results_display[:0]
 		results_entry = results_entry[:0]
 		if len(s) > 2 {
-			for searchname, uri := range v.filetreedata.Values {
+			v.treeData().Each(func(searchname string, uri fyne.URI) {
 				name := uri.Name()
 				if strings.Contains(name, s) {
 					results_display = append(results_display, name)
 					results_entry = append(results_entry, searchname)
 				}
-			}
+			})
 		}
 		if len(results_entry) >= 1 {
 			v.imgplayer.SetNewData(results_entry)
@@ -217,12 +240,210 @@ func (v *Viewer) displayLoadingScreen(msg string) func() {
 	}
 }
 
+// treeData is the tree that is shown. it gets swapped while walking,
+// so hold on to what it returns instead of asking again.
+func (v *Viewer) treeData() *ft.Filetreemaps {
+	return v.filetreedata.Load()
+}
+
+// treeIncomplete is true if the walk was cancelled or is still going
+func (v *Viewer) treeIncomplete() bool {
+	v.treelock.Lock()
+	defer v.treelock.Unlock()
+	return v.treewalk != nil
+}
+
 func (v *Viewer) refreshFileTree(dir fyne.ListableURI) {
+	v.treelock.Lock()
+	if v.treewalk != nil {
+		v.treewalk.Cancel()
+		v.treewalk = nil
+	}
+	if v.filewatcher != nil {
+		v.filewatcher.Close()
+		v.filewatcher = nil
+	}
+	v.treelock.Unlock()
+	ignore := v.ignorepatterns
+	if reject := rejectPattern(v.rejectfolder, dir.Path()); reject != "" {
+		// culled files should not show up again, whatever the folder is called
+		ignore += "\n" + reject
+	}
+	filter := ft.NewFilter(v.includeextensions, v.excludeextensions, ignore, v.hidedotfiles, v.followsymlinks)
+
+	start := time.Now()
+	if tree := ft.LoadIndex(dir, filter); tree != nil {
+		v.filetreedata.Store(tree)
+		v.setStatus(fmt.Sprintf("Loaded the index in %0.3f sec, looking for changes...", time.Since(start).Seconds()))
+		v.watchFileTree(dir, tree, true)
+		// some sort keys need every file probed, until that is done the
+		// tree is shown in the order it had when the index was saved
+		go func() {
+			v.sortFileTree(tree)
+			v.filetree.Refresh()
+			v.RefreshFolder()
+		}()
+		return
+	}
+
+	// the tree is shown while it is being filled
+	walk := ft.StartFilltree(dir, filter)
+	v.treelock.Lock()
+	v.treewalk = walk
+	v.treelock.Unlock()
+	v.filetreedata.Store(walk.Published())
 	v.setStatus("Loading folder info...")
-	defer v.displayLoadingScreen(fmt.Sprintf("Loading folder: %s", dir.Path()))()
-	var took float64
-	v.filetreedata, took = ft.Fillfiletree(binding.DataTreeRootID, dir, dir.String())
-	v.setStatus(fmt.Sprintf("Loading finished! Took %0.3f sec", took))
+	v.cancelwalk.Show()
+	go v.followTreeWalk(dir, walk)
+}
+
+// publishWalk shows a tree of the walk, unless another root was opened meanwhile
+func (v *Viewer) publishWalk(walk *ft.TreeWalk, tree *ft.Filetreemaps, finished bool) bool {
+	v.treelock.Lock()
+	defer v.treelock.Unlock()
+	if v.treewalk != walk {
+		return false
+	}
+	v.filetreedata.Store(tree)
+	if finished && !walk.Cancelled() {
+		// a cancelled walk is kept around, so we know the tree is not complete
+		v.treewalk = nil
+	}
+	return true
+}
+
+func (v *Viewer) followTreeWalk(dir fyne.ListableURI, walk *ft.TreeWalk) {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			folders, files := walk.Progress()
+			// only what changed since the last tick is sorted, nobody sees
+			// it before it is published
+			changes := walk.Changes()
+			v.sortFileTree(changes)
+			if !v.publishWalk(walk, walk.Publish(changes), false) {
+				return
+			}
+			v.filetree.Refresh()
+			v.RefreshFolder()
+			v.setStatus(fmt.Sprintf("Loading: %d folders, %d files", folders, files))
+
+		case <-walk.Done():
+			tree, took := walk.Result()
+			v.sortFileTree(tree)
+			if !v.publishWalk(walk, tree, true) {
+				// someone started a new one
+				return
+			}
+			v.cancelwalk.Hide()
+			v.filetree.Refresh()
+			v.RefreshFolder()
+			folders, files := walk.Progress()
+			if walk.Cancelled() {
+				v.setStatus(fmt.Sprintf("Loading cancelled after %d folders, %d files", folders, files) + linkStatus(&tree.Links))
+			} else {
+				v.setStatus(fmt.Sprintf("Loading finished! %d folders, %d files, took %0.3f sec", folders, files, took) + linkStatus(&tree.Links))
+				go v.saveIndex(tree)
+			}
+			v.watchFileTree(dir, tree, false)
+			return
+		}
+	}
+}
+
+func (v *Viewer) cancelTreeWalk() {
+	v.treelock.Lock()
+	walk := v.treewalk
+	v.treelock.Unlock()
+	if walk != nil {
+		walk.Cancel()
+		v.setStatus("Cancelling, keeping what was found so far...")
+	}
+}
+
+func (v *Viewer) watchFileTree(dir fyne.ListableURI, tree *ft.Filetreemaps, revalidate bool) {
+	watcher, err := tree.Watch(dir, v.fileTreeChanged)
+	if err != nil {
+		v.setStatus("Watching the folder failed: " + err.Error())
+		return
+	}
+	v.treelock.Lock()
+	if v.treeData() != tree {
+		// another root was opened meanwhile
+		v.treelock.Unlock()
+		watcher.Close()
+		return
+	}
+	v.filewatcher = watcher
+	v.treelock.Unlock()
+	if revalidate {
+		// the tree stays usable while this runs. it is only locked for the
+		// folders that changed, and the changes come in like from the watcher
+		go func() {
+			start := time.Now()
+			watcher.Revalidate()
+			v.setStatus(fmt.Sprintf("Caught up with the changes on disk in %0.3f sec", time.Since(start).Seconds()))
+			v.saveIndex(tree)
+		}()
+	}
+}
+
+// fileWatcher is nil while walking
+func (v *Viewer) fileWatcher() *ft.Watcher {
+	v.treelock.Lock()
+	defer v.treelock.Unlock()
+	return v.filewatcher
+}
+
+func (v *Viewer) saveIndex(tree *ft.Filetreemaps) {
+	if err := tree.SaveIndex(); err != nil {
+		v.setStatus("Saving the folder index failed: " + err.Error())
+	}
+}
+
+func (v *Viewer) fileTreeChanged(changes []ft.Change) {
+	sidecars := 0
+	for _, change := range changes {
+		switch change.Kind {
+		case ft.ChangeCreated:
+		case ft.ChangeSidecar:
+			// someone else rated something
+			v.ratings.forgetSidecar(change.ID)
+			sidecars++
+		default:
+			v.InvalidateImage(change.ID)
+			v.sortinfo.forget(change.ID)
+			v.ratings.forget(change.ID)
+		}
+	}
+	if sidecars > 0 && v.previews.Load() == nil {
+		if uri, ok := v.treeData().URI(v.imgplayer.Current()); ok {
+			v.showRating(uri)
+		}
+	}
+	if sidecars == len(changes) {
+		// the tree is the same
+		return
+	}
+	// new files get added at the end
+	v.sortFileTree(v.treeData())
+	v.filetree.Refresh()
+	v.RefreshFolder()
+	v.setStatus(fmt.Sprintf("Folder changed on disk, %d entries updated", len(changes)))
+}
+
+// linkStatus tells about the symlinks we could not follow
+func linkStatus(links *ft.LinkReport) string {
+	status := ""
+	if broken := links.Broken(); len(broken) > 0 {
+		status += fmt.Sprintf(" | %d broken links, like %s", len(broken), broken[0])
+	}
+	if loops := links.Loops(); len(loops) > 0 {
+		status += fmt.Sprintf(" | skipped %d links that loop, like %s", len(loops), loops[0])
+	}
+	return status
 }
 
 func parentfromfile(uri fyne.URI) fyne.URI {
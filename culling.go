@@ -0,0 +1,466 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"fyne.io/fyne/v2"
+
+	afs "github.com/BieHDC/fic/archivefs"
+	ft "github.com/BieHDC/fic/filetree"
+	md "github.com/BieHDC/fic/mediadata"
+)
+
+type cullKind int
+
+const (
+	cullKeep cullKind = iota
+	cullReject
+	cullUndo
+	cullTrash
+	cullRate
+	cullLabel
+	cullToFolder
+)
+
+type cullAction struct {
+	kind   cullKind
+	folder string // only for cullToFolder
+	stars  int    // only for cullRate
+	label  string // only for cullLabel
+}
+
+type cullModifier struct {
+	name     string
+	modifier fyne.KeyModifier
+}
+
+// the modifiers in the order cullKeyName puts them in
+var cullModifiers = []cullModifier{
+	{"ctrl", fyne.KeyModifierControl},
+	{"alt", fyne.KeyModifierAlt},
+	{"shift", fyne.KeyModifierShift},
+	{"super", fyne.KeyModifierSuper},
+}
+
+// cullKeyName is how the key is looked up, like ctrl+1
+func cullKeyName(name fyne.KeyName, modifiers fyne.KeyModifier) string {
+	key := ""
+	for _, m := range cullModifiers {
+		if modifiers&m.modifier != 0 {
+			key += m.name + "+"
+		}
+	}
+	return key + strings.ToLower(string(name))
+}
+
+// parseCullKey turns Ctrl+1 into the same name cullKeyName would
+func parseCullKey(s string) (string, bool) {
+	parts := strings.Split(strings.ToLower(s), "+")
+	var modifiers fyne.KeyModifier
+	for _, part := range parts[:len(parts)-1] {
+		part = strings.TrimSpace(part)
+		if part == "control" {
+			part = "ctrl"
+		}
+		i := slices.IndexFunc(cullModifiers, func(m cullModifier) bool {
+			return m.name == part
+		})
+		if i < 0 {
+			return "", false
+		}
+		modifiers |= cullModifiers[i].modifier
+	}
+	key := strings.TrimSpace(parts[len(parts)-1])
+	if key == "" {
+		return "", false
+	}
+	return cullKeyName(fyne.KeyName(key), modifiers), true
+}
+
+// parseCullKeys reads the KEY=action lines from the settings, the keys
+// are fyne key names like K, 1 or BackSpace, optionally with modifiers
+// in front like Ctrl+1, and not case sensitive
+func parseCullKeys(s string) map[string]cullAction {
+	actions := make(map[string]cullAction)
+	for _, line := range strings.Split(s, "\n") {
+		key, action, ok := strings.Cut(line, "=")
+		action = strings.TrimSpace(action)
+		if !ok || action == "" {
+			continue
+		}
+		key, ok = parseCullKey(key)
+		if !ok {
+			continue
+		}
+		lower := strings.ToLower(action)
+		if stars, ok := strings.CutPrefix(lower, "rate "); ok {
+			if n, err := strconv.Atoi(strings.TrimSpace(stars)); err == nil && n >= 0 && n <= 5 {
+				actions[key] = cullAction{kind: cullRate, stars: n}
+			}
+			continue
+		}
+		if strings.HasPrefix(lower, "label ") {
+			// the label keeps its case, other tools compare it as is
+			actions[key] = cullAction{kind: cullLabel, label: strings.TrimSpace(action[len("label "):])}
+			continue
+		}
+		switch lower {
+		case "keep":
+			actions[key] = cullAction{kind: cullKeep}
+		case "reject":
+			actions[key] = cullAction{kind: cullReject}
+		case "undo":
+			actions[key] = cullAction{kind: cullUndo}
+		case "trash":
+			actions[key] = cullAction{kind: cullTrash}
+		default:
+			actions[key] = cullAction{kind: cullToFolder, folder: action}
+		}
+	}
+	return actions
+}
+
+// cullOp is what we did to a file, so it can be undone
+type cullOp struct {
+	id       string // in the tree and the player
+	from, to string // on disk, empty when it was only rated
+	// the sidecar that went along, empty if there was none
+	sidecarfrom, sidecarto string
+	moved                  bool
+	rated                  bool
+	previous               md.Rating // before it was rated
+	// where it was in the player
+	index, position int
+}
+
+type cullHistory struct {
+	mu  sync.Mutex
+	ops []cullOp
+}
+
+func (ch *cullHistory) push(op cullOp, limit int) {
+	ch.mu.Lock()
+	ch.ops = append(ch.ops, op)
+	if over := len(ch.ops) - max(limit, 1); over > 0 {
+		ch.ops = ch.ops[over:]
+	}
+	ch.mu.Unlock()
+}
+
+// restore puts an op back that could not be undone
+func (ch *cullHistory) restore(op cullOp) {
+	ch.mu.Lock()
+	ch.ops = append(ch.ops, op)
+	ch.mu.Unlock()
+}
+
+func (ch *cullHistory) pop() (cullOp, bool) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if len(ch.ops) < 1 {
+		return cullOp{}, false
+	}
+	op := ch.ops[len(ch.ops)-1]
+	ch.ops = ch.ops[:len(ch.ops)-1]
+	return op, true
+}
+
+// cullKey runs the culling action bound to the key, if there is one
+func (v *Viewer) cullKey(name fyne.KeyName, modifiers fyne.KeyModifier) bool {
+	action, ok := parseCullKeys(v.cullkeys)[cullKeyName(name, modifiers)]
+	if !ok {
+		return false
+	}
+	if action.kind == cullUndo {
+		v.undoCull()
+		return true
+	}
+
+	id := v.imgplayer.Current()
+	uri, ok := v.treeData().URI(id)
+	if !ok || v.filetree.IsBranch(id) {
+		v.setStatus("There is no file to cull")
+		return true
+	}
+
+	var err error
+	switch action.kind {
+	case cullKeep:
+		// in the sidecar, so it is still kept after a restart
+		err = v.rateFile(id, uri, func(rating md.Rating) md.Rating {
+			rating.Kept = true
+			return rating
+		})
+		if err == nil {
+			v.setStatus("Kept " + uri.Name())
+			v.imgplayer.Next()
+		}
+	case cullTrash:
+		err = v.confirmTrash(uri)
+	case cullRate:
+		err = v.rateFile(id, uri, func(rating md.Rating) md.Rating {
+			rating.Stars = action.stars
+			return rating
+		})
+	case cullLabel:
+		err = v.rateFile(id, uri, func(rating md.Rating) md.Rating {
+			// the same label again takes it off
+			if rating.Label == action.label {
+				rating.Label = ""
+			} else {
+				rating.Label = action.label
+			}
+			return rating
+		})
+	case cullReject:
+		err = v.cullInto(id, uri, v.rejectfolder, true)
+	case cullToFolder:
+		err = v.cullInto(id, uri, action.folder, !v.cullcopy)
+	}
+	if err != nil {
+		v.setStatus("Culling " + uri.Name() + " failed: " + err.Error())
+	}
+	return true
+}
+
+func (v *Viewer) cullInto(id string, uri fyne.URI, folder string, move bool) error {
+	if afs.IsArchiveURI(uri) {
+		return errors.New("files inside of archives can not be moved")
+	}
+	from := uri.Path()
+	if !filepath.IsAbs(folder) {
+		folder = filepath.Join(filepath.Dir(from), folder)
+	}
+	err := os.MkdirAll(folder, 0o755)
+	if err != nil {
+		return err
+	}
+	to, err := freeFileName(filepath.Join(folder, filepath.Base(from)))
+	if err != nil {
+		return err
+	}
+
+	op := cullOp{id: id, from: from, to: to, moved: move}
+	sidecarfrom, sidecarto := sidecarMove(from, to)
+	if move {
+		err = moveFile(from, to)
+		if err != nil {
+			return err
+		}
+		if sidecarfrom != "" && moveFile(sidecarfrom, sidecarto) == nil {
+			op.sidecarfrom, op.sidecarto = sidecarfrom, sidecarto
+		}
+		v.InvalidateImage(id)
+		v.syncFileTree(from, to)
+		// the next file moves up into its place
+		op.index, op.position, _ = v.imgplayer.RemoveData(id)
+		v.setStatus(fmt.Sprintf("Moved %s to %s", uri.Name(), folder))
+	} else {
+		err = copyFile(from, to)
+		if err != nil {
+			return err
+		}
+		if sidecarfrom != "" && copyFile(sidecarfrom, sidecarto) == nil {
+			op.sidecarfrom, op.sidecarto = sidecarfrom, sidecarto
+		}
+		v.syncFileTree(to)
+		v.imgplayer.Next()
+		v.setStatus(fmt.Sprintf("Copied %s to %s", uri.Name(), folder))
+	}
+	v.culled.push(op, int(v.undolimit))
+	return nil
+}
+
+func (v *Viewer) undoCull() {
+	op, ok := v.culled.pop()
+	if !ok {
+		v.setStatus("Nothing to undo")
+		return
+	}
+
+	var err error
+	switch {
+	case op.rated:
+		uri, ok := v.treeData().URI(op.id)
+		if !ok {
+			err = errors.New("the file is gone")
+			break
+		}
+		err = md.WriteRating(uri.Path(), op.previous)
+		if err != nil {
+			break
+		}
+		v.ratings.set(op.id, op.previous)
+		v.imgplayer.SeekToData(op.id)
+		v.showRating(uri)
+		v.setStatus("Put the rating of " + uri.Name() + " back")
+	case op.moved:
+		if _, staterr := os.Lstat(op.from); staterr == nil {
+			err = fmt.Errorf("%s exists again", op.from)
+			break
+		}
+		err = moveFile(op.to, op.from)
+		if err != nil {
+			break
+		}
+		if op.sidecarto != "" {
+			// a new one might have been written meanwhile, that one stays
+			if _, staterr := os.Lstat(op.sidecarfrom); staterr != nil {
+				moveFile(op.sidecarto, op.sidecarfrom)
+			}
+		}
+		v.ratings.forget(op.id)
+		v.syncFileTree(op.to, op.from)
+		v.imgplayer.InsertData(op.id, op.index, op.position)
+		v.setStatus("Moved " + filepath.Base(op.from) + " back")
+	default:
+		err = os.Remove(op.to)
+		if err != nil {
+			break
+		}
+		if op.sidecarto != "" {
+			os.Remove(op.sidecarto)
+		}
+		v.syncFileTree(op.to)
+		v.imgplayer.SeekToData(op.id)
+		v.setStatus("Removed the copy of " + filepath.Base(op.from))
+	}
+	if err != nil {
+		// leave it on the stack, so it can be tried again
+		v.culled.restore(op)
+		v.setStatus("Undo failed: " + err.Error())
+	}
+}
+
+// syncFileTree updates the tree right away after we changed something
+// on disk, the watcher would only do it a bit later
+func (v *Viewer) syncFileTree(paths ...string) {
+	watcher := v.fileWatcher()
+	if watcher == nil {
+		// still loading, the walk picks it up or not
+		return
+	}
+	changes := watcher.Sync(paths...)
+	for _, change := range changes {
+		if change.Kind != ft.ChangeCreated {
+			v.InvalidateImage(change.ID)
+			v.sortinfo.forget(change.ID)
+		}
+	}
+	if len(changes) > 0 {
+		v.sortFileTree(v.treeData())
+		v.filetree.Refresh()
+	}
+}
+
+// sidecarMove returns the sidecar of from and where it goes when the file
+// goes to to, named the same way so other tools find it. both are empty if
+// there is none, or if the place is taken by the sidecar of another file.
+func sidecarMove(from, to string) (string, string) {
+	sidecar := md.SidecarPath(from)
+	if _, err := os.Lstat(sidecar); err != nil {
+		return "", ""
+	}
+	target := to + ".xmp"
+	if sidecar != from+".xmp" {
+		target = strings.TrimSuffix(to, filepath.Ext(to)) + ".xmp"
+	}
+	if _, err := os.Lstat(target); err == nil {
+		return "", ""
+	}
+	return sidecar, target
+}
+
+// freeFileName appends a number to the name until nothing is in the way.
+// if we can not even look, like without permission, it gives up.
+// rejectPattern turns the reject folder into an ignore pattern. a relative
+// one sits next to every file, so it goes by name at any depth. an absolute
+// one only matters if it is inside of root.
+func rejectPattern(folder, root string) string {
+	if folder == "" {
+		return ""
+	}
+	folder = filepath.Clean(folder)
+	anchor := "**/"
+	if filepath.IsAbs(folder) {
+		rel, err := filepath.Rel(root, folder)
+		if err != nil {
+			return ""
+		}
+		folder, anchor = rel, "/"
+	}
+	if folder == "." || folder == ".." || strings.HasPrefix(folder, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	var pattern strings.Builder
+	pattern.WriteString(anchor)
+	for _, r := range filepath.ToSlash(folder) {
+		if strings.ContainsRune(`*?[\`, r) {
+			pattern.WriteRune('\\')
+		}
+		pattern.WriteRune(r)
+	}
+	pattern.WriteString("/")
+	return pattern.String()
+}
+
+func freeFileName(path string) (string, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 2; ; i++ {
+		_, err := os.Lstat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return path, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		path = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+}
+
+func moveFile(from, to string) error {
+	err := os.Rename(from, to)
+	if err == nil {
+		return nil
+	}
+	// probably another filesystem, rename can not do that
+	if copyFile(from, to) != nil {
+		return err
+	}
+	return os.Remove(from)
+}
+
+func copyFile(from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if closeerr := dst.Close(); err == nil {
+		err = closeerr
+	}
+	if err != nil {
+		os.Remove(to)
+		return err
+	}
+	// keeps sorting by date working
+	return os.Chtimes(to, info.ModTime(), info.ModTime())
+}
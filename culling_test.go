@@ -0,0 +1,198 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2"
+)
+
+func TestParseCullKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines string
+		want  map[string]cullAction
+	}{
+		{"empty", "", map[string]cullAction{}},
+		{"the simple ones", "K=keep\nx = Reject\nBackSpace=undo\nDelete=TRASH", map[string]cullAction{
+			"k":         {kind: cullKeep},
+			"x":         {kind: cullReject},
+			"backspace": {kind: cullUndo},
+			"delete":    {kind: cullTrash},
+		}},
+		{"ratings", "1=rate 1\n5=Rate  5\n0=rate 0\n6=rate 6\n7=rate lots", map[string]cullAction{
+			"1": {kind: cullRate, stars: 1},
+			"5": {kind: cullRate, stars: 5},
+			"0": {kind: cullRate, stars: 0},
+		}},
+		{"labels keep their case", "R=label Red\nG=LABEL To Print ", map[string]cullAction{
+			"r": {kind: cullLabel, label: "Red"},
+			"g": {kind: cullLabel, label: "To Print"},
+		}},
+		{"everything else is a folder", "F=../Best Of\nA=/tmp/archive=old", map[string]cullAction{
+			"f": {kind: cullToFolder, folder: "../Best Of"},
+			"a": {kind: cullToFolder, folder: "/tmp/archive=old"},
+		}},
+		{"broken lines are skipped", "no equals\n=keep\nK=\n  \r", map[string]cullAction{}},
+		{"the last one wins", "K=keep\nk=reject", map[string]cullAction{
+			"k": {kind: cullReject},
+		}},
+		{"modifiers", "Ctrl+1=rate 1\nshift + alt + X=reject\ncontrol+super+2=label Red\nhyper+3=keep\nctrl+=keep", map[string]cullAction{
+			"ctrl+1":       {kind: cullRate, stars: 1},
+			"alt+shift+x":  {kind: cullReject},
+			"ctrl+super+2": {kind: cullLabel, label: "Red"},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := parseCullKeys(test.lines); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCullKeyName(t *testing.T) {
+	tests := []struct {
+		name      fyne.KeyName
+		modifiers fyne.KeyModifier
+		want      string
+	}{
+		{fyne.Key1, 0, "1"},
+		{fyne.KeyBackspace, 0, "backspace"},
+		{fyne.Key1, fyne.KeyModifierControl, "ctrl+1"},
+		{fyne.KeyK, fyne.KeyModifierShift | fyne.KeyModifierControl | fyne.KeyModifierSuper, "ctrl+shift+super+k"},
+	}
+	for _, test := range tests {
+		if got := cullKeyName(test.name, test.modifiers); got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+
+	// the defaults bind the number keys to folders, and with ctrl to ratings
+	keys := parseCullKeys(defaultCullKeys)
+	for i := 1; i <= 9; i++ {
+		digit := fyne.KeyName(strconv.Itoa(i))
+		if keys[cullKeyName(digit, 0)].kind != cullToFolder {
+			t.Errorf("%s does not move to a folder", digit)
+		}
+		if kind := keys[cullKeyName(digit, fyne.KeyModifierControl)].kind; kind != cullRate && kind != cullLabel {
+			t.Errorf("ctrl+%s does not rate", digit)
+		}
+	}
+}
+
+func TestRejectPattern(t *testing.T) {
+	tests := []struct {
+		folder, root string
+		want         string
+	}{
+		{"rejected", "/photos", "**/rejected/"},
+		{"culled/rejected/", "/photos", "**/culled/rejected/"},
+		{"*bad[1]", "/photos", `**/\*bad\[1]/`},
+		{"/photos/rejected", "/photos", "/rejected/"},
+		// outside of the tree, nothing to hide
+		{"/elsewhere/rejected", "/photos", ""},
+		{"../rejected", "/photos", ""},
+		{"", "/photos", ""},
+	}
+	for _, test := range tests {
+		got := rejectPattern(test.folder, test.root)
+		if got != test.want {
+			t.Errorf("%q in %q: got %q, want %q", test.folder, test.root, got, test.want)
+		}
+	}
+}
+
+func TestFreeFileName(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.png", "a (2).png", "b", "c.tar.gz", "broken.png"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a dangling link is in the way just the same
+	os.Remove(filepath.Join(dir, "broken.png"))
+	if err := os.Symlink(filepath.Join(dir, "missing"), filepath.Join(dir, "broken.png")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"free.png", "free.png"},
+		{"a.png", "a (3).png"},
+		{"b", "b (2)"},
+		{"c.tar.gz", "c.tar (2).gz"},
+		{"broken.png", "broken (2).png"},
+	}
+	for _, test := range tests {
+		got, err := freeFileName(filepath.Join(dir, test.name))
+		if err != nil || got != filepath.Join(dir, test.want) {
+			t.Errorf("%s: got %s, %v, want %s", test.name, filepath.Base(got), err, test.want)
+		}
+	}
+
+	// a file where a folder should be, this would never be free
+	if got, err := freeFileName(filepath.Join(dir, "b", "a.png")); err == nil {
+		t.Errorf("got %s for a path that can not exist", got)
+	}
+}
+
+func TestCopyFileKeepsModTime(t *testing.T) {
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "from"), filepath.Join(dir, "to")
+	if err := os.WriteFile(from, []byte("data"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	os.Chtimes(from, old, old)
+	if err := copyFile(from, to); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(to)
+	if err != nil || !info.ModTime().Equal(old) {
+		t.Errorf("copy is %v, %v", info, err)
+	}
+	// it never overwrites
+	if copyFile(from, to) == nil {
+		t.Error("copied over an existing file")
+	}
+}
+
+func TestSidecarMove(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.jpg", "a.xmp", "b.cr2", "b.cr2.xmp", "c.png", "taken.jpg", "taken.xmp", "d.jpg", "d.xmp"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		from, to         string
+		wantfrom, wantto string
+	}{
+		{"a.jpg", "out/a.jpg", "a.xmp", "out/a.xmp"},
+		{"a.jpg", "out/a (2).jpg", "a.xmp", "out/a (2).xmp"},
+		{"b.cr2", "out/b.cr2", "b.cr2.xmp", "out/b.cr2.xmp"},
+		{"c.png", "out/c.png", "", ""},
+		// the sidecar of another file is in the way
+		{"d.jpg", "taken.jpg", "", ""},
+	}
+	for _, test := range tests {
+		join := func(name string) string {
+			if name == "" {
+				return ""
+			}
+			return filepath.Join(dir, name)
+		}
+		from, to := sidecarMove(join(test.from), join(test.to))
+		if from != join(test.wantfrom) || to != join(test.wantto) {
+			t.Errorf("%s to %s: got %s to %s, want %s to %s", test.from, test.to, from, to, test.wantfrom, test.wantto)
+		}
+	}
+}
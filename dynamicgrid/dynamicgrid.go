@@ -8,7 +8,7 @@ import (
 	"fyne.io/fyne/v2/theme"
 )
 
-func NewDynamicGrid(minrows, mincols int, requestMore func(int) []fyne.CanvasObject, objects ...fyne.CanvasObject) *fyne.Container {
+func NewDynamicGrid(minrows, mincols int, requestMore func(int) ([]fyne.CanvasObject, bool), objects ...fyne.CanvasObject) *fyne.Container {
 	return container.New(NewDynamicGridLayout(minrows, mincols, requestMore), objects...)
 }
 
@@ -18,12 +18,14 @@ var _ fyne.Layout = (*dynamicGridLayout)(nil)
 type dynamicGridLayout struct {
 	minrows     int
 	mincols     int
-	requestMore func(int) []fyne.CanvasObject
+	requestMore func(int) ([]fyne.CanvasObject, bool)
 	exhausted   bool
 }
 
 // NewDynamicGridLayout returns a new grid layout which uses columns when horizontal but rows when vertical.
-func NewDynamicGridLayout(minrows, mincols int, requestMore func(int) []fyne.CanvasObject) fyne.Layout {
+// requestMore is asked for the amount of objects that would fit and returns what it has so far,
+// the bool tells if there will never be more. it may fill in the rest later and refresh the container.
+func NewDynamicGridLayout(minrows, mincols int, requestMore func(int) ([]fyne.CanvasObject, bool)) fyne.Layout {
 	minrows = max(minrows, 1)
 	mincols = max(mincols, 1)
 	exhausted := requestMore == nil //we will never be able to request more
@@ -96,11 +98,8 @@ func (g *dynamicGridLayout) Layout(objects []fyne.CanvasObject, size fyne.Size)
 	maxamount := numrows * numcols
 	if !g.exhausted && (len(objects) < maxamount) {
 		if g.requestMore != nil {
-			objects = g.requestMore(maxamount)
-			if len(objects) < maxamount {
-				// stop trying to fetch more items
-				g.exhausted = true
-			}
+			// stop trying to fetch more items once it says so
+			objects, g.exhausted = g.requestMore(maxamount)
 		}
 	}
 
@@ -11,9 +11,11 @@ import (
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/driver/desktop"
 	"fyne.io/fyne/v2/storage"
 	"fyne.io/fyne/v2/widget"
 
+	afs "github.com/BieHDC/fic/archivefs"
 	ilp "github.com/BieHDC/fic/imagelistplayer"
 	md "github.com/BieHDC/fic/mediadata"
 )
@@ -39,6 +41,7 @@ func main() {
 type Viewer struct {
 	//general
 	rootdir fyne.ListableURI
+	window  fyne.Window
 
 	//ui specific
 	Menubar
@@ -94,8 +97,11 @@ func makeSplash() (fyne.CanvasObject, func()) {
 
 func makeMain(a fyne.App, w fyne.Window) fyne.CanvasObject {
 	v := NewViewer()
+	v.window = w
 	// Load settings
 	v.LoadSettings()
+	v.SetCacheBudget(int64(v.maxcachesize))
+	v.applyDecodeSettings(w.Canvas())
 	w.SetFullScreen(v.fullscreen)
 	w.Resize(fyne.NewSize(v.winx, v.winy))
 	w.SetOnClosed(func() {
@@ -103,6 +109,10 @@ func makeMain(a fyne.App, w fyne.Window) fyne.CanvasObject {
 		scale := a.Settings().Scale()
 		x, y := w.Canvas().Size().Components()
 		v.SaveSettings(x*scale, y*scale, w.FullScreen())
+		// so the next start does not have to walk everything
+		if !v.treeIncomplete() {
+			v.treeData().SaveIndex()
+		}
 	})
 
 	// Point of Interest
@@ -137,8 +147,17 @@ func makeMain(a fyne.App, w fyne.Window) fyne.CanvasObject {
 			v.imgplayer.Previous()
 		case fyne.KeyRight:
 			v.imgplayer.Next()
+		case fyne.KeyI:
+			v.toggleMetadataPanel()
+		default:
+			v.cullKey(ke.Name, v.modifiers)
 		}
 	})
+	// typed keys do not tell about the modifiers, so we follow them ourselves
+	if dc, ok := w.Canvas().(desktop.Canvas); ok {
+		dc.SetOnKeyDown(func(ke *fyne.KeyEvent) { v.modifiers |= modifierOf(ke.Name) })
+		dc.SetOnKeyUp(func(ke *fyne.KeyEvent) { v.modifiers &^= modifierOf(ke.Name) })
+	}
 
 	content := container.NewHSplit(v.makeLeft(), v.makeViewer())
 	content.SetOffset(0.3)
@@ -158,7 +177,24 @@ func makeMain(a fyne.App, w fyne.Window) fyne.CanvasObject {
 	return final
 }
 
+func modifierOf(key fyne.KeyName) fyne.KeyModifier {
+	switch key {
+	case desktop.KeyControlLeft, desktop.KeyControlRight:
+		return fyne.KeyModifierControl
+	case desktop.KeyAltLeft, desktop.KeyAltRight:
+		return fyne.KeyModifierAlt
+	case desktop.KeyShiftLeft, desktop.KeyShiftRight:
+		return fyne.KeyModifierShift
+	case desktop.KeySuperLeft, desktop.KeySuperRight:
+		return fyne.KeyModifierSuper
+	}
+	return 0
+}
+
 func stringToListerURI(dir string) (fyne.ListableURI, error) {
+	if afs.IsArchive(dir) {
+		return afs.RootURI(dir)
+	}
 	fileuri := storage.NewFileURI(dir)
 	diruri, err := storage.ListerForURI(fileuri)
 	if err != nil {
@@ -1,6 +1,8 @@
 package fc
 
 import (
+	"image/color"
+
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/theme"
@@ -12,9 +14,14 @@ type FileCard struct {
 	filename string
 	image    fyne.CanvasObject
 	tapped   func(*fyne.PointEvent)
+	menu     func(*fyne.PointEvent)
+	// shown on top of the image, like a rating
+	badge      string
+	badgecolor color.Color
 }
 
 var _ fyne.Tappable = (*FileCard)(nil)
+var _ fyne.SecondaryTappable = (*FileCard)(nil)
 
 func trimitiseFilename(s string) string {
 	const targetStringLen = 26 //arbitrary number, subject to testing and change
@@ -44,29 +51,41 @@ func (c *FileCard) Tapped(pe *fyne.PointEvent) {
 	}
 }
 
-/*
 func (c *FileCard) TappedSecondary(pe *fyne.PointEvent) {
-	menu := fyne.NewMenu("Fileinfo",
-			     fyne.NewMenuItem("Copy Link", func() {
-				     fmt.Println("this is:" ,c.Filename)
-			}))
-	widget.ShowPopUpMenuAtPosition(menu, fyne.CurrentApp().Driver().CanvasForObject(c), pe.AbsolutePosition.AddXY(0,1))
+	if c.menu != nil {
+		c.menu(pe)
+	}
 }
-*/
 
 func (c *FileCard) WithCallback(cb func(*fyne.PointEvent)) *FileCard {
 	c.tapped = cb
 	return c
 }
 
+// WithBadge puts a short text on top of the image, a nil color is the theme default
+func (c *FileCard) WithBadge(text string, col color.Color) *FileCard {
+	c.badge = text
+	c.badgecolor = col
+	return c
+}
+
+// WithSecondaryCallback is called on right click, usually to show a context menu
+func (c *FileCard) WithSecondaryCallback(cb func(*fyne.PointEvent)) *FileCard {
+	c.menu = cb
+	return c
+}
+
 // CreateRenderer is a private method to Fyne which links this widget to its renderer
 func (c *FileCard) CreateRenderer() fyne.WidgetRenderer {
 	c.ExtendBaseWidget(c)
 
 	filenameText := canvas.NewText(c.filename, theme.ForegroundColor())
 	filenameText.Alignment = fyne.TextAlignCenter
+	badgeText := canvas.NewText(c.badge, theme.ForegroundColor())
+	badgeText.TextStyle.Bold = true
 	return &filecardRenderer{
 		filenameText: filenameText,
+		badgeText:    badgeText,
 		card:         c,
 	}
 }
@@ -98,6 +117,7 @@ func (c *FileCard) SetFilename(text string) {
 
 type filecardRenderer struct {
 	filenameText *canvas.Text
+	badgeText    *canvas.Text
 	card         *FileCard
 }
 
@@ -112,7 +132,7 @@ const (
 func (c *filecardRenderer) Destroy() {}
 
 func (c *filecardRenderer) Objects() []fyne.CanvasObject {
-	return []fyne.CanvasObject{c.filenameText, c.card.image}
+	return []fyne.CanvasObject{c.filenameText, c.card.image, c.badgeText}
 }
 
 // Layout the components of the card container.
@@ -127,6 +147,9 @@ func (c *filecardRenderer) Layout(size fyne.Size) {
 		pos.Y += cardMediaHeight
 	}
 
+	c.badgeText.Move(fyne.NewSquareOffsetPos(padding * 2))
+	c.badgeText.Resize(c.badgeText.MinSize())
+
 	if c.card.filename != "" {
 		titlePad := padding * 2
 		size.Width -= titlePad * 2
@@ -167,6 +190,14 @@ func (c *filecardRenderer) Refresh() {
 		c.filenameText.Color = theme.ForegroundColor()
 		c.filenameText.Refresh()
 	}
+	c.badgeText.Text = c.card.badge
+	c.badgeText.TextSize = theme.TextSize()
+	c.badgeText.Color = theme.ForegroundColor()
+	if c.card.badgecolor != nil {
+		c.badgeText.Color = c.card.badgecolor
+	}
+	c.badgeText.Resize(c.badgeText.MinSize())
+	c.badgeText.Refresh()
 	if c.card.image != nil {
 		c.card.image.Refresh()
 	}
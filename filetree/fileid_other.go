@@ -0,0 +1,18 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package ft
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// fileIDOf falls back to the real path, as there is no inode to compare
+func fileIDOf(path string, _ os.FileInfo) fileID {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return fileID{path: path}
+	}
+	return fileID{path: real}
+}
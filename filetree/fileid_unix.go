@@ -0,0 +1,18 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package ft
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileIDOf needs the info of the target, not of a link to it
+func fileIDOf(path string, info os.FileInfo) fileID {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{path: path}
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}
+}
@@ -3,84 +3,137 @@ package ft
 import (
 	"os"
 	"sync"
-	"time"
 
 	"fyne.io/fyne/v2"
-	"fyne.io/fyne/v2/data/binding"
 	"fyne.io/fyne/v2/storage"
+
+	afs "github.com/BieHDC/fic/archivefs"
 )
 
+// Filetreemaps is the tree of folders and files. the watcher keeps changing
+// it while it is shown, so everything outside of this package goes through
+// the methods below, which take the lock.
 type Filetreemaps struct {
 	mu     sync.Mutex
-	Ids    map[string][]string
-	Values map[string]fyne.URI
+	ids    map[string][]string // the children of every folder
+	values map[string]fyne.URI
+	Links  LinkReport // only filled when following symlinks
+	filter *Filter
+	root   fyne.ListableURI
+	// of every folder on disk we walked, also the empty ones that are not
+	// in the tree. lets us find out what changed since the index was saved.
+	modtimes map[string]int64
+	// the folders whose children changed, only kept while walking. in a
+	// tree from TreeWalk.Changes these are the folders that get published.
+	changed map[string]struct{}
 }
 
-func newFiletreemaps() *Filetreemaps {
+func newFiletreemaps(filter *Filter) *Filetreemaps {
 	return &Filetreemaps{
-		Ids:    make(map[string][]string),
-		Values: make(map[string]fyne.URI),
+		ids:      make(map[string][]string),
+		values:   make(map[string]fyne.URI),
+		filter:   filter,
+		modtimes: make(map[string]int64),
 	}
 }
 
 func (ft *Filetreemaps) Nil() {
-	ft.Ids = nil
-	ft.Values = nil
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	ft.ids = nil
+	ft.values = nil
+	ft.modtimes = nil
+}
+
+// Children returns the ids in a folder. the slice is never modified
+// in place, it stays valid after the tree changed.
+func (ft *Filetreemaps) Children(id string) []string {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	return ft.ids[id]
+}
+
+// IsFolder is true for folders that have something in them
+func (ft *Filetreemaps) IsFolder(id string) bool {
+	return len(ft.Children(id)) > 0
+}
+
+func (ft *Filetreemaps) URI(id string) (fyne.URI, bool) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	uri, ok := ft.values[id]
+	return uri, ok
+}
+
+// URIs looks up many ids at once, the ones not in the tree are left out
+func (ft *Filetreemaps) URIs(ids []string) []fyne.URI {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	uris := make([]fyne.URI, 0, len(ids))
+	for _, id := range ids {
+		if uri, ok := ft.values[id]; ok {
+			uris = append(uris, uri)
+		}
+	}
+	return uris
+}
+
+// Each calls fn for every folder and file in the tree. fn is called
+// with the tree locked and must not call back into it.
+func (ft *Filetreemaps) Each(fn func(id string, uri fyne.URI)) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	for id, uri := range ft.values {
+		fn(id, uri)
+	}
 }
 
 func (ft *Filetreemaps) addEntryNotLocked(parent, id string, val fyne.URI, prepend bool) {
-	lids, ok := ft.Ids[parent]
+	lids, ok := ft.ids[parent]
 	if !ok {
 		lids = make([]string, 0)
 	}
 
 	if prepend {
-		ft.Ids[parent] = append([]string{id}, lids...)
+		ft.ids[parent] = append([]string{id}, lids...)
 	} else {
-		ft.Ids[parent] = append(lids, id)
+		ft.ids[parent] = append(lids, id)
 	}
-	ft.Values[id] = val
+	ft.values[id] = val
 }
 
-func (ft *Filetreemaps) merge(childfolder string, cft *Filetreemaps, childuri fyne.URI) {
-	ft.mu.Lock()
+func (ft *Filetreemaps) mergeNotLocked(childfolder string, cft *Filetreemaps, childuri fyne.URI) {
 	// dont need to lock the child, it has to be finished before merge
-
-	for k, v := range cft.Ids {
-		ft.Ids[k] = v
+	for k, v := range cft.ids {
+		ft.ids[k] = v
 	}
-	for k, v := range cft.Values {
-		ft.Values[k] = v
+	for k, v := range cft.values {
+		ft.values[k] = v
 	}
-	ft.Values[childfolder] = childuri
-
-	ft.mu.Unlock()
+	ft.mergeModtimesNotLocked(cft)
+	ft.values[childfolder] = childuri
 }
 
-func Fillfiletree(parent string, dir fyne.ListableURI, root string) (*Filetreemaps, float64) {
-	ft := newFiletreemaps()
-	ft.addEntryNotLocked(binding.DataTreeRootID, dir.String(), dir, true)
-
-	cft := newFiletreemaps()
-	start := time.Now()
-	sem := make(chan struct{}, 200) // chosen by gut feeling
-	cft.walkdirectory(dir.String(), dir, sem)
-
-	close(sem)
-	// nobody seems to really know if we need or should do this
-	for range sem {
+func (ft *Filetreemaps) mergeModtimesNotLocked(cft *Filetreemaps) {
+	for k, v := range cft.modtimes {
+		ft.modtimes[k] = v
 	}
-	sem = nil
-
-	ft.merge(dir.String(), cft, dir)
+}
 
-	return ft, time.Since(start).Seconds()
+// Fillfiletree walks dir and returns when it is done, see StartFilltree
+func Fillfiletree(parent string, dir fyne.ListableURI, root string, filter *Filter) (*Filetreemaps, float64) {
+	tw := StartFilltree(dir, filter)
+	<-tw.Done()
+	return tw.Result()
 }
 
 type entryFolder struct {
 	parentfolder string
 	nodeID       string
 	uri          fyne.ListableURI
+	ctx          walkContext
+	modtime      int64 // 0 inside of archives
+	empty        bool
 }
 
 type entryFile struct {
@@ -89,36 +142,79 @@ type entryFile struct {
 	uri          fyne.URI
 }
 
-func walkfolder(parentfolder string, dir fyne.ListableURI) ([]entryFolder, []entryFile) {
+func walkfolder(parentfolder string, dir fyne.ListableURI, filter *Filter, ctx walkContext) ([]entryFolder, []entryFile) {
 	var folders []entryFolder
 	var files []entryFile
 
+	addFolder := func(uri fyne.URI, ctx walkContext, modtime int64) {
+		lu, err := storage.ListerForURI(uri)
+		if lu == nil || err != nil {
+			return
+		}
+		numitems, _ := lu.List()
+		// empty folders are not added, but we remember them in case they fill up
+		folders = append(folders, entryFolder{parentfolder, lu.String(), lu, ctx.into(lu.String(), lu), modtime, len(numitems) == 0})
+	}
+
 	items, _ := dir.List()
+	ctx.rules = folderRules(ctx.rules, dir, items)
+	rules := ctx.rules
 	for _, uri := range items {
 		uri := uri
 		nodeID := uri.String()
 
+		if afs.IsArchiveURI(uri) {
+			// we are inside of an archive, there is nothing on disk to stat
+			isDir, err := storage.CanList(uri)
+			if err != nil || filter.skips(rules, uri, isDir) {
+				continue
+			}
+			if isDir {
+				addFolder(uri, ctx, 0)
+			} else if filter.Allows(uri) {
+				files = append(files, entryFile{parentfolder, nodeID, uri})
+			}
+			continue
+		}
+
 		fileinfo, err := os.Lstat(uri.Path())
 		if err != nil {
 			continue
 		}
+		fileinfo, ok := ctx.resolve(uri.Path(), fileinfo)
+		if !ok {
+			continue
+		}
 		mode := fileinfo.Mode()
+		if filter.skips(rules, uri, mode.IsDir()) {
+			// ignored folders are not even looked into
+			continue
+		}
 
 		if mode.IsDir() {
-			uri, err := storage.ListerForURI(uri)
-			if uri == nil || err != nil {
+			if ctx.following() {
+				id := fileIDOf(uri.Path(), fileinfo)
+				if ctx.isLoop(uri.Path(), id) {
+					continue
+				}
+				addFolder(uri, ctx.child(id), fileinfo.ModTime().UnixNano())
 				continue
 			}
-			numitems, _ := uri.List()
-			// do not add empty folders
-			if len(numitems) > 0 {
-				folders = append(folders, entryFolder{parentfolder, nodeID, uri})
-			}
+			addFolder(uri, ctx, fileinfo.ModTime().UnixNano())
 			continue
 		}
 
 		if mode.IsRegular() {
-			files = append(files, entryFile{parentfolder, nodeID, uri})
+			if afs.IsArchive(uri.Path()) {
+				root, err := afs.RootURI(uri.Path())
+				if err == nil {
+					addFolder(root, ctx, fileinfo.ModTime().UnixNano())
+				}
+				continue
+			}
+			if filter.Allows(uri) {
+				files = append(files, entryFile{parentfolder, nodeID, uri})
+			}
 			continue
 		}
 	}
@@ -126,42 +222,40 @@ func walkfolder(parentfolder string, dir fyne.ListableURI) ([]entryFolder, []ent
 	return folders, files
 }
 
-func (ft *Filetreemaps) walkdirectory(parentfolder string, dir fyne.ListableURI, sem chan struct{}) {
-	folders, files := walkfolder(parentfolder, dir)
+// walkdirectory publishes the files of every folder as soon as it is listed,
+// so the tree can be shown while the subfolders are still being walked
+func (ft *Filetreemaps) walkdirectory(dir fyne.ListableURI, sem chan struct{}, ctx walkContext) {
+	if ctx.progress.cancelled() {
+		return
+	}
+	folders, files := walkfolder(dir.String(), dir, ft.filter, ctx)
+	ctx.progress.walked(len(files))
+
+	ft.mu.Lock()
+	for _, folder := range folders {
+		if folder.modtime != 0 {
+			ft.modtimes[folder.nodeID] = folder.modtime
+		}
+	}
+	if len(files) > 0 {
+		ft.publishNotLocked(ctx.chain, files)
+	}
+	ft.mu.Unlock()
 
 	var wg sync.WaitGroup
-	wg.Add(len(folders))
 	for _, folder := range folders {
+		if folder.empty {
+			continue
+		}
+		wg.Add(1)
 		sem <- struct{}{}
 		go func(folder entryFolder) {
-			cft := newFiletreemaps()
 			<-sem
-			cft.walkdirectory(folder.nodeID, folder.uri, sem)
-			ft.merge(folder.nodeID, cft, folder.uri)
-			//lets help the gc a little out
-			cft.Nil()
-			cft = nil
+			ft.walkdirectory(folder.uri, sem, folder.ctx)
 			wg.Done()
 		}(folder)
 	}
 	wg.Wait()
-
-	for _, file := range files {
-		ft.addEntryNotLocked(file.parentfolder, file.nodeID, file.uri, false)
-	}
-
-	lf := len(folders)
-	if lf == 0 {
-		return
-	}
-	for i := lf - 1; i >= 0; i-- {
-		ff := folders[i]
-		ft.addEntryNotLocked(ff.parentfolder, ff.nodeID, ff.uri, true)
-	}
-
-	// lets help the GC a little out
-	files = nil
-	folders = nil
 }
 
 /*
@@ -0,0 +1,90 @@
+package ft
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"fyne.io/fyne/v2"
+
+	afs "github.com/BieHDC/fic/archivefs"
+)
+
+// Filter decides which files make it into the tree
+type Filter struct {
+	include      map[string]bool // extensions that are always shown
+	exclude      map[string]bool // extensions that are never shown
+	ignore       string          // patterns like in an ignore file, for the whole tree
+	hidedotfiles bool
+	followlinks  bool
+}
+
+// NewFilter takes lists of extensions like "png, .psd *.txt",
+// everything else is shown if it looks like a decodable image.
+// ignore skips whole folders while walking, see IgnoreFileName.
+func NewFilter(include, exclude, ignore string, hidedotfiles, followlinks bool) *Filter {
+	return &Filter{
+		include:      parseExtensions(include),
+		exclude:      parseExtensions(exclude),
+		ignore:       ignore,
+		hidedotfiles: hidedotfiles,
+		followlinks:  followlinks,
+	}
+}
+
+func parseExtensions(list string) map[string]bool {
+	exts := make(map[string]bool)
+	for _, ext := range strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' '
+	}) {
+		ext = strings.ToLower(strings.TrimLeft(ext, "*."))
+		if ext != "" {
+			exts["."+ext] = true
+		}
+	}
+	return exts
+}
+
+// the image formats only need a few bytes to recognise themselves
+const sniffSize = 512
+
+// Allows reports if the file should be shown, a nil filter allows everything
+func (f *Filter) Allows(uri fyne.URI) bool {
+	if f == nil {
+		return true
+	}
+	ext := strings.ToLower(filepath.Ext(uri.Name()))
+	if f.exclude[ext] {
+		return false
+	}
+	if f.include[ext] {
+		return true
+	}
+	if afs.IsArchiveURI(uri) {
+		// reading a member can mean decompressing the archive up to
+		// it, which is way too slow to do for all of them
+		return strings.HasPrefix(uri.MimeType(), "image/")
+	}
+	return sniffImage(uri.Path())
+}
+
+// sniffImage asks the registered image formats if they know the file. a
+// header that is cut short still means one of them recognised it.
+func sniffImage(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header := make([]byte, sniffSize)
+	n, err := io.ReadFull(f, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return false
+	}
+	_, _, err = image.DecodeConfig(bytes.NewReader(header[:n]))
+	return !errors.Is(err, image.ErrFormat)
+}
@@ -0,0 +1,105 @@
+package ft
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"fyne.io/fyne/v2/storage"
+)
+
+func TestParseExtensions(t *testing.T) {
+	tests := []struct {
+		list string
+		want []string
+	}{
+		{"", nil},
+		{"png", []string{".png"}},
+		{"png, .psd *.txt;JPG", []string{".png", ".psd", ".txt", ".jpg"}},
+		{" , ;*.", nil},
+	}
+	for _, test := range tests {
+		want := make(map[string]bool)
+		for _, ext := range test.want {
+			want[ext] = true
+		}
+		if got := parseExtensions(test.list); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got %v, want %v", test.list, got, want)
+		}
+	}
+}
+
+func encodedImages(t *testing.T) (pngdata, gifdata []byte) {
+	t.Helper()
+	img := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White})
+	var pngbuf, gifbuf bytes.Buffer
+	if err := png.Encode(&pngbuf, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := gif.Encode(&gifbuf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return pngbuf.Bytes(), gifbuf.Bytes()
+}
+
+func TestFilterAllows(t *testing.T) {
+	pngdata, gifdata := encodedImages(t)
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"photo.png":      pngdata,
+		"anim.gif":       gifdata,
+		"noextension":    pngdata,
+		"wrongname.jpg":  gifdata,
+		"cut.png":        pngdata[:20], // only the header, the rest did not arrive yet
+		"notes.txt":      []byte("not an image"),
+		"layers.psd":     pngdata,
+		"empty.png":      nil,
+		"IMAGE.PNG":      pngdata,
+		"forced.raw":     []byte("raw data no decoder knows"),
+		"excluded.PSD":   []byte("still excluded"),
+		"shortnote.text": []byte("hi"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filter := NewFilter("raw", "psd", "", false, false)
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"photo.png", true},
+		{"anim.gif", true},
+		{"noextension", true},
+		{"wrongname.jpg", true},
+		{"cut.png", true},
+		{"notes.txt", false},
+		{"layers.psd", false},
+		{"empty.png", false},
+		{"IMAGE.PNG", true},
+		{"forced.raw", true},
+		{"excluded.PSD", false},
+		{"shortnote.text", false},
+		{"missing.png", false},
+	}
+	for _, test := range tests {
+		uri := storage.NewFileURI(filepath.Join(dir, test.name))
+		if got := filter.Allows(uri); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// a nil filter is what the tools use that want everything
+	var nofilter *Filter
+	if !nofilter.Allows(storage.NewFileURI(filepath.Join(dir, "notes.txt"))) {
+		t.Error("a nil filter skipped a file")
+	}
+}
@@ -0,0 +1,183 @@
+package ft
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/storage"
+)
+
+// IgnoreFileName works like a .gitignore for the folder it is in
+const IgnoreFileName = ".ficignore"
+
+type ignoreRule struct {
+	base     string   // the folder the pattern applies to
+	segments []string // of the pattern, split at the slashes
+	negate   bool
+	dironly  bool
+	anchored bool // has a slash, so it matches from base on instead of any name
+}
+
+// ignoreRules chains the rules of a folder to the ones of its parents
+type ignoreRules struct {
+	parent *ignoreRules
+	rules  []ignoreRule
+}
+
+// parseIgnore reads patterns like git does, minus the rarely used escapes
+func parseIgnore(text, base string) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{base: strings.TrimSuffix(base, "/")}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, `\`)
+		if strings.HasSuffix(line, "/") {
+			rule.dironly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		rule.segments = strings.Split(line, "/")
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func (r ignoreRule) matches(p string, isdir bool) bool {
+	if r.dironly && !isdir {
+		return false
+	}
+	rel, ok := strings.CutPrefix(p, r.base+"/")
+	if !ok {
+		return false
+	}
+	if !r.anchored {
+		matched, _ := path.Match(r.segments[0], path.Base(rel))
+		return matched
+	}
+	return matchSegments(r.segments, strings.Split(rel, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// any amount of folders, including none
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], name[0]); !matched {
+			return false
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+	return len(name) == 0
+}
+
+// ignored applies the rules from the top down, the last match decides
+func (ir *ignoreRules) ignored(p string, isdir bool) bool {
+	if ir == nil {
+		return false
+	}
+	ignored := ir.parent.ignored(p, isdir)
+	for _, rule := range ir.rules {
+		if rule.matches(p, isdir) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+func (ir *ignoreRules) extend(rules []ignoreRule) *ignoreRules {
+	if len(rules) == 0 {
+		return ir
+	}
+	return &ignoreRules{parent: ir, rules: rules}
+}
+
+func readIgnoreFile(uri fyne.URI) string {
+	res, err := storage.Reader(uri)
+	if err != nil {
+		return ""
+	}
+	defer res.Close()
+	data, err := io.ReadAll(res)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// folderRules extends the rules with the ignore file in dir, if items has one
+func folderRules(rules *ignoreRules, dir fyne.URI, items []fyne.URI) *ignoreRules {
+	for _, uri := range items {
+		if uri.Name() == IgnoreFileName {
+			return rules.extend(parseIgnore(readIgnoreFile(uri), dir.Path()))
+		}
+	}
+	return rules
+}
+
+// rootRules are the global patterns, relative to the root of the tree
+func (f *Filter) rootRules(rootpath string) *ignoreRules {
+	if f == nil {
+		return nil
+	}
+	return (*ignoreRules)(nil).extend(parseIgnore(f.ignore, rootpath))
+}
+
+// rulesForFolder collects the rules that apply inside of a folder on disk,
+// for when we did not come along the way there while walking
+func (f *Filter) rulesForFolder(rootpath, folder string) *ignoreRules {
+	rules := f.rootRules(rootpath)
+	rel, err := filepath.Rel(rootpath, folder)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return rules
+	}
+	dir := rootpath
+	parts := []string{}
+	if rel != "." {
+		parts = strings.Split(rel, string(filepath.Separator))
+	}
+	for i := 0; ; i++ {
+		data, err := os.ReadFile(filepath.Join(dir, IgnoreFileName))
+		if err == nil {
+			rules = rules.extend(parseIgnore(string(data), storage.NewFileURI(dir).Path()))
+		}
+		if i >= len(parts) {
+			return rules
+		}
+		dir = filepath.Join(dir, parts[i])
+	}
+}
+
+// skips reports if the entry is hidden or ignored
+func (f *Filter) skips(rules *ignoreRules, uri fyne.URI, isdir bool) bool {
+	if f != nil && f.hidedotfiles && strings.HasPrefix(uri.Name(), ".") {
+		return true
+	}
+	return rules.ignored(uri.Path(), isdir)
+}
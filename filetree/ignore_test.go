@@ -0,0 +1,159 @@
+package ft
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/storage"
+)
+
+func TestIgnorePatterns(t *testing.T) {
+	type check struct {
+		path    string
+		isdir   bool
+		ignored bool
+	}
+	tests := []struct {
+		name     string
+		patterns string
+		checks   []check
+	}{
+		{"names match in every folder", "*.tmp", []check{
+			{"/root/a.tmp", false, true},
+			{"/root/sub/deep/b.tmp", false, true},
+			{"/root/a.png", false, false},
+			{"/elsewhere/a.tmp", false, false},
+		}},
+		{"trailing slash only matches folders", "cache/", []check{
+			{"/root/cache", true, true},
+			{"/root/sub/cache", true, true},
+			{"/root/cache", false, false},
+		}},
+		{"leading slash anchors to the folder", "/build", []check{
+			{"/root/build", true, true},
+			{"/root/sub/build", true, false},
+		}},
+		{"a slash in the middle anchors too", "docs/*.md", []check{
+			{"/root/docs/a.md", false, true},
+			{"/root/x/docs/a.md", false, false},
+			{"/root/docs/sub/a.md", false, false},
+		}},
+		{"leading double star", "**/thumbs", []check{
+			{"/root/thumbs", true, true},
+			{"/root/a/b/thumbs", true, true},
+			{"/root/a/thumbsup", true, false},
+		}},
+		{"double star in the middle", "a/**/b", []check{
+			{"/root/a/b", true, true},
+			{"/root/a/x/y/b", true, true},
+			{"/root/a/x", true, false},
+			{"/root/x/a/b", true, false},
+		}},
+		{"trailing double star", "raw/**", []check{
+			{"/root/raw/a.png", false, true},
+			{"/root/raw/x/a.png", false, true},
+		}},
+		{"negation takes files back", "*.png\n!keep.png", []check{
+			{"/root/other.png", false, true},
+			{"/root/keep.png", false, false},
+			{"/root/sub/keep.png", false, false},
+		}},
+		{"the last match decides", "!keep.png\n*.png", []check{
+			{"/root/keep.png", false, true},
+		}},
+		{"comments, blank lines and trailing spaces", "# *.png\n\n*.tmp   \n", []check{
+			{"/root/a.png", false, false},
+			{"/root/a.tmp", false, true},
+		}},
+		{"escaped hash and exclamation mark", "\\#notes\n\\!important", []check{
+			{"/root/#notes", false, true},
+			{"/root/!important", false, true},
+			{"/root/important", false, false},
+		}},
+		{"character classes", "img[0-9].png\nshot?.jpg", []check{
+			{"/root/img5.png", false, true},
+			{"/root/imgx.png", false, false},
+			{"/root/shot1.jpg", false, true},
+			{"/root/shot10.jpg", false, false},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules := (*ignoreRules)(nil).extend(parseIgnore(test.patterns, "/root"))
+			for _, c := range test.checks {
+				if got := rules.ignored(c.path, c.isdir); got != c.ignored {
+					t.Errorf("%s (folder %v): ignored is %v, want %v", c.path, c.isdir, got, c.ignored)
+				}
+			}
+		})
+	}
+}
+
+func TestIgnoreRulesChain(t *testing.T) {
+	// the root ignores all tmp files, a subfolder wants one of them back
+	root := (*ignoreRules)(nil).extend(parseIgnore("*.tmp", "/root"))
+	sub := root.extend(parseIgnore("!keep.tmp\nlocal.png", "/root/sub"))
+	tests := []struct {
+		rules   *ignoreRules
+		path    string
+		ignored bool
+	}{
+		{sub, "/root/sub/keep.tmp", false},
+		{sub, "/root/sub/other.tmp", true},
+		{sub, "/root/sub/local.png", true},
+		{root, "/root/keep.tmp", true},
+		{root, "/root/local.png", false},
+	}
+	for _, test := range tests {
+		if got := test.rules.ignored(test.path, false); got != test.ignored {
+			t.Errorf("%s: ignored is %v, want %v", test.path, got, test.ignored)
+		}
+	}
+	if root.extend(nil) != root {
+		t.Error("no rules should not add a level")
+	}
+}
+
+func TestIgnoreWhileWalking(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir,
+		"a.txt", "skip.txt", ".hidden.txt",
+		"render-cache/frame.txt",
+		"shots/one.txt", "shots/two.txt", "shots/keep.txt",
+		".dotfolder/inside.txt",
+	)
+	if err := os.WriteFile(filepath.Join(dir, IgnoreFileName), []byte("skip.txt\n*.txt\n!a.txt\n!shots/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "shots", IgnoreFileName), []byte("!*.txt\ntwo.txt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	filter := NewFilter("txt", "", "render-cache/", true, false)
+	root := listerFor(t, dir)
+	tree, _ := Fillfiletree("", root, root.String(), filter)
+
+	var got []string
+	tree.Each(func(id string, uri fyne.URI) {
+		if _, isfolder := tree.ids[id]; !isfolder {
+			rel, _ := filepath.Rel(dir, uri.Path())
+			got = append(got, rel)
+		}
+	})
+	slices.Sort(got)
+	want := []string{"a.txt", "shots/keep.txt", "shots/one.txt"}
+	if !slices.Equal(got, want) {
+		t.Errorf("walked %v, want %v", got, want)
+	}
+
+	// the watcher asks the ignore files along the way again
+	rules := filter.rulesForFolder(root.Path(), filepath.Join(dir, "shots"))
+	if !filter.skips(rules, storage.NewFileURI(filepath.Join(dir, "shots", "two.txt")), false) {
+		t.Error("two.txt should be skipped in shots")
+	}
+	if filter.skips(rules, storage.NewFileURI(filepath.Join(dir, "shots", "new.txt")), false) {
+		t.Error("new.txt should not be skipped in shots")
+	}
+}
@@ -0,0 +1,254 @@
+package ft
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/storage"
+	"github.com/fsnotify/fsnotify"
+
+	afs "github.com/BieHDC/fic/archivefs"
+)
+
+// bump when the walker changes what ends up in the tree
+const indexVersion = 1
+
+type treeIndex struct {
+	Version  int
+	Root     string
+	Filter   string // an index made with other settings has other files in it
+	Ids      map[string][]string
+	ModTimes map[string]int64
+}
+
+func indexPath(root fyne.URI) string {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		cache = os.TempDir()
+	}
+	sum := sha256.Sum256([]byte(root.String()))
+	return filepath.Join(cache, "fic", "index", hex.EncodeToString(sum[:16])+".gob.gz")
+}
+
+func (f *Filter) signature() string {
+	if f == nil {
+		return ""
+	}
+	sortedKeys := func(m map[string]bool) []string {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	return fmt.Sprintf("%v|%v|%q|%v|%v", sortedKeys(f.include), sortedKeys(f.exclude), f.ignore, f.hidedotfiles, f.followlinks)
+}
+
+// LoadIndex returns the tree as it was saved for root, or nil if there is
+// none for the same filter. it is as old as the save, see Watcher.Revalidate.
+func LoadIndex(root fyne.ListableURI, filter *Filter) *Filetreemaps {
+	file, err := os.Open(indexPath(root))
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil
+	}
+	var index treeIndex
+	if gob.NewDecoder(gz).Decode(&index) != nil {
+		return nil
+	}
+	if index.Version != indexVersion || index.Root != root.String() || index.Filter != filter.signature() {
+		return nil
+	}
+
+	ft := newFiletreemaps(filter)
+	ft.root = root
+	ft.ids = index.Ids
+	if index.ModTimes != nil {
+		ft.modtimes = index.ModTimes
+	}
+	ft.values[root.String()] = root
+	for _, children := range ft.ids {
+		for _, id := range children {
+			if _, ok := ft.values[id]; ok {
+				continue
+			}
+			uri, err := storage.ParseURI(id)
+			if err != nil {
+				// better walk again than show half of it
+				return nil
+			}
+			ft.values[id] = uri
+		}
+	}
+	return ft
+}
+
+// SaveIndex stores the tree, so opening the same root again can skip the walk
+func (ft *Filetreemaps) SaveIndex() error {
+	if ft.root == nil {
+		return errors.New("not the root of a tree")
+	}
+	ft.mu.Lock()
+	// the slices are never modified in place, a shallow copy is enough
+	index := treeIndex{
+		Version:  indexVersion,
+		Root:     ft.root.String(),
+		Filter:   ft.filter.signature(),
+		Ids:      maps.Clone(ft.ids),
+		ModTimes: maps.Clone(ft.modtimes),
+	}
+	ft.mu.Unlock()
+
+	path := indexPath(ft.root)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	// write next to it and rename, so a crash never leaves half an index
+	tmp, err := os.CreateTemp(filepath.Dir(path), "index-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	gz := gzip.NewWriter(tmp)
+	err = gob.NewEncoder(gz).Encode(&index)
+	if err == nil {
+		err = gz.Close()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// stat calls in flight while revalidating, network drives
+// take their time to answer but do not mind many questions
+const revalidateWorkers = 32
+
+type staleFolder struct {
+	id      string
+	path    string
+	modtime int64 // 0 if it is gone
+}
+
+// Revalidate catches up with the changes on disk since the index was saved.
+// only folders whose mtime changed are looked into, so files that got
+// written to in place or edits to ignore files need a full rescan.
+// the tree is only locked for the folders that changed.
+func (w *Watcher) Revalidate() {
+	w.ft.mu.Lock()
+	saved := maps.Clone(w.ft.modtimes)
+	w.ft.mu.Unlock()
+
+	var lock sync.Mutex
+	var stale []staleFolder
+	sem := make(chan struct{}, revalidateWorkers)
+	var wg sync.WaitGroup
+	for id, modtime := range saved {
+		uri, err := storage.ParseURI(id)
+		if err != nil {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(id, path string, modtime int64) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			current := int64(0)
+			if info, err := os.Stat(path); err == nil {
+				current = info.ModTime().UnixNano()
+			}
+			if current != modtime {
+				lock.Lock()
+				stale = append(stale, staleFolder{id, path, current})
+				lock.Unlock()
+			}
+		}(id, uri.Path(), modtime)
+	}
+	wg.Wait()
+
+	// parents first, their rescan might already cover the children
+	slices.SortFunc(stale, func(a, b staleFolder) int {
+		return len(a.path) - len(b.path)
+	})
+
+	var changes []Change
+	for _, folder := range stale {
+		select {
+		case <-w.done:
+			// another root got opened
+			return
+		default:
+		}
+		w.ft.mu.Lock()
+		changes = append(changes, w.rescanNotLocked(folder)...)
+		w.ft.mu.Unlock()
+	}
+
+	if len(changes) > 0 && w.onChange != nil {
+		w.onChange(changes)
+	}
+}
+
+func (w *Watcher) rescanNotLocked(folder staleFolder) []Change {
+	if folder.modtime == 0 {
+		delete(w.ft.modtimes, folder.id)
+		return w.applyNotLocked(folder.path, fsnotify.Remove)
+	}
+	w.ft.modtimes[folder.id] = folder.modtime
+
+	_, intree := w.ft.ids[folder.id]
+	if !intree {
+		// it was empty before, the watcher knows how to add it
+		return w.applyNotLocked(folder.path, fsnotify.Create)
+	}
+	if afs.IsArchiveURI(w.ft.values[folder.id]) {
+		// there is no telling what changed inside of it
+		return w.applyNotLocked(folder.path, fsnotify.Write)
+	}
+
+	// compare what is on disk and what is in the tree, entry by entry
+	var paths []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	entries, _ := os.ReadDir(folder.path)
+	for _, entry := range entries {
+		add(filepath.Join(folder.path, entry.Name()))
+	}
+	for _, child := range w.ft.ids[folder.id] {
+		if uri, ok := w.ft.values[child]; ok {
+			add(uri.Path())
+		}
+	}
+
+	var changes []Change
+	for _, path := range paths {
+		changes = append(changes, w.applyNotLocked(path, 0)...)
+	}
+	return changes
+}
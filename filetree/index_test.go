@@ -0,0 +1,195 @@
+package ft
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2/storage"
+)
+
+// useTempCache keeps the indexes of the tests out of the real cache
+func useTempCache(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+}
+
+func sameTree(t *testing.T, got, want *Filetreemaps) {
+	t.Helper()
+	if len(got.ids) != len(want.ids) {
+		t.Errorf("got %d folders, want %d", len(got.ids), len(want.ids))
+	}
+	for id, children := range want.ids {
+		if !slices.Equal(got.ids[id], children) {
+			t.Errorf("%s has %v, want %v", id, got.ids[id], children)
+		}
+	}
+	for id, uri := range want.values {
+		if gotu, ok := got.values[id]; !ok || gotu.String() != uri.String() {
+			t.Errorf("%s is %v, want %v", id, gotu, uri)
+		}
+	}
+	if !maps.Equal(got.modtimes, want.modtimes) {
+		t.Errorf("modtimes are %v, want %v", got.modtimes, want.modtimes)
+	}
+}
+
+func TestIndexRoundtrip(t *testing.T) {
+	useTempCache(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, "a.txt", "b.txt", "sub/c.txt", "sub/deep/d.txt")
+	if err := os.Mkdir(filepath.Join(dir, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	root := listerFor(t, dir)
+	tree, _ := Fillfiletree("", root, root.String(), textFilter)
+	if err := tree.SaveIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := LoadIndex(root, NewFilter("txt", "", "", false, false))
+	if loaded == nil {
+		t.Fatal("the index was not loaded")
+	}
+	sameTree(t, loaded, tree)
+	// empty folders are not in the tree, but we need to know when they fill up
+	if _, ok := loaded.modtimes[storage.NewFileURI(filepath.Join(dir, "empty")).String()]; !ok {
+		t.Error("the empty folder was not remembered")
+	}
+}
+
+func TestIndexMismatch(t *testing.T) {
+	useTempCache(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, "a.txt")
+	root := listerFor(t, dir)
+	tree, _ := Fillfiletree("", root, root.String(), textFilter)
+	if err := tree.SaveIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	// every setting that changes what gets walked makes the index useless
+	filters := map[string]*Filter{
+		"include":     NewFilter("txt, md", "", "", false, false),
+		"exclude":     NewFilter("txt", "psd", "", false, false),
+		"ignore":      NewFilter("txt", "", "cache/", false, false),
+		"dotfiles":    NewFilter("txt", "", "", true, false),
+		"followlinks": NewFilter("txt", "", "", false, true),
+		"nil":         nil,
+	}
+	for name, filter := range filters {
+		if LoadIndex(root, filter) != nil {
+			t.Errorf("loaded an index made with another %s", name)
+		}
+	}
+	// the order of the extensions does not matter
+	if LoadIndex(root, NewFilter("TXT", "", "", false, false)) == nil {
+		t.Error("the same filter written differently did not load")
+	}
+
+	other := listerFor(t, t.TempDir())
+	if LoadIndex(other, textFilter) != nil {
+		t.Error("loaded the index of another root")
+	}
+}
+
+func TestIndexBroken(t *testing.T) {
+	useTempCache(t)
+	dir := t.TempDir()
+	root := listerFor(t, dir)
+	path := indexPath(root)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(index treeIndex) {
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gz := gzip.NewWriter(file)
+		if err := gob.NewEncoder(gz).Encode(&index); err != nil {
+			t.Fatal(err)
+		}
+		gz.Close()
+		file.Close()
+	}
+
+	write(treeIndex{Version: indexVersion + 1, Root: root.String(), Filter: textFilter.signature()})
+	if LoadIndex(root, textFilter) != nil {
+		t.Error("loaded an index of another version")
+	}
+
+	write(treeIndex{Version: indexVersion, Root: root.String(), Filter: textFilter.signature(),
+		Ids: map[string][]string{root.String(): {"::not a uri"}}})
+	if LoadIndex(root, textFilter) != nil {
+		t.Error("loaded an index with a broken id")
+	}
+
+	if err := os.WriteFile(path, []byte("not gzip"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if LoadIndex(root, textFilter) != nil {
+		t.Error("loaded garbage")
+	}
+
+	if err := newFiletreemaps(textFilter).SaveIndex(); err == nil {
+		t.Error("saved a tree that has no root")
+	}
+}
+
+func TestRevalidate(t *testing.T) {
+	useTempCache(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, "a.txt", "gone.txt", "sub/c.txt")
+	if err := os.Mkdir(filepath.Join(dir, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	root := listerFor(t, dir)
+	tree, _ := Fillfiletree("", root, root.String(), textFilter)
+	if err := tree.SaveIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	// what happened while we were closed
+	os.Remove(filepath.Join(dir, "gone.txt"))
+	writeFiles(t, dir, "new.txt", "empty/filled.txt", "added/e.txt")
+	os.RemoveAll(filepath.Join(dir, "sub"))
+	// the clock might not have moved on since the walk
+	later := time.Now().Add(time.Minute)
+	for _, folder := range []string{dir, filepath.Join(dir, "empty")} {
+		os.Chtimes(folder, later, later)
+	}
+
+	loaded := LoadIndex(root, textFilter)
+	if loaded == nil {
+		t.Fatal("the index was not loaded")
+	}
+	var changes []Change
+	w, err := loaded.Watch(root, func(c []Change) { changes = append(changes, c...) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.Revalidate()
+	if len(changes) == 0 {
+		t.Error("no changes were reported")
+	}
+
+	fresh, _ := Fillfiletree("", root, root.String(), textFilter)
+	for id := range fresh.values {
+		if _, ok := loaded.URI(id); !ok {
+			t.Errorf("%s is missing after revalidating", id)
+		}
+	}
+	for id := range loaded.values {
+		if _, ok := fresh.URI(id); !ok {
+			t.Errorf("%s is still there after revalidating", id)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package ft
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"fyne.io/fyne/v2"
+)
+
+// CompareNatural compares names like a human would, img2 comes before img10
+func CompareNatural(a, b string) int {
+	origa, origb := a, b
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			var numa, numb string
+			numa, a = digitRun(a)
+			numb, b = digitRun(b)
+			// longer numbers are bigger, leading zeros do not count
+			numa = strings.TrimLeft(numa, "0")
+			numb = strings.TrimLeft(numb, "0")
+			if c := cmp.Compare(len(numa), len(numb)); c != 0 {
+				return c
+			}
+			if c := strings.Compare(numa, numb); c != 0 {
+				return c
+			}
+			continue
+		}
+		ra, sizea := utf8.DecodeRuneInString(a)
+		rb, sizeb := utf8.DecodeRuneInString(b)
+		if c := cmp.Compare(unicode.ToLower(ra), unicode.ToLower(rb)); c != 0 {
+			return c
+		}
+		a = a[sizea:]
+		b = b[sizeb:]
+	}
+	if c := cmp.Compare(len(a), len(b)); c != 0 {
+		return c
+	}
+	// img02 and img2, or Img and img, still need a stable order
+	return strings.Compare(origa, origb)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func digitRun(s string) (string, string) {
+	end := 0
+	for end < len(s) && isDigit(s[end]) {
+		end++
+	}
+	return s[:end], s[end:]
+}
+
+// Sort orders the children of every folder, folders stay in front of the files.
+// compare is called with the tree locked and must not call back into it.
+// of a tree from TreeWalk.Changes only the folders that changed are sorted.
+func (ft *Filetreemaps) Sort(compare func(a, b fyne.URI) int) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	for parent, children := range ft.ids {
+		if _, changed := ft.changed[parent]; ft.changed != nil && !changed {
+			continue
+		}
+		// never modify the slice in place, someone might be iterating it
+		sorted := slices.Clone(children)
+		slices.SortStableFunc(sorted, func(a, b string) int {
+			_, afolder := ft.ids[a]
+			_, bfolder := ft.ids[b]
+			if afolder != bfolder {
+				if afolder {
+					return -1
+				}
+				return 1
+			}
+			uria, oka := ft.values[a]
+			urib, okb := ft.values[b]
+			if !oka || !okb {
+				return CompareNatural(a, b)
+			}
+			return compare(uria, urib)
+		})
+		ft.ids[parent] = sorted
+	}
+}
+
+// Files returns the uris of everything in the tree that is not a folder
+func (ft *Filetreemaps) Files() []fyne.URI {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	files := make([]fyne.URI, 0, len(ft.values))
+	for id, uri := range ft.values {
+		if _, isfolder := ft.ids[id]; !isfolder {
+			files = append(files, uri)
+		}
+	}
+	return files
+}
@@ -0,0 +1,34 @@
+package ft
+
+import "testing"
+
+func TestCompareNatural(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"img2.png", "img10.png", -1},
+		{"img10.png", "img2.png", 1},
+		{"img2.png", "img2.png", 0},
+		{"a.png", "B.png", -1},
+		{"IMG1.png", "img2.png", -1},
+		// leading zeros do not count, but still give a stable order
+		{"img007.png", "img7.png", -1},
+		{"img007.png", "img8.png", -1},
+		{"img", "img1", -1},
+		{"1", "a", -1},
+		{"Img.png", "img.png", -1},
+		{"photo 9 b", "photo 10 a", -1},
+		{"99999999999999999999", "100000000000000000000", -1},
+		{"ä.png", "b.png", 1},
+		{"", "a", -1},
+	}
+	for _, test := range tests {
+		if got := CompareNatural(test.a, test.b); got != test.want {
+			t.Errorf("%q, %q: got %d, want %d", test.a, test.b, got, test.want)
+		}
+		if got := CompareNatural(test.b, test.a); got != -test.want {
+			t.Errorf("%q, %q: got %d, want %d", test.b, test.a, got, -test.want)
+		}
+	}
+}
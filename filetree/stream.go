@@ -0,0 +1,229 @@
+package ft
+
+import (
+	"os"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/data/binding"
+)
+
+// TreeWalk fills a tree in the background. folders show up in the tree
+// as soon as something was found in them, see Changes.
+type TreeWalk struct {
+	tree     *Filetreemaps
+	view     *Filetreemaps // what was published so far
+	progress walkProgress
+	done     chan struct{}
+	took     time.Duration
+}
+
+type walkProgress struct {
+	folders atomic.Int64
+	files   atomic.Int64
+	cancel  chan struct{}
+}
+
+func (wp *walkProgress) cancelled() bool {
+	if wp == nil {
+		return false
+	}
+	select {
+	case <-wp.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+func (wp *walkProgress) walked(files int) {
+	if wp == nil {
+		return
+	}
+	wp.folders.Add(1)
+	wp.files.Add(int64(files))
+}
+
+// treeLink is a folder on the way from the root of a walk to where it is now
+type treeLink struct {
+	id  string
+	uri fyne.URI
+}
+
+// StartFilltree walks dir until it is done or cancelled
+func StartFilltree(dir fyne.ListableURI, filter *Filter) *TreeWalk {
+	tw := &TreeWalk{
+		tree: newFiletreemaps(filter),
+		done: make(chan struct{}),
+	}
+	tw.progress.cancel = make(chan struct{})
+	ft := tw.tree
+	ft.root = dir
+	ft.changed = make(map[string]struct{})
+	ft.addEntryNotLocked(binding.DataTreeRootID, dir.String(), dir, true)
+	ft.markNotLocked(binding.DataTreeRootID)
+	tw.view = newFiletreemaps(filter)
+	tw.view.root = dir
+	if info, err := os.Stat(dir.Path()); err == nil {
+		ft.modtimes[dir.String()] = info.ModTime().UnixNano()
+	}
+
+	go func() {
+		start := time.Now()
+		sem := make(chan struct{}, 200) // chosen by gut feeling
+		ctx := filter.rootContext(dir.Path(), dir.Path(), &ft.Links)
+		ctx.chain = []treeLink{{dir.String(), dir}}
+		ctx.progress = &tw.progress
+		ft.walkdirectory(dir, sem, ctx)
+
+		close(sem)
+		// nobody seems to really know if we need or should do this
+		for range sem {
+		}
+		sem = nil
+
+		// the result is sorted as a whole
+		ft.mu.Lock()
+		ft.changed = nil
+		ft.mu.Unlock()
+
+		tw.took = time.Since(start)
+		close(tw.done)
+	}()
+
+	return tw
+}
+
+// Cancel stops the walk, what was found so far stays in the tree
+func (tw *TreeWalk) Cancel() {
+	select {
+	case <-tw.progress.cancel:
+	default:
+		close(tw.progress.cancel)
+	}
+}
+
+func (tw *TreeWalk) Cancelled() bool {
+	return tw.progress.cancelled()
+}
+
+func (tw *TreeWalk) Done() <-chan struct{} {
+	return tw.done
+}
+
+// Progress returns how many folders were looked into and how many files were found
+func (tw *TreeWalk) Progress() (int64, int64) {
+	return tw.progress.folders.Load(), tw.progress.files.Load()
+}
+
+// Changes are the folders that changed since the last call, with everything
+// that is in them. the walk never writes to it, so it can be sorted before
+// it goes to Publish. the tree itself is only valid after Done.
+func (tw *TreeWalk) Changes() *Filetreemaps {
+	return tw.tree.changes()
+}
+
+// Publish merges the sorted changes into the tree that is shown while
+// walking, the folders that did not change stay as they were
+func (tw *TreeWalk) Publish(changes *Filetreemaps) *Filetreemaps {
+	view := tw.view
+	view.mu.Lock()
+	defer view.mu.Unlock()
+	for folder := range changes.changed {
+		view.ids[folder] = changes.ids[folder]
+	}
+	for id, uri := range changes.values {
+		view.values[id] = uri
+	}
+	return view
+}
+
+// Published is the tree that is shown while walking, empty until the first Publish
+func (tw *TreeWalk) Published() *Filetreemaps {
+	return tw.view
+}
+
+// Result is the finished tree and how long the walk took, only valid after Done
+func (tw *TreeWalk) Result() (*Filetreemaps, float64) {
+	return tw.tree, tw.took.Seconds()
+}
+
+func (ft *Filetreemaps) changes() *Filetreemaps {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	delta := newFiletreemaps(ft.filter)
+	delta.root = ft.root
+	delta.changed = make(map[string]struct{})
+	if ft.changed == nil {
+		// the walk is done
+		return delta
+	}
+	delta.changed, ft.changed = ft.changed, delta.changed
+	// the slices are never modified in place, no need to copy them
+	for folder := range delta.changed {
+		children := ft.ids[folder]
+		delta.ids[folder] = children
+		if uri, ok := ft.values[folder]; ok {
+			delta.values[folder] = uri
+		}
+		for _, child := range children {
+			delta.values[child] = ft.values[child]
+			// sorting has to know which ones are folders
+			if grandchildren, isfolder := ft.ids[child]; isfolder {
+				delta.ids[child] = grandchildren
+			}
+		}
+	}
+	return delta
+}
+
+func (ft *Filetreemaps) markNotLocked(folder string) {
+	if ft.changed != nil {
+		ft.changed[folder] = struct{}{}
+	}
+}
+
+// publishNotLocked adds the files of the last folder in chain, linking in
+// the folders on the way there that are not in the tree yet. this way only
+// folders that have something in them ever make it into the tree.
+func (ft *Filetreemaps) publishNotLocked(chain []treeLink, files []entryFile) {
+	for i := len(chain) - 1; i > 0; i-- {
+		if _, linked := ft.values[chain[i].id]; linked {
+			break
+		}
+		ft.insertFolderNotLocked(chain[i-1].id, chain[i].id, chain[i].uri)
+	}
+
+	folder := chain[len(chain)-1].id
+	// never modify the slice in place, someone might be iterating it
+	children := slices.Clip(ft.ids[folder])
+	if children == nil {
+		children = make([]string, 0, len(files))
+	}
+	for _, file := range files {
+		children = append(children, file.nodeID)
+		ft.values[file.nodeID] = file.uri
+	}
+	ft.ids[folder] = children
+	ft.markNotLocked(folder)
+}
+
+// insertFolderNotLocked puts the folder behind the other folders in parent
+func (ft *Filetreemaps) insertFolderNotLocked(parent, id string, uri fyne.URI) {
+	children := slices.Clone(ft.ids[parent])
+	insertat := 0
+	for i, child := range children {
+		if _, isfolder := ft.ids[child]; isfolder {
+			insertat = i + 1
+		}
+	}
+	ft.ids[parent] = slices.Insert(children, insertat, id)
+	if _, ok := ft.ids[id]; !ok {
+		ft.ids[id] = []string{}
+	}
+	ft.values[id] = uri
+	ft.markNotLocked(parent)
+	ft.markNotLocked(id)
+}
@@ -0,0 +1,124 @@
+package ft
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/storage"
+)
+
+func byNameDescending(a, b fyne.URI) int {
+	return -CompareNatural(a.Name(), b.Name())
+}
+
+// TestSnapshotWhileWalking sorts and reads snapshots like the ui does
+// while the walk is still going, run it with -race
+func TestSnapshotWhileWalking(t *testing.T) {
+	dir := t.TempDir()
+	var names []string
+	for folder := range 20 {
+		for file := range 10 {
+			names = append(names, fmt.Sprintf("f%d/img%d.txt", folder, file))
+		}
+	}
+	writeFiles(t, dir, names...)
+	root := listerFor(t, dir)
+
+	walk := StartFilltree(root, textFilter)
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-walk.Done():
+					return
+				default:
+				}
+				changes := walk.Changes()
+				changes.Sort(byNameDescending)
+				snap := walk.Publish(changes)
+				for _, folder := range snap.Children(root.String()) {
+					snap.URIs(snap.Children(folder))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	tree, _ := walk.Result()
+	folder := root.String() + "/f3"
+	before := tree.Children(folder)
+	if len(before) != 10 {
+		t.Fatalf("f3 has %d files, want 10", len(before))
+	}
+
+	// what was published while walking is sorted, the tree is not
+	if !slices.Equal(tree.Children(folder), before) {
+		t.Error("sorting the changes changed the tree")
+	}
+	// the walk might have finished before the last of it was published
+	sorted := walk.Published().Children(folder)
+	if !slices.IsSortedFunc(sorted, func(a, b string) int { return -CompareNatural(a, b) }) {
+		t.Errorf("the published tree is not sorted: %v", sorted)
+	}
+}
+
+// TestChangesOnlyHaveWhatChanged publishes by hand, like the walk does
+func TestChangesOnlyHaveWhatChanged(t *testing.T) {
+	root := listerFor(t, t.TempDir())
+	walk := StartFilltree(root, textFilter)
+	<-walk.Done()
+
+	tree := walk.tree
+	tree.changed = make(map[string]struct{})
+	folder := func(name string) treeLink {
+		id := root.String() + "/" + name
+		return treeLink{id, storage.NewFileURI(root.Path() + "/" + name)}
+	}
+	file := func(folder treeLink, name string) entryFile {
+		id := folder.id + "/" + name
+		return entryFile{folder.id, id, storage.NewFileURI(folder.uri.Path() + "/" + name)}
+	}
+	rootlink := treeLink{root.String(), root}
+	a, b := folder("a"), folder("b")
+
+	publish := func(chain []treeLink, files ...entryFile) *Filetreemaps {
+		tree.mu.Lock()
+		tree.publishNotLocked(chain, files)
+		tree.mu.Unlock()
+		changes := walk.Changes()
+		changes.Sort(byNameDescending)
+		return changes
+	}
+
+	changes := publish([]treeLink{rootlink, a}, file(a, "img1.txt"), file(a, "img2.txt"))
+	walk.Publish(changes)
+	changes = publish([]treeLink{rootlink, b}, file(b, "img1.txt"))
+	view := walk.Publish(changes)
+	if got := view.Children(a.id); len(got) != 2 || !strings.HasSuffix(got[0], "img2.txt") {
+		t.Errorf("a is %v, want it sorted", got)
+	}
+
+	before := view.Children(a.id)
+	changes = publish([]treeLink{rootlink, b}, file(b, "img2.txt"))
+	if _, ok := changes.changed[a.id]; ok {
+		t.Error("a did not change but is in the changes")
+	}
+	view = walk.Publish(changes)
+	if after := view.Children(a.id); &after[0] != &before[0] {
+		t.Error("a was copied even though it did not change")
+	}
+	if got := view.Children(b.id); len(got) != 2 || !strings.HasSuffix(got[0], "img2.txt") {
+		t.Errorf("b is %v, want it sorted", got)
+	}
+	// folders stay in front of the files
+	if got := view.Children(root.String()); !slices.Equal(got, []string{b.id, a.id}) {
+		t.Errorf("root is %v, want b and a", got)
+	}
+}
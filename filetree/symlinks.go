@@ -0,0 +1,146 @@
+package ft
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"fyne.io/fyne/v2"
+)
+
+// fileID tells if two paths are the same folder, see fileIDOf
+type fileID struct {
+	dev, ino uint64
+	path     string // where there are no inodes
+}
+
+// LinkReport lists the symlinks that could not be followed
+type LinkReport struct {
+	lock   sync.Mutex
+	broken []string
+	loops  []string
+}
+
+func (lr *LinkReport) addBroken(path string) {
+	lr.lock.Lock()
+	lr.broken = append(lr.broken, path)
+	lr.lock.Unlock()
+}
+
+func (lr *LinkReport) addLoop(path string) {
+	lr.lock.Lock()
+	lr.loops = append(lr.loops, path)
+	lr.lock.Unlock()
+}
+
+// Broken returns the paths of the links that point nowhere
+func (lr *LinkReport) Broken() []string {
+	lr.lock.Lock()
+	defer lr.lock.Unlock()
+	return slices.Clone(lr.broken)
+}
+
+// Loops returns the paths of the links that point to a folder above them
+func (lr *LinkReport) Loops() []string {
+	lr.lock.Lock()
+	defer lr.lock.Unlock()
+	return slices.Clone(lr.loops)
+}
+
+// walkContext is what a folder passes down to its subfolders
+type walkContext struct {
+	rules     *ignoreRules
+	links     *LinkReport // nil if symlinks are not followed
+	ancestors []fileID    // the folders above, a link to one of them is a loop
+	chain     []treeLink  // from the root of the walk to the folder
+	progress  *walkProgress
+}
+
+func (wc walkContext) into(id string, uri fyne.URI) walkContext {
+	// the siblings share the slice, so never append in place
+	wc.chain = append(slices.Clip(wc.chain), treeLink{id, uri})
+	return wc
+}
+
+func (wc walkContext) following() bool {
+	return wc.links != nil
+}
+
+func (wc walkContext) child(id fileID) walkContext {
+	if !wc.following() {
+		return wc
+	}
+	// the siblings share the slice, so never append in place
+	wc.ancestors = append(slices.Clip(wc.ancestors), id)
+	return wc
+}
+
+// resolve follows the link if we are told to, the bool is false if the entry has to be skipped
+func (wc walkContext) resolve(path string, info os.FileInfo) (os.FileInfo, bool) {
+	if info.Mode()&os.ModeSymlink == 0 {
+		return info, true
+	}
+	if !wc.following() {
+		return nil, false
+	}
+	target, err := os.Stat(path)
+	if err != nil {
+		wc.links.addBroken(path)
+		return nil, false
+	}
+	return target, true
+}
+
+// isLoop reports a folder that is one of its own ancestors, which can
+// only happen through a symlink and would make us walk forever
+func (wc walkContext) isLoop(path string, id fileID) bool {
+	if !wc.following() || !slices.Contains(wc.ancestors, id) {
+		return false
+	}
+	wc.links.addLoop(path)
+	return true
+}
+
+// loops is isLoop for the folder the context was made for by rootContext
+func (wc walkContext) loops(path string) bool {
+	n := len(wc.ancestors)
+	if n < 2 {
+		return false
+	}
+	parent := walkContext{links: wc.links, ancestors: wc.ancestors[:n-1]}
+	return parent.isLoop(path, wc.ancestors[n-1])
+}
+
+// rootContext sets up walking a folder inside of root, which is root itself for a full walk
+func (f *Filter) rootContext(rootpath, folder string, links *LinkReport) walkContext {
+	wc := walkContext{rules: f.rootRules(rootpath)}
+	if folder != rootpath {
+		// the ignore file of folder itself is read by the walk
+		wc.rules = f.rulesForFolder(rootpath, filepath.Dir(folder))
+	}
+	if f == nil || !f.followlinks {
+		return wc
+	}
+	wc.links = links
+
+	rel, err := filepath.Rel(rootpath, folder)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = "."
+	}
+	dir := rootpath
+	parts := []string{}
+	if rel != "." {
+		parts = strings.Split(rel, string(filepath.Separator))
+	}
+	for i := 0; ; i++ {
+		if info, err := os.Stat(dir); err == nil {
+			wc.ancestors = append(wc.ancestors, fileIDOf(dir, info))
+		}
+		if i >= len(parts) {
+			return wc
+		}
+		dir = filepath.Join(dir, parts[i])
+	}
+}
@@ -0,0 +1,347 @@
+package ft
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/data/binding"
+	"fyne.io/fyne/v2/storage"
+	"github.com/fsnotify/fsnotify"
+
+	afs "github.com/BieHDC/fic/archivefs"
+)
+
+type ChangeKind int
+
+const (
+	ChangeCreated ChangeKind = iota
+	ChangeRemoved
+	ChangeModified
+	// a sidecar like photo.xmp changed, the ID is the one of the sidecar.
+	// those are never in the tree, but what they belong to is.
+	ChangeSidecar
+)
+
+type Change struct {
+	Kind ChangeKind
+	ID   string
+}
+
+type Watcher struct {
+	ft       *Filetreemaps
+	root     string
+	rootpath string
+	fsw      *fsnotify.Watcher
+	onChange func([]Change)
+	done     chan struct{}
+}
+
+// writers like render farms produce a lot of events per file,
+// we collect them for a bit and apply them in one go
+const watcherDebounce = 250 * time.Millisecond
+
+// Watch applies changes on the filesystem to the tree until Close is called.
+// onChange is called from the watcher goroutine after the tree has been updated.
+func (ft *Filetreemaps) Watch(root fyne.ListableURI, onChange func([]Change)) (*Watcher, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Watcher{
+		ft:       ft,
+		root:     root.String(),
+		rootpath: root.Path(),
+		fsw:      fsw,
+		onChange: onChange,
+		done:     make(chan struct{}),
+	}
+
+	ft.mu.Lock()
+	for id := range ft.ids {
+		w.watchNotLocked(id)
+	}
+	ft.mu.Unlock()
+
+	go w.run()
+	return w, nil
+}
+
+func (w *Watcher) Close() {
+	close(w.done)
+	w.fsw.Close()
+}
+
+func (w *Watcher) watchNotLocked(id string) {
+	if id == binding.DataTreeRootID {
+		return
+	}
+	uri, ok := w.ft.values[id]
+	if !ok {
+		return
+	}
+	// we can run out of watches on huge trees, nothing we can do about it
+	w.fsw.Add(uri.Path())
+}
+
+func (w *Watcher) run() {
+	pending := make(map[string]fsnotify.Op)
+	var order []string
+
+	debounce := time.NewTimer(watcherDebounce)
+	debounce.Stop()
+	defer debounce.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+
+		case evt, ok := <-w.fsw.Events:
+			if !ok {
+				return
+			}
+			if _, seen := pending[evt.Name]; !seen {
+				order = append(order, evt.Name)
+			}
+			pending[evt.Name] |= evt.Op
+			debounce.Reset(watcherDebounce)
+
+		case _, ok := <-w.fsw.Errors:
+			if !ok {
+				return
+			}
+
+		case <-debounce.C:
+			w.ft.mu.Lock()
+			var changes []Change
+			for _, path := range order {
+				changes = append(changes, w.applyNotLocked(path, pending[path])...)
+			}
+			w.ft.mu.Unlock()
+
+			clear(pending)
+			order = order[:0]
+
+			if len(changes) > 0 && w.onChange != nil {
+				w.onChange(changes)
+			}
+		}
+	}
+}
+
+// Sync applies what we changed on disk ourselves right away, instead of
+// waiting for the events. those find nothing left to do once they arrive.
+func (w *Watcher) Sync(paths ...string) []Change {
+	w.ft.mu.Lock()
+	defer w.ft.mu.Unlock()
+	var changes []Change
+	for _, path := range paths {
+		changes = append(changes, w.applyNotLocked(path, 0)...)
+	}
+	return changes
+}
+
+func pathToID(path string) string {
+	return storage.NewFileURI(path).String()
+}
+
+// applyNotLocked compares the tree against what is on disk now, which
+// saves us from reasoning about the order rename and remove events come in
+func (w *Watcher) applyNotLocked(path string, op fsnotify.Op) []Change {
+	id := pathToID(path)
+	_, intree := w.ft.values[id]
+	if !intree && afs.IsArchive(path) {
+		id = afs.NewURI(path).String()
+		_, intree = w.ft.values[id]
+	}
+
+	if !intree && isSidecar(path) {
+		return []Change{{Kind: ChangeSidecar, ID: id}}
+	}
+
+	fileinfo, err := os.Lstat(path)
+	if err != nil {
+		if intree {
+			return w.removeNotLocked(id)
+		}
+		return nil
+	}
+	fileinfo, ok := w.ft.filter.rootContext(w.rootpath, w.rootpath, &w.ft.Links).resolve(path, fileinfo)
+	if !ok {
+		// the link broke or we do not follow them
+		if intree {
+			return w.removeNotLocked(id)
+		}
+		return nil
+	}
+	mode := fileinfo.Mode()
+
+	if !intree {
+		if mode.IsDir() {
+			return w.addFolderNotLocked(path)
+		}
+		if mode.IsRegular() {
+			if afs.IsArchive(path) {
+				return w.addArchiveNotLocked(path)
+			}
+			return w.addFileNotLocked(path)
+		}
+		return nil
+	}
+
+	// the file got replaced or written to
+	if mode.IsRegular() && op.Has(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) {
+		if afs.IsArchiveURI(w.ft.values[id]) {
+			// the whole content might be different now
+			return append(w.removeNotLocked(id), w.addArchiveNotLocked(path)...)
+		}
+		return []Change{{Kind: ChangeModified, ID: id}}
+	}
+	return nil
+}
+
+func isSidecar(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".xmp")
+}
+
+// the tree does not contain empty folders, so the parent of a new entry
+// might be missing too, in which case we add the parent instead
+func (w *Watcher) parentInTreeNotLocked(path string) (string, bool) {
+	parentid := pathToID(filepath.Dir(path))
+	_, ok := w.ft.values[parentid]
+	return parentid, ok
+}
+
+func (w *Watcher) insideRoot(path string) bool {
+	rel, err := filepath.Rel(w.rootpath, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// skips checks a new entry against the ignore rules, the ignore
+// files themselves are read again as they might have changed
+func (w *Watcher) skips(path string, isdir bool) bool {
+	rules := w.ft.filter.rulesForFolder(w.rootpath, filepath.Dir(path))
+	return w.ft.filter.skips(rules, storage.NewFileURI(path), isdir)
+}
+
+func (w *Watcher) addFileNotLocked(path string) []Change {
+	if !w.insideRoot(path) || w.skips(path, false) || !w.ft.filter.Allows(storage.NewFileURI(path)) {
+		return nil
+	}
+	parentid, ok := w.parentInTreeNotLocked(path)
+	if !ok {
+		return w.addFolderNotLocked(filepath.Dir(path))
+	}
+
+	id := pathToID(path)
+	w.ft.addEntryNotLocked(parentid, id, storage.NewFileURI(path), false)
+	return []Change{{Kind: ChangeCreated, ID: id}}
+}
+
+func (w *Watcher) addFolderNotLocked(path string) []Change {
+	if !w.insideRoot(path) || w.skips(path, true) {
+		return nil
+	}
+	lu, err := storage.ListerForURI(storage.NewFileURI(path))
+	if lu == nil || err != nil {
+		return nil
+	}
+	// freshly created folders are empty, we still want to know once files arrive
+	w.fsw.Add(path)
+	return w.addListableNotLocked(path, lu)
+}
+
+func (w *Watcher) addArchiveNotLocked(path string) []Change {
+	if !w.insideRoot(path) || w.skips(path, false) {
+		return nil
+	}
+	lu, err := afs.RootURI(path)
+	if lu == nil || err != nil {
+		return nil
+	}
+	return w.addListableNotLocked(path, lu)
+}
+
+func (w *Watcher) addListableNotLocked(path string, lu fyne.ListableURI) []Change {
+	if !w.insideRoot(path) {
+		return nil
+	}
+	parentid, ok := w.parentInTreeNotLocked(path)
+	if !ok {
+		return w.addFolderNotLocked(filepath.Dir(path))
+	}
+
+	id := lu.String()
+
+	ctx := w.ft.filter.rootContext(w.rootpath, path, &w.ft.Links)
+	if ctx.loops(path) {
+		return nil
+	}
+	ctx.chain = []treeLink{{id, lu}}
+	cft := newFiletreemaps(w.ft.filter)
+	sem := make(chan struct{}, 200)
+	cft.walkdirectory(lu, sem, ctx)
+	close(sem)
+	w.ft.mergeModtimesNotLocked(cft)
+	if info, err := os.Stat(path); err == nil {
+		w.ft.modtimes[id] = info.ModTime().UnixNano()
+	}
+	if len(cft.ids[id]) == 0 {
+		// do not add empty folders
+		return nil
+	}
+	w.ft.mergeNotLocked(id, cft, lu)
+	// folders go in front of the files
+	w.ft.insertFolderNotLocked(parentid, id, lu)
+
+	changes := []Change{{Kind: ChangeCreated, ID: id}}
+	for folder, files := range cft.ids {
+		w.watchNotLocked(folder)
+		for _, file := range files {
+			changes = append(changes, Change{Kind: ChangeCreated, ID: file})
+		}
+	}
+	return changes
+}
+
+func (w *Watcher) removeNotLocked(id string) []Change {
+	if id == w.root {
+		return nil
+	}
+	uri := w.ft.values[id]
+	changes := w.removeSubtreeNotLocked(id)
+
+	parentid, ok := w.parentInTreeNotLocked(uri.Path())
+	if !ok {
+		return changes
+	}
+	// never modify the slice in place, someone might be iterating it
+	siblings := slices.DeleteFunc(slices.Clone(w.ft.ids[parentid]), func(s string) bool {
+		return s == id
+	})
+	w.ft.ids[parentid] = siblings
+	if len(siblings) == 0 && parentid != w.root {
+		// the tree never shows empty folders
+		changes = append(changes, w.removeNotLocked(parentid)...)
+	}
+	return changes
+}
+
+func (w *Watcher) removeSubtreeNotLocked(id string) []Change {
+	changes := []Change{{Kind: ChangeRemoved, ID: id}}
+	if children, isfolder := w.ft.ids[id]; isfolder {
+		for _, child := range children {
+			changes = append(changes, w.removeSubtreeNotLocked(child)...)
+		}
+		delete(w.ft.ids, id)
+		w.fsw.Remove(w.ft.values[id].Path())
+	}
+	delete(w.ft.values, id)
+	delete(w.ft.modtimes, id)
+	return changes
+}
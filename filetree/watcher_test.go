@@ -0,0 +1,143 @@
+package ft
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/test"
+)
+
+func TestMain(m *testing.M) {
+	// registers the file repository the storage functions need
+	test.NewApp()
+	os.Exit(m.Run())
+}
+
+// textFilter lets the tests use plain files instead of images
+var textFilter = NewFilter("txt", "", "", false, false)
+
+func writeFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func listerFor(t *testing.T, dir string) fyne.ListableURI {
+	t.Helper()
+	lu, err := storage.ListerForURI(storage.NewFileURI(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lu
+}
+
+// TestWatcherConcurrentReaders reads the tree like the ui does while the
+// watcher applies changes, run it with -race
+func TestWatcherConcurrentReaders(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, "a.txt", "b.txt", "sub/c.txt")
+	root := listerFor(t, dir)
+	tree, _ := Fillfiletree("", root, root.String(), textFilter)
+
+	changed := make(chan []Change, 16)
+	w, err := tree.Watch(root, func(changes []Change) { changed <- changes })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				for _, id := range tree.Children(root.String()) {
+					tree.URI(id)
+					tree.IsFolder(id)
+				}
+				tree.Each(func(string, fyne.URI) {})
+			}
+		}()
+	}
+
+	for i := 0; i < 20; i++ {
+		writeFiles(t, dir, "new.txt", "sub/more.txt")
+		w.Sync(filepath.Join(dir, "new.txt"))
+		os.Remove(filepath.Join(dir, "new.txt"))
+		os.RemoveAll(filepath.Join(dir, "sub"))
+		w.Sync(filepath.Join(dir, "new.txt"), filepath.Join(dir, "sub"))
+	}
+	writeFiles(t, dir, "last.txt")
+
+	deadline := time.After(5 * time.Second)
+	lastid := storage.NewFileURI(filepath.Join(dir, "last.txt")).String()
+	for {
+		if slices.Contains(tree.Children(root.String()), lastid) {
+			break
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			t.Fatal("the watcher never added last.txt")
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if _, ok := tree.URI(storage.NewFileURI(filepath.Join(dir, "sub")).String()); ok {
+		t.Error("the removed folder is still in the tree")
+	}
+}
+
+func TestWatcherSidecars(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, "a.txt")
+	root := listerFor(t, dir)
+	tree, _ := Fillfiletree("", root, root.String(), textFilter)
+
+	changed := make(chan []Change, 16)
+	w, err := tree.Watch(root, func(changes []Change) { changed <- changes })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	writeFiles(t, dir, "a.XMP")
+	sidecar := storage.NewFileURI(filepath.Join(dir, "a.XMP")).String()
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case changes := <-changed:
+			for _, change := range changes {
+				if change.Kind == ChangeSidecar && change.ID == sidecar {
+					if _, ok := tree.URI(sidecar); ok {
+						t.Error("the sidecar got into the tree")
+					}
+					return
+				}
+			}
+		case <-deadline:
+			t.Fatal("the sidecar change never came")
+		}
+	}
+}
@@ -1,7 +1,8 @@
 package gp
 
 import (
-	"sync/atomic"
+	"sync"
+	"time"
 )
 
 type gplayerAction int
@@ -15,24 +16,62 @@ const (
 	//
 	GPlayerConfig_SetMaxIndex
 	GPlayerConfig_Direction
+	GPlayerConfig_SetCursor
+	GPlayerConfig_Repeat
+	GPlayerConfig_DropFrames
 	//
 	GPlayerAction_Next
 	GPlayerAction_Previous
 	GPlayerAction_Seek
-	//
-	gplayerinternal_unblock
 )
 
+// what happens when playback runs off the end of the list
+type RepeatPolicy int
+
+const (
+	RepeatForever RepeatPolicy = iota
+	RepeatCount                // play the whole list n times, then stop
+	RepeatOnce
+	RepeatPingPong // bounce between both ends forever
+)
+
+// a single run of the play goroutine, a new one is made on every play
+// so a stopping goroutine can never pick up the state of the next one
+type playback struct {
+	stop bool          // guarded by the player lock
+	wake chan struct{} // closed on stop to cut the wait for the next frame short
+	done chan struct{}
+}
+
 type GPlayer struct {
+	// everything below is guarded by lock, the callbacks
+	// are always called without holding it, so they are
+	// free to send events back to the player
+	lock          sync.Mutex
+	wakeup        *sync.Cond // signalled on unpause and stop
+	onFrame       func(int, bool)
+	onEnded       func()
+	frameDuration func(int) time.Duration
+	//
 	index     int
-	onFrame   func(int, bool)
 	direction int
-	//
 	maxindex  int
-	isrunning atomic.Bool
+	//
+	repeat      RepeatPolicy
+	repeatcount int
+	dropframes  bool
+	// reset on play
+	passes   int
+	bounce   int // 1 or -1 for ping pong
+	ended    bool
+	deadline time.Time // when the next frame is due, zero means now
+	dropped  int
+	// reset on play and resume
+	measurestart time.Time
+	shown        int
 	// reset on stop
-	paused    bool
-	eventchan chan gplayerAction
+	paused  bool
+	running *playback
 }
 
 // for the callback:
@@ -43,59 +82,160 @@ type GPlayer struct {
 // with the right speed
 func NewPlayer(onFrame func(int, bool)) *GPlayer {
 	gp := &GPlayer{}
+	gp.wakeup = sync.NewCond(&gp.lock)
 	gp.onFrame = onFrame
 	gp.direction = 1
+	gp.bounce = 1
 	return gp
 }
 
-func (gp *GPlayer) ensureUnblocked() {
-	select {
-	case gp.eventchan <- gplayerinternal_unblock:
-	default:
+// frameDuration says how long a frame stays on screen, the next one is
+// scheduled from when the previous one was due and not from when the
+// callback returned, so the time spent displaying is not added on top.
+// it is called with the player locked, so it must not send events.
+// without it the onFrame callback has to do the waiting itself.
+func (gp *GPlayer) SetFrameDuration(frameDuration func(int) time.Duration) {
+	gp.lock.Lock()
+	gp.frameDuration = frameDuration
+	gp.lock.Unlock()
+}
+
+// must be called with the lock held
+func (gp *GPlayer) durationOf(index int) time.Duration {
+	if gp.frameDuration == nil {
+		return 0
 	}
+	return gp.frameDuration(index)
+}
+
+// onEnded is called from the player when it stopped
+// by itself because the repeat policy ran out
+func (gp *GPlayer) SetOnEnded(onEnded func()) {
+	gp.lock.Lock()
+	gp.onEnded = onEnded
+	gp.lock.Unlock()
 }
 
-func (gp *GPlayer) play() {
+func (gp *GPlayer) play(pb *playback, showfirst bool) {
+	defer close(pb.done)
+
+	if showfirst {
+		gp.lock.Lock()
+		index := gp.index
+		gp.lock.Unlock()
+		gp.onFrame(index, true)
+	}
+
 	for {
-		select {
-		case evttype := <-gp.eventchan:
-			switch evttype {
-			case GPlayerAction_Stop:
-				gp.paused = false
-				gp.index = 0
-				gp.onFrame(gp.index, false)
-				gp.eventchan <- GPlayerAction_Stop //signal we are done
-				return
-			case GPlayerAction_Pause:
-				gp.paused = true
-				<-gp.eventchan
-			case GPlayerAction_Play:
-				gp.paused = false
-			case gplayerinternal_unblock:
-				//ignore
-			default:
-				println(evttype)
-				panic("unexpected event")
+		gp.lock.Lock()
+		for gp.paused && !pb.stop {
+			gp.wakeup.Wait()
+		}
+		if pb.stop {
+			gp.lock.Unlock()
+			return
+		}
+		if gp.deadline.IsZero() {
+			gp.deadline = time.Now()
+		}
+		ok := gp.advance()
+		// skip frames whose whole slot is already over
+		for ok && gp.dropframes {
+			duration := gp.durationOf(gp.index)
+			if duration <= 0 || !time.Now().After(gp.deadline.Add(duration)) {
+				break
+			}
+			gp.deadline = gp.deadline.Add(duration)
+			gp.dropped++
+			ok = gp.advance()
+		}
+		if !ok {
+			gp.running = nil
+			gp.paused = false
+			gp.ended = true
+			onEnded := gp.onEnded
+			gp.lock.Unlock()
+			if onEnded != nil {
+				onEnded()
 			}
+			return
+		}
+		index := gp.index
+		gp.lock.Unlock()
+
+		gp.onFrame(index, true)
+
+		gp.lock.Lock()
+		gp.shown++
+		gp.deadline = gp.deadline.Add(gp.durationOf(index))
+		now := time.Now()
+		if !gp.dropframes && now.After(gp.deadline) {
+			// we are late, but rushing the next frames to catch
+			// up would look worse than just continuing from here
+			gp.deadline = now
+		}
+		wait := gp.deadline.Sub(now)
+		gp.lock.Unlock()
 
-		default:
-			gp.move(gp.direction)
-			gp.onFrame(gp.index, true)
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-pb.wake:
+				timer.Stop()
+			}
 		}
 	}
 }
 
-// fixme technically wrong, doesnt correctly overflow
-func (gp *GPlayer) move(offset int) {
-	gp.index += offset
+// advance moves one step in the play direction and applies the
+// repeat policy at the ends, returns false if playback is over
+// must be called with the lock held
+func (gp *GPlayer) advance() bool {
+	if gp.maxindex <= 0 {
+		// the list was emptied while we played
+		return false
+	}
 
-	// roll over
-	if gp.index < 0 {
-		gp.index = gp.maxindex - 1
+	direction := gp.direction * gp.bounce
+	next := gp.index + direction
+	if next >= 0 && next < gp.maxindex {
+		gp.index = next
+		return true
 	}
-	if gp.index >= gp.maxindex {
+
+	switch gp.repeat {
+	case RepeatOnce:
+		return false
+	case RepeatCount:
+		gp.passes++
+		if gp.passes >= gp.repeatcount {
+			return false
+		}
+	case RepeatPingPong:
+		gp.bounce = -gp.bounce
+		gp.index = max(min(gp.index-direction, gp.maxindex-1), 0)
+		return true
+	}
+	gp.move(direction)
+	return true
+}
+
+// move wraps around for any offset, must be called with the lock held
+func (gp *GPlayer) move(offset int) {
+	if gp.maxindex <= 0 {
 		gp.index = 0
+		return
+	}
+	gp.index = ((gp.index+offset)%gp.maxindex + gp.maxindex) % gp.maxindex
+}
+
+// the index playback starts from after it ended
+func (gp *GPlayer) startIndex() int {
+	if gp.direction < 0 {
+		return max(gp.maxindex-1, 0)
 	}
+	return 0
 }
 
 type GPlayerStatus int
@@ -112,73 +252,153 @@ const (
 )
 
 func (gp *GPlayer) Cursor() int {
+	gp.lock.Lock()
+	defer gp.lock.Unlock()
 	return gp.index
 }
 
-// fixme technically this thing needs to be mutexed to safety, but unless
-// someone makes tests or misuses it, i am not going to spend time on that,
-// unless it happens to me
-// if the issue comes, we need to stop the player, do the sets, then resume
-// or go the cheapest way and mutexlock the play(), do the sets, and unlock them
-// how expensive even is taking locks for fun?
+// Throughput returns the measured frames per second since playback
+// started or resumed and how many frames were dropped to keep up,
+// there is nothing to measure while stopped or paused
+func (gp *GPlayer) Throughput() (float64, int) {
+	gp.lock.Lock()
+	defer gp.lock.Unlock()
+	if gp.running == nil || gp.paused || gp.shown < 2 {
+		return 0, gp.dropped
+	}
+	elapsed := time.Since(gp.measurestart).Seconds()
+	if elapsed <= 0 {
+		return 0, gp.dropped
+	}
+	return float64(gp.shown) / elapsed, gp.dropped
+}
+
+// must be called with the lock held
+func (gp *GPlayer) startNotLocked() GPlayerStatus {
+	if gp.running != nil {
+		return GplayerStatus_Confused
+	}
+	if gp.maxindex <= 0 {
+		return GplayerStatus_EmptyPlaylist
+	}
+	gp.passes = 0
+	gp.bounce = 1
+	gp.paused = false
+	gp.deadline = time.Time{}
+	gp.dropped = 0
+	gp.measurestart = time.Now()
+	gp.shown = 0
+	restart := gp.ended
+	if restart {
+		// start over instead of ending again right away
+		gp.ended = false
+		gp.index = gp.startIndex()
+	}
+	pb := &playback{wake: make(chan struct{}), done: make(chan struct{})}
+	gp.running = pb
+	go gp.play(pb, restart)
+	return GPlayerStatus_Playing
+}
+
+// must be called with the lock held
+func (gp *GPlayer) pauseNotLocked() GPlayerStatus {
+	if gp.running == nil {
+		return GplayerStatus_Confused
+	}
+	gp.paused = !gp.paused
+	if gp.paused {
+		return GPlayerStatus_Paused
+	}
+	// the time we were paused is not part of the schedule
+	gp.deadline = time.Time{}
+	gp.measurestart = time.Now()
+	gp.shown = 0
+	gp.wakeup.Broadcast()
+	return GPlayerStatus_Playing
+}
+
+// stop waits until the goroutine is gone, so it must not
+// be called from inside the onFrame callback
+func (gp *GPlayer) stop() GPlayerStatus {
+	gp.lock.Lock()
+	pb := gp.running
+	if pb == nil {
+		gp.lock.Unlock()
+		return GplayerStatus_Confused
+	}
+	pb.stop = true
+	close(pb.wake)
+	gp.running = nil
+	gp.paused = false
+	gp.wakeup.Broadcast()
+	gp.lock.Unlock()
+
+	<-pb.done
+
+	gp.lock.Lock()
+	gp.index = 0
+	gp.lock.Unlock()
+	gp.onFrame(0, false)
+	return GPlayerStatus_Stopped
+}
+
+// showFrameAndUnlock displays the cursor after a jump, must be called with the
+// lock held, it returns unlocked because onFrame may call us again
+func (gp *GPlayer) showFrameAndUnlock() {
+	index := gp.index
+	gp.lock.Unlock()
+	gp.onFrame(index, false)
+}
+
+// every event is safe to send from any goroutine, also while playing
 func (gp *GPlayer) SendEvent(action gplayerAction, args ...int) GPlayerStatus {
 	switch action {
 	case GPlayerAction_Stop:
-		if gp.isrunning.CompareAndSwap(true, false) {
-			gp.ensureUnblocked()
-			gp.eventchan <- GPlayerAction_Stop
-			<-gp.eventchan
-			close(gp.eventchan)
-			gp.eventchan = nil
-			return GPlayerStatus_Stopped
-		}
+		return gp.stop()
 
 	case GPlayerAction_Pause:
-		if gp.isrunning.Load() {
-			if !gp.paused {
-				gp.eventchan <- GPlayerAction_Pause
-				return GPlayerStatus_Paused
-			} else {
-				gp.ensureUnblocked()
-				gp.eventchan <- GPlayerAction_Play
-				return GPlayerStatus_Playing
-			}
-		}
+		gp.lock.Lock()
+		defer gp.lock.Unlock()
+		return gp.pauseNotLocked()
 
 	case GPlayerAction_Play:
-		if gp.isrunning.CompareAndSwap(false, true) {
-			if gp.maxindex <= 0 {
-				return GplayerStatus_EmptyPlaylist
-			}
-			gp.eventchan = make(chan gplayerAction)
-			go gp.play()
-			return GPlayerStatus_Playing
-		}
+		gp.lock.Lock()
+		defer gp.lock.Unlock()
+		return gp.startNotLocked()
 
 	case GPlayerAction_Playpause:
-		if gp.isrunning.Load() {
-			return gp.SendEvent(GPlayerAction_Pause)
-		} else {
-			return gp.SendEvent(GPlayerAction_Play)
+		gp.lock.Lock()
+		defer gp.lock.Unlock()
+		if gp.running != nil {
+			return gp.pauseNotLocked()
 		}
+		return gp.startNotLocked()
 
 	case GPlayerAction_Playstop:
-		if gp.isrunning.Load() {
-			return gp.SendEvent(GPlayerAction_Stop)
-		} else {
-			return gp.SendEvent(GPlayerAction_Play)
+		gp.lock.Lock()
+		if gp.running != nil {
+			gp.lock.Unlock()
+			return gp.stop()
 		}
+		defer gp.lock.Unlock()
+		return gp.startNotLocked()
 
 	case GPlayerConfig_SetMaxIndex:
 		lenargs := len(args)
 		if lenargs != 1 {
 			return GPlayerStatus_ArgCountMismatch
 		}
-		gp.maxindex = args[0]
-		if gp.index > gp.maxindex {
+		gp.lock.Lock()
+		gp.maxindex = max(args[0], 0)
+		if gp.index >= gp.maxindex && gp.maxindex > 0 {
+			gp.index = 0
+			gp.showFrameAndUnlock()
+			return GPlayerStatus_OK
+		}
+		if gp.maxindex == 0 {
 			gp.index = 0
-			gp.onFrame(gp.index, false)
 		}
+		gp.lock.Unlock()
 		return GPlayerStatus_OK
 
 	case GPlayerConfig_Direction:
@@ -186,7 +406,46 @@ func (gp *GPlayer) SendEvent(action gplayerAction, args ...int) GPlayerStatus {
 		if lenargs != 1 {
 			return GPlayerStatus_ArgCountMismatch
 		}
+		gp.lock.Lock()
 		gp.direction = args[0]
+		gp.lock.Unlock()
+		return GPlayerStatus_OK
+
+	case GPlayerConfig_Repeat:
+		// policy and for RepeatCount how often
+		lenargs := len(args)
+		if lenargs < 1 || lenargs > 2 {
+			return GPlayerStatus_ArgCountMismatch
+		}
+		gp.lock.Lock()
+		gp.repeat = RepeatPolicy(args[0])
+		gp.repeatcount = 1
+		if lenargs == 2 {
+			gp.repeatcount = max(args[1], 1)
+		}
+		gp.lock.Unlock()
+		return GPlayerStatus_OK
+
+	case GPlayerConfig_DropFrames:
+		// 1 to drop frames when we fall behind, 0 to show all of them late
+		lenargs := len(args)
+		if lenargs != 1 {
+			return GPlayerStatus_ArgCountMismatch
+		}
+		gp.lock.Lock()
+		gp.dropframes = args[0] != 0
+		gp.lock.Unlock()
+		return GPlayerStatus_OK
+
+	case GPlayerConfig_SetCursor:
+		// like seek, but without displaying the frame
+		lenargs := len(args)
+		if lenargs != 1 {
+			return GPlayerStatus_ArgCountMismatch
+		}
+		gp.lock.Lock()
+		gp.index = max(min(args[0], gp.maxindex-1), 0)
+		gp.lock.Unlock()
 		return GPlayerStatus_OK
 
 	case GPlayerAction_Seek:
@@ -194,23 +453,38 @@ func (gp *GPlayer) SendEvent(action gplayerAction, args ...int) GPlayerStatus {
 		if lenargs != 1 {
 			return GPlayerStatus_ArgCountMismatch
 		}
-		if gp.index == args[0] {
-			gp.onFrame(gp.index, false)
-			return GPlayerStatus_OK
+		gp.lock.Lock()
+		if gp.maxindex <= 0 {
+			gp.lock.Unlock()
+			return GplayerStatus_EmptyPlaylist
+		}
+		if gp.index != args[0] {
+			gp.ended = false
 		}
-		gp.index = args[0]
-		// at least 0, at most gp.maxindex
-		gp.index = max(min(gp.index, gp.maxindex), 0)
-		gp.onFrame(gp.index, false)
+		// at least 0, at most the last one
+		gp.index = max(min(args[0], gp.maxindex-1), 0)
+		gp.showFrameAndUnlock()
 		return GPlayerStatus_OK
 
 	case GPlayerAction_Previous:
+		gp.lock.Lock()
+		if gp.maxindex <= 0 {
+			gp.lock.Unlock()
+			return GplayerStatus_EmptyPlaylist
+		}
+		gp.ended = false
 		gp.move(-1)
-		gp.onFrame(gp.index, false)
+		gp.showFrameAndUnlock()
 		return GPlayerStatus_OK
 	case GPlayerAction_Next:
+		gp.lock.Lock()
+		if gp.maxindex <= 0 {
+			gp.lock.Unlock()
+			return GplayerStatus_EmptyPlaylist
+		}
+		gp.ended = false
 		gp.move(1)
-		gp.onFrame(gp.index, false)
+		gp.showFrameAndUnlock()
 		return GPlayerStatus_OK
 
 	default:
@@ -0,0 +1,180 @@
+package gp
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// within fails the test if fn does not return in time, which
+// for the player almost always means it deadlocked
+func within(t *testing.T, d time.Duration, what string, fn func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(d):
+		t.Fatalf("%s did not return within %v", what, d)
+	}
+}
+
+const maxTestIndex = 50
+
+// TestConcurrentEvents sends every event from many goroutines at once,
+// run it with -race
+func TestConcurrentEvents(t *testing.T) {
+	var frames, badframes atomic.Int64
+	var player *GPlayer
+	player = NewPlayer(func(index int, block bool) {
+		frames.Add(1)
+		if index < 0 || index >= maxTestIndex {
+			badframes.Add(1)
+		}
+		if block && index%7 == 0 {
+			// the callbacks are allowed to send events back
+			player.SendEvent(GPlayerConfig_SetCursor, index+1)
+		}
+	})
+	player.SetFrameDuration(func(int) time.Duration { return 100 * time.Microsecond })
+	player.SetOnEnded(func() {})
+	player.SendEvent(GPlayerConfig_SetMaxIndex, 10)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for i := 0; i < 400; i++ {
+				switch rng.Intn(14) {
+				case 0:
+					player.SendEvent(GPlayerAction_Play)
+				case 1:
+					player.SendEvent(GPlayerAction_Pause)
+				case 2:
+					player.SendEvent(GPlayerAction_Playpause)
+				case 3:
+					player.SendEvent(GPlayerAction_Playstop)
+				case 4:
+					player.SendEvent(GPlayerAction_Stop)
+				case 5:
+					player.SendEvent(GPlayerAction_Seek, rng.Intn(maxTestIndex))
+				case 6:
+					player.SendEvent(GPlayerAction_Next)
+				case 7:
+					player.SendEvent(GPlayerAction_Previous)
+				case 8:
+					player.SendEvent(GPlayerConfig_SetMaxIndex, rng.Intn(maxTestIndex))
+				case 9:
+					player.SendEvent(GPlayerConfig_SetCursor, rng.Intn(maxTestIndex))
+				case 10:
+					player.SendEvent(GPlayerConfig_Direction, []int{-1, 1}[rng.Intn(2)])
+				case 11:
+					player.SendEvent(GPlayerConfig_Repeat, rng.Intn(4), 1+rng.Intn(3))
+				case 12:
+					player.SendEvent(GPlayerConfig_DropFrames, rng.Intn(2))
+				case 13:
+					player.Cursor()
+					player.Throughput()
+				}
+			}
+		}(int64(g))
+	}
+	within(t, 20*time.Second, "sending the events", wg.Wait)
+
+	within(t, 5*time.Second, "the final stop", func() {
+		player.SendEvent(GPlayerConfig_SetMaxIndex, 10)
+		player.SendEvent(GPlayerAction_Play)
+		player.SendEvent(GPlayerAction_Stop)
+	})
+	if badframes.Load() > 0 {
+		t.Errorf("%d of %d frames were out of bounds", badframes.Load(), frames.Load())
+	}
+
+	// nothing plays on after a stop
+	after := frames.Load()
+	time.Sleep(20 * time.Millisecond)
+	if frames.Load() != after {
+		t.Errorf("%d frames were shown after stopping", frames.Load()-after)
+	}
+}
+
+func TestStopCutsWaitShort(t *testing.T) {
+	player := NewPlayer(func(int, bool) {})
+	player.SetFrameDuration(func(int) time.Duration { return time.Hour })
+	player.SendEvent(GPlayerConfig_SetMaxIndex, 3)
+	for i := 0; i < 50; i++ {
+		if status := player.SendEvent(GPlayerAction_Play); status != GPlayerStatus_Playing {
+			t.Fatalf("play returned %v", status)
+		}
+		within(t, time.Second, "stop while waiting for the next frame", func() {
+			player.SendEvent(GPlayerAction_Stop)
+		})
+	}
+}
+
+func TestStopWhilePaused(t *testing.T) {
+	player := NewPlayer(func(int, bool) {})
+	player.SetFrameDuration(func(int) time.Duration { return time.Millisecond })
+	player.SendEvent(GPlayerConfig_SetMaxIndex, 3)
+	player.SendEvent(GPlayerAction_Play)
+	if status := player.SendEvent(GPlayerAction_Pause); status != GPlayerStatus_Paused {
+		t.Fatalf("pause returned %v", status)
+	}
+	within(t, time.Second, "stop while paused", func() {
+		player.SendEvent(GPlayerAction_Stop)
+	})
+}
+
+func TestStopWhileShowingSlowFrame(t *testing.T) {
+	player := NewPlayer(func(_ int, block bool) {
+		if block {
+			time.Sleep(5 * time.Millisecond)
+		}
+	})
+	player.SendEvent(GPlayerConfig_SetMaxIndex, 3)
+	for i := 0; i < 20; i++ {
+		player.SendEvent(GPlayerAction_Play)
+		time.Sleep(time.Millisecond)
+		within(t, time.Second, "stop while a frame is shown", func() {
+			player.SendEvent(GPlayerAction_Stop)
+		})
+	}
+}
+
+func TestRepeatOnceEnds(t *testing.T) {
+	var lock sync.Mutex
+	var shown []int
+	ended := make(chan struct{})
+	player := NewPlayer(func(index int, block bool) {
+		if block {
+			lock.Lock()
+			shown = append(shown, index)
+			lock.Unlock()
+		}
+	})
+	player.SetOnEnded(func() { close(ended) })
+	player.SendEvent(GPlayerConfig_SetMaxIndex, 3)
+	player.SendEvent(GPlayerConfig_Repeat, int(RepeatOnce))
+	player.SendEvent(GPlayerAction_Play)
+
+	select {
+	case <-ended:
+	case <-time.After(time.Second):
+		t.Fatal("playback never ended")
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(shown) != 2 || shown[0] != 1 || shown[1] != 2 {
+		t.Errorf("shown %v, want [1 2]", shown)
+	}
+	if status := player.SendEvent(GPlayerAction_Stop); status != GplayerStatus_Confused {
+		t.Errorf("stop after the end returned %v, it was not playing anymore", status)
+	}
+}
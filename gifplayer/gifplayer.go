@@ -1,6 +1,8 @@
 package gp
 
 import (
+	"math"
+	"sync/atomic"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -13,7 +15,8 @@ import (
 	gp "github.com/BieHDC/fic/genericplayer"
 )
 
-func NewGifPlayer(frames []*canvas.Image, delays []int) *GifPlayer {
+// loopcount is how often the animation is played, 0 is forever
+func NewGifPlayer(frames []*canvas.Image, delays []int, loopcount int) *GifPlayer {
 	g := &GifPlayer{}
 	g.ExtendBaseWidget(g)
 
@@ -22,19 +25,28 @@ func NewGifPlayer(frames []*canvas.Image, delays []int) *GifPlayer {
 	g.framedisplay.ScaleMode = canvas.ImageScaleSmooth
 	g.content = container.NewStack(g.framedisplay)
 
-	g.speedmodifier = 1.0
-	g.player = gp.NewPlayer(func(index int, block bool) {
+	g.SetSpeedModifier(1.0)
+	g.player = gp.NewPlayer(func(index int, _ bool) {
 		if g.onFrame != nil {
 			g.onFrame(index)
 		}
 		g.setContent(g.frames[index])
-		if block {
-			// *10000000 -> nanosecond to 100th of a gif second
-			time.Sleep(time.Duration(float64(g.delays[index]) * 10000000.0 * g.speedmodifier))
+	})
+	g.player.SetFrameDuration(func(index int) time.Duration {
+		speedmodifier := math.Float64frombits(g.speedmodifier.Load())
+		// *10000000 -> nanosecond to 100th of a gif second
+		return time.Duration(float64(g.delays[index]) * 10000000.0 * speedmodifier)
+	})
+
+	g.player.SetOnEnded(func() {
+		if g.onEnded != nil {
+			g.onEnded()
 		}
 	})
 
 	g.setFrames(frames, delays)
+	g.loopcount = loopcount
+	g.SetLoopCount(loopcount)
 
 	return g
 }
@@ -91,7 +103,8 @@ func (g *GifPlayer) SetDirection(dir int) bool {
 }
 
 func (g *GifPlayer) SetSpeedModifier(speed float64) {
-	g.speedmodifier = 1 / speed
+	// read from the player while it plays
+	g.speedmodifier.Store(math.Float64bits(1 / speed))
 }
 
 func (g *GifPlayer) GetSeekerBounds() (int, int) {
@@ -106,17 +119,36 @@ func (g *GifPlayer) SetOnFrame(onFrame func(int)) {
 	g.onFrame = onFrame
 }
 
+// called when the animation stopped by itself
+func (g *GifPlayer) SetOnEnded(onEnded func()) {
+	g.onEnded = onEnded
+}
+
+// SetLoopCount sets how often the animation is played, 0 is forever
+func (g *GifPlayer) SetLoopCount(loopcount int) bool {
+	if loopcount <= 0 {
+		return g.SetRepeat(gp.RepeatForever, 0)
+	}
+	return g.SetRepeat(gp.RepeatCount, loopcount)
+}
+
+func (g *GifPlayer) SetRepeat(policy gp.RepeatPolicy, count int) bool {
+	return g.player.SendEvent(gp.GPlayerConfig_Repeat, int(policy), count) == gp.GPlayerStatus_OK
+}
+
 type GifPlayer struct {
 	widget.BaseWidget
 	//
-	length int
-	frames []*canvas.Image
-	delays []int
+	length    int
+	frames    []*canvas.Image
+	delays    []int
+	loopcount int // what the file wants
 	//
 	framedisplay  *canvas.Image
 	onFrame       func(int)
+	onEnded       func()
 	content       *fyne.Container
-	speedmodifier float64
+	speedmodifier atomic.Uint64 // float64 bits
 	player        *gp.GPlayer
 }
 
@@ -126,7 +158,7 @@ type minPlayer struct {
 	playstop *widget.Button
 }
 
-func NewMinimalGifPlayer(frames []*canvas.Image, delays []int) *minPlayer {
+func NewMinimalGifPlayer(frames []*canvas.Image, delays []int, loopcount int) *minPlayer {
 	var lgp *GifPlayer
 	var playstop *widget.Button
 	playstop = widget.NewButtonWithIcon("Play", theme.MediaPlayIcon(), func() {
@@ -140,7 +172,11 @@ func NewMinimalGifPlayer(frames []*canvas.Image, delays []int) *minPlayer {
 			playstop.SetIcon(theme.MediaPlayIcon())
 		}
 	})
-	lgp = NewGifPlayer(frames, delays).WithControlPanel(playstop)
+	lgp = NewGifPlayer(frames, delays, loopcount).WithControlPanel(playstop)
+	lgp.SetOnEnded(func() {
+		playstop.SetText("Play")
+		playstop.SetIcon(theme.MediaPlayIcon())
+	})
 	return &minPlayer{
 		GifPlayer: lgp,
 		playstop:  playstop,
@@ -153,7 +189,7 @@ type extendedPlayer struct {
 	playpause *widget.Button
 }
 
-func NewExtendedGifPlayer(frames []*canvas.Image, delays []int) *extendedPlayer {
+func NewExtendedGifPlayer(frames []*canvas.Image, delays []int, loopcount int) *extendedPlayer {
 	var lgp *GifPlayer
 
 	var playpause *widget.Button
@@ -209,9 +245,27 @@ func NewExtendedGifPlayer(frames []*canvas.Image, delays []int) *extendedPlayer
 		lgp.SeekTo(int(f))
 	}
 
-	controls := container.NewBorder(controlbuttons, nil, nil, nil, seeker)
+	repeat := widget.NewSelect([]string{"File Default", "Forever", "Once", "Ping-Pong"}, func(s string) {
+		switch s {
+		case "File Default":
+			lgp.SetLoopCount(lgp.loopcount)
+		case "Forever":
+			lgp.SetRepeat(gp.RepeatForever, 0)
+		case "Once":
+			lgp.SetRepeat(gp.RepeatOnce, 0)
+		case "Ping-Pong":
+			lgp.SetRepeat(gp.RepeatPingPong, 0)
+		}
+	})
+
+	controls := container.NewBorder(controlbuttons, nil, nil, repeat, seeker)
 
-	lgp = NewGifPlayer(frames, delays).WithControlPanel(controls)
+	lgp = NewGifPlayer(frames, delays, loopcount).WithControlPanel(controls)
+	repeat.SetSelectedIndex(0)
+	lgp.SetOnEnded(func() {
+		playpause.SetText("Play")
+		playpause.SetIcon(theme.MediaPlayIcon())
+	})
 	lower, upper := lgp.GetSeekerBounds()
 	seeker.Min = float64(lower)
 	seeker.Max = float64(upper)
@@ -7,6 +7,7 @@ toolchain go1.23.3
 require (
 	fyne.io/fyne/v2 v2.5.2
 	github.com/anthonynsimon/bild v0.14.0
+	github.com/fsnotify/fsnotify v1.8.0
 	golang.org/x/image v0.22.0
 	golang.org/x/sync v0.9.0
 )
@@ -16,7 +17,6 @@ require (
 	github.com/BurntSushi/toml v1.4.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/fredbi/uri v1.1.0 // indirect
-	github.com/fsnotify/fsnotify v1.8.0 // indirect
 	github.com/fyne-io/gl-js v0.0.0-20230506162202-1fdaa286a934 // indirect
 	github.com/fyne-io/glfw-js v0.0.0-20241126112943-313d8a0fe1d0 // indirect
 	github.com/fyne-io/image v0.0.0-20240417123036-dc0ee9e7c964 // indirect
@@ -1,25 +1,54 @@
 package ilp
 
 import (
+	"slices"
+	"sync"
+	"time"
+
 	gp "github.com/BieHDC/fic/genericplayer"
 )
 
 type ImagePlayer struct {
+	// the player runs on its own goroutine, lock guards the lists
+	lock        sync.Mutex
 	filelistlen int
 	filelist    []string
+	playlist    []string // the order we play in, filelist unless shuffled
+	//
+	shuffle      ShuffleMode
+	seed         int64
+	lastposition int
 	//
 	player        *gp.GPlayer
 	onFrame       func(int, []string, bool)
 	onDataChanged func()
+	onListUpdated func()
 	onPlay        func()
+	onEnded       func()
 }
 
 func NewImagePlayer() *ImagePlayer {
 	ip := &ImagePlayer{}
 
 	ip.player = gp.NewPlayer(func(index int, block bool) {
+		ip.lock.Lock()
+		if block && ip.shuffle == ShuffleExhaust && index == 0 && ip.lastposition == ip.filelistlen-1 {
+			// every file was shown once, start a new round
+			ip.reshuffleNotLocked(newSeed())
+		}
+		ip.lastposition = index
+		playlist := ip.playlist
+		ip.lock.Unlock()
+
+		// the index is the position in the play order
 		if ip.onFrame != nil {
-			ip.onFrame(index, ip.filelist, block)
+			ip.onFrame(index, playlist, block)
+		}
+	})
+
+	ip.player.SetOnEnded(func() {
+		if ip.onEnded != nil {
+			ip.onEnded()
 		}
 	})
 
@@ -27,14 +56,110 @@ func NewImagePlayer() *ImagePlayer {
 }
 
 func (ip *ImagePlayer) SetNewData(files []string) {
+	ip.lock.Lock()
 	ip.filelist = files
 	ip.filelistlen = len(files)
-	ip.player.SendEvent(gp.GPlayerConfig_SetMaxIndex, ip.filelistlen)
+	ip.buildPlaylistNotLocked()
+	ip.lock.Unlock()
+	ip.player.SendEvent(gp.GPlayerConfig_SetMaxIndex, len(files))
 	if ip.onDataChanged != nil {
 		ip.onDataChanged()
 	}
 }
 
+// UpdateData replaces the list while keeping the cursor
+// on the current file, as long as it still exists
+func (ip *ImagePlayer) UpdateData(files []string) {
+	current := ip.Current()
+	ip.lock.Lock()
+	ip.filelist = files
+	ip.filelistlen = len(files)
+	ip.buildPlaylistNotLocked()
+	position := ip.positionNotLocked(current)
+	ip.lock.Unlock()
+	ip.player.SendEvent(gp.GPlayerConfig_SetMaxIndex, len(files))
+	if position >= 0 {
+		ip.player.SendEvent(gp.GPlayerConfig_SetCursor, position)
+	}
+	if ip.onListUpdated != nil {
+		ip.onListUpdated()
+	}
+}
+
+// RemoveData takes a file out of the lists without changing the order of
+// the rest. if it was the current one, the one that moved up into its
+// place is shown. it returns where the file was, so InsertData can put it back.
+func (ip *ImagePlayer) RemoveData(file string) (int, int, bool) {
+	current := ip.Current()
+	ip.lock.Lock()
+	position := ip.positionNotLocked(file)
+	index := slices.Index(ip.filelist, file)
+	if position < 0 || index < 0 {
+		ip.lock.Unlock()
+		return 0, 0, false
+	}
+	// never modify the slices in place, someone might be iterating them
+	shuffled := ip.shuffle != ShuffleOff
+	ip.filelist = slices.Delete(slices.Clone(ip.filelist), index, index+1)
+	ip.filelistlen = len(ip.filelist)
+	if shuffled {
+		ip.playlist = slices.Delete(slices.Clone(ip.playlist), position, position+1)
+	} else {
+		ip.playlist = ip.filelist
+	}
+	numfiles := ip.filelistlen
+	stayat := ip.positionNotLocked(current)
+	ip.lock.Unlock()
+
+	removedat := position
+	if file != current && stayat >= 0 {
+		// the cursor stays on what is shown
+		ip.player.SendEvent(gp.GPlayerConfig_SetCursor, stayat)
+		ip.player.SendEvent(gp.GPlayerConfig_SetMaxIndex, numfiles)
+		if ip.onListUpdated != nil {
+			ip.onListUpdated()
+		}
+		return index, removedat, true
+	}
+	if numfiles > 0 {
+		// so shrinking the list does not jump back to the start
+		position = min(position, numfiles-1)
+		ip.player.SendEvent(gp.GPlayerConfig_SetCursor, position)
+	}
+	ip.player.SendEvent(gp.GPlayerConfig_SetMaxIndex, numfiles)
+	if numfiles > 0 {
+		ip.SeekTo(position)
+	}
+	if ip.onListUpdated != nil {
+		ip.onListUpdated()
+	}
+	return index, removedat, true
+}
+
+// InsertData puts a file back to where RemoveData took it from and shows it
+func (ip *ImagePlayer) InsertData(file string, index, position int) {
+	ip.lock.Lock()
+	index = min(max(index, 0), len(ip.filelist))
+	position = min(max(position, 0), len(ip.playlist))
+	shuffled := ip.shuffle != ShuffleOff
+	ip.filelist = slices.Insert(slices.Clone(ip.filelist), index, file)
+	ip.filelistlen = len(ip.filelist)
+	if shuffled {
+		ip.playlist = slices.Insert(slices.Clone(ip.playlist), position, file)
+	} else {
+		ip.playlist = ip.filelist
+		position = index
+	}
+	numfiles := ip.filelistlen
+	ip.lock.Unlock()
+
+	ip.player.SendEvent(gp.GPlayerConfig_SetMaxIndex, numfiles)
+	ip.SeekTo(position)
+	if ip.onListUpdated != nil {
+		ip.onListUpdated()
+	}
+}
+
 func (ip *ImagePlayer) SetOnFrameFunc(cb func(int, []string, bool)) {
 	ip.onFrame = cb
 }
@@ -43,10 +168,46 @@ func (ip *ImagePlayer) SetOnDataChangedFunc(cb func()) {
 	ip.onDataChanged = cb
 }
 
+func (ip *ImagePlayer) SetOnListUpdatedFunc(cb func()) {
+	ip.onListUpdated = cb
+}
+
 func (ip *ImagePlayer) SetOnPlayFunc(cb func()) {
 	ip.onPlay = cb
 }
 
+// called when playback stopped at the end of the list
+func (ip *ImagePlayer) SetOnEndedFunc(cb func()) {
+	ip.onEnded = cb
+}
+
+// SetStopAtEnd makes playback stop after the last file instead of starting over
+func (ip *ImagePlayer) SetStopAtEnd(stop bool) bool {
+	policy := gp.RepeatForever
+	if stop {
+		policy = gp.RepeatOnce
+	}
+	return ip.player.SendEvent(gp.GPlayerConfig_Repeat, int(policy)) == gp.GPlayerStatus_OK
+}
+
+// SetFrameDurationFunc sets how long a file stays on screen, see GPlayer.SetFrameDuration
+func (ip *ImagePlayer) SetFrameDurationFunc(cb func(int) time.Duration) {
+	ip.player.SetFrameDuration(cb)
+}
+
+// SetDropFrames skips files when playback falls behind the schedule
+func (ip *ImagePlayer) SetDropFrames(drop bool) bool {
+	dropframes := 0
+	if drop {
+		dropframes = 1
+	}
+	return ip.player.SendEvent(gp.GPlayerConfig_DropFrames, dropframes) == gp.GPlayerStatus_OK
+}
+
+func (ip *ImagePlayer) Throughput() (float64, int) {
+	return ip.player.Throughput()
+}
+
 func (ip *ImagePlayer) PlayPause() bool {
 	status := ip.player.SendEvent(gp.GPlayerAction_Playpause) == gp.GPlayerStatus_Playing
 	if status {
@@ -70,7 +231,7 @@ func (ip *ImagePlayer) Next() bool {
 }
 
 func (ip *ImagePlayer) GetSeekerBounds() (int, int) {
-	return 0, ip.filelistlen - 1
+	return 0, ip.Len() - 1
 }
 
 func (ip *ImagePlayer) SeekTo(index int) bool {
@@ -78,23 +239,59 @@ func (ip *ImagePlayer) SeekTo(index int) bool {
 }
 
 func (ip *ImagePlayer) SeekToData(file string) bool {
-	for i, id := range ip.filelist {
-		if id == file {
-			ip.SeekTo(i)
-			return true
-		}
+	ip.lock.Lock()
+	position := ip.positionNotLocked(file)
+	ip.lock.Unlock()
+	if position < 0 {
+		return false
 	}
-	return false
+	ip.SeekTo(position)
+	return true
 }
 
 func (ip *ImagePlayer) Len() int {
+	ip.lock.Lock()
+	defer ip.lock.Unlock()
 	return ip.filelistlen
 }
 
+// List is the files in their real order
 func (ip *ImagePlayer) List() []string {
+	ip.lock.Lock()
+	defer ip.lock.Unlock()
 	return ip.filelist
 }
 
+// Playlist is the files in the order they are played
+func (ip *ImagePlayer) Playlist() []string {
+	ip.lock.Lock()
+	defer ip.lock.Unlock()
+	return ip.playlist
+}
+
 func (ip *ImagePlayer) Cursor() int {
 	return ip.player.Cursor()
 }
+
+func (ip *ImagePlayer) Current() string {
+	cursor := ip.Cursor()
+	ip.lock.Lock()
+	defer ip.lock.Unlock()
+	if cursor < 0 || cursor >= len(ip.playlist) {
+		return ""
+	}
+	return ip.playlist[cursor]
+}
+
+// RealCursor is the index of the current file in List
+func (ip *ImagePlayer) RealCursor() int {
+	current := ip.Current()
+	ip.lock.Lock()
+	defer ip.lock.Unlock()
+	for i, id := range ip.filelist {
+		if id == current {
+			return i
+		}
+	}
+	return 0
+}
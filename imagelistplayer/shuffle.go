@@ -0,0 +1,98 @@
+package ilp
+
+import (
+	"math/rand"
+	"time"
+
+	gp "github.com/BieHDC/fic/genericplayer"
+)
+
+type ShuffleMode int
+
+const (
+	ShuffleOff ShuffleMode = iota
+	// the same shuffled order every round, until it is reseeded
+	ShuffleStable
+	// a new order every round, nothing repeats until every file was shown
+	ShuffleExhaust
+)
+
+func newSeed() int64 {
+	return time.Now().UnixNano()
+}
+
+// must be called with the lock held
+func (ip *ImagePlayer) buildPlaylistNotLocked() {
+	if ip.shuffle == ShuffleOff {
+		ip.playlist = ip.filelist
+		return
+	}
+	if ip.seed == 0 {
+		ip.seed = newSeed()
+	}
+	order := rand.New(rand.NewSource(ip.seed)).Perm(len(ip.filelist))
+	ip.playlist = make([]string, len(order))
+	for i, index := range order {
+		ip.playlist[i] = ip.filelist[index]
+	}
+}
+
+// reshuffleNotLocked makes a new order that does not start
+// with the file that was shown last, must be called with the lock held
+func (ip *ImagePlayer) reshuffleNotLocked(seed int64) {
+	var last string
+	if len(ip.playlist) > 0 {
+		last = ip.playlist[len(ip.playlist)-1]
+	}
+	ip.seed = seed
+	ip.buildPlaylistNotLocked()
+	if len(ip.playlist) > 1 && ip.playlist[0] == last {
+		ip.playlist[0], ip.playlist[1] = ip.playlist[1], ip.playlist[0]
+	}
+}
+
+// must be called with the lock held
+func (ip *ImagePlayer) positionNotLocked(file string) int {
+	for i, id := range ip.playlist {
+		if id == file {
+			return i
+		}
+	}
+	return -1
+}
+
+// reorder runs fn on the lists and moves the cursor
+// to where the current file ended up
+func (ip *ImagePlayer) reorder(fn func()) {
+	current := ip.Current()
+	ip.lock.Lock()
+	fn()
+	position := ip.positionNotLocked(current)
+	ip.lock.Unlock()
+	if position >= 0 {
+		ip.player.SendEvent(gp.GPlayerConfig_SetCursor, position)
+	}
+	if ip.onListUpdated != nil {
+		ip.onListUpdated()
+	}
+}
+
+// SetShuffle changes the play order, turning it off lands
+// on the real index of the current file
+func (ip *ImagePlayer) SetShuffle(mode ShuffleMode) {
+	ip.reorder(func() {
+		ip.shuffle = mode
+		ip.buildPlaylistNotLocked()
+	})
+}
+
+// Reseed makes a new shuffled order, 0 picks a random seed
+func (ip *ImagePlayer) Reseed(seed int64) {
+	if seed == 0 {
+		seed = newSeed()
+	}
+	ip.reorder(func() {
+		ip.seed = seed
+		ip.buildPlaylistNotLocked()
+	})
+}
@@ -0,0 +1,79 @@
+package md
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+type frameDisposal int
+
+const (
+	disposeNone frameDisposal = iota
+	disposeBackground
+	disposePrevious
+)
+
+// animFrame is a single frame of any of the animated formats,
+// before it has been drawn onto the logical screen
+type animFrame struct {
+	img     image.Image
+	bounds  image.Rectangle // where on the screen it goes
+	blend   bool            // false replaces the area instead of drawing over it
+	dispose frameDisposal
+	delay   int // in 100th of a second, like gif does it
+}
+
+type animation struct {
+	frames    []*image.RGBA
+	delays    []int
+	loopcount int // how often it should be played, 0 is forever
+}
+
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	clone := image.NewRGBA(img.Rect)
+	copy(clone.Pix, img.Pix)
+	return clone
+}
+
+// composeFrames renders every frame onto the logical screen while
+// honouring the disposal method of the previous frame
+func composeFrames(screen image.Rectangle, background color.Color, frames []animFrame) *animation {
+	anim := &animation{
+		frames: make([]*image.RGBA, len(frames)),
+		delays: make([]int, len(frames)),
+	}
+	bg := image.NewUniform(background)
+
+	canvas := image.NewRGBA(screen)
+	for i, frame := range frames {
+		var previous *image.RGBA
+		if frame.dispose == disposePrevious {
+			previous = cloneRGBA(canvas)
+		}
+
+		op := draw.Src
+		if frame.blend {
+			op = draw.Over
+		}
+		draw.Draw(canvas, frame.bounds, frame.img, frame.img.Bounds().Min, op)
+		anim.frames[i] = cloneRGBA(canvas)
+
+		anim.delays[i] = frame.delay
+		if anim.delays[i] < 1 {
+			// fix animations with no delay to the default of 10
+			// this is what other programs do
+			anim.delays[i] = 10
+		}
+
+		// prepare the canvas for the next frame
+		switch frame.dispose {
+		case disposeBackground:
+			draw.Draw(canvas, frame.bounds, bg, image.Point{}, draw.Src)
+		case disposePrevious:
+			canvas = previous
+		}
+	}
+
+	return anim
+}
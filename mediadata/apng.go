@@ -0,0 +1,173 @@
+package md
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// https://wiki.mozilla.org/APNG_Specification
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// forEachPNGChunk calls fn for every chunk until it returns false
+func forEachPNGChunk(data []byte, fn func(chunktype string, chunk []byte) bool) error {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return fmt.Errorf("not a png")
+	}
+	data = data[len(pngSignature):]
+	for len(data) >= 12 {
+		length := binary.BigEndian.Uint32(data[0:4])
+		if uint64(length)+12 > uint64(len(data)) {
+			return fmt.Errorf("truncated png chunk")
+		}
+		if !fn(string(data[4:8]), data[8:8+length]) {
+			return nil
+		}
+		data = data[12+length:]
+	}
+	return nil
+}
+
+func pngChunk(chunktype string, data []byte) []byte {
+	chunk := make([]byte, 0, len(data)+12)
+	chunk = binary.BigEndian.AppendUint32(chunk, uint32(len(data)))
+	chunk = append(chunk, chunktype...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+type apngFrame struct {
+	width, height int
+	x, y          int
+	delay         int
+	dispose       frameDisposal
+	blend         bool
+	data          [][]byte // the image data of all IDAT or fdAT chunks
+}
+
+// isAPNG checks for the animation control chunk, which must come before the image data
+func isAPNG(data []byte) bool {
+	found := false
+	forEachPNGChunk(data, func(chunktype string, _ []byte) bool {
+		if chunktype == "acTL" {
+			found = true
+		}
+		return chunktype != "IDAT" && !found
+	})
+	return found
+}
+
+func decodeAPNG(data []byte) (*animation, error) {
+	var ihdr []byte
+	var numplays int
+	var shared [][]byte // chunks every frame needs, like the palette
+	var frames []*apngFrame
+	var current *apngFrame
+
+	err := forEachPNGChunk(data, func(chunktype string, chunk []byte) bool {
+		switch chunktype {
+		case "IHDR":
+			ihdr = chunk
+		case "acTL":
+			if len(chunk) >= 8 {
+				numplays = int(binary.BigEndian.Uint32(chunk[4:8]))
+			}
+		case "PLTE", "tRNS", "gAMA", "cHRM", "sRGB", "iCCP", "sBIT":
+			shared = append(shared, pngChunk(chunktype, chunk))
+		case "fcTL":
+			if len(chunk) < 26 {
+				return true
+			}
+			current = &apngFrame{
+				width:  int(binary.BigEndian.Uint32(chunk[4:8])),
+				height: int(binary.BigEndian.Uint32(chunk[8:12])),
+				x:      int(binary.BigEndian.Uint32(chunk[12:16])),
+				y:      int(binary.BigEndian.Uint32(chunk[16:20])),
+				blend:  chunk[25] == 1,
+			}
+			num := int(binary.BigEndian.Uint16(chunk[20:22]))
+			den := int(binary.BigEndian.Uint16(chunk[22:24]))
+			if den == 0 {
+				den = 100
+			}
+			current.delay = (num*100 + den/2) / den
+			switch chunk[24] {
+			case 1:
+				current.dispose = disposeBackground
+			case 2:
+				current.dispose = disposePrevious
+			}
+			frames = append(frames, current)
+		case "IDAT":
+			// the default image is only part of the animation if it has a fcTL
+			if current != nil {
+				current.data = append(current.data, chunk)
+			}
+		case "fdAT":
+			if current != nil && len(chunk) > 4 {
+				current.data = append(current.data, chunk[4:])
+			}
+		case "IEND":
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(ihdr) < 13 {
+		return nil, fmt.Errorf("png without header")
+	}
+	if len(frames) < 1 {
+		return nil, fmt.Errorf("apng without frames")
+	}
+
+	screen := image.Rect(0, 0, int(binary.BigEndian.Uint32(ihdr[0:4])), int(binary.BigEndian.Uint32(ihdr[4:8])))
+	animframes := make([]animFrame, 0, len(frames))
+	for i, frame := range frames {
+		if len(frame.data) < 1 {
+			continue
+		}
+		// every frame is decoded as a standalone png with its own header
+		framepng := bytes.NewBuffer(nil)
+		framepng.Write(pngSignature)
+		frameihdr := bytes.Clone(ihdr)
+		binary.BigEndian.PutUint32(frameihdr[0:4], uint32(frame.width))
+		binary.BigEndian.PutUint32(frameihdr[4:8], uint32(frame.height))
+		framepng.Write(pngChunk("IHDR", frameihdr))
+		for _, chunk := range shared {
+			framepng.Write(chunk)
+		}
+		for _, idat := range frame.data {
+			framepng.Write(pngChunk("IDAT", idat))
+		}
+		framepng.Write(pngChunk("IEND", nil))
+
+		img, err := png.Decode(framepng)
+		if err != nil {
+			return nil, fmt.Errorf("apng frame %d: %w", i, err)
+		}
+
+		dispose := frame.dispose
+		if i == 0 && dispose == disposePrevious {
+			// the spec says so
+			dispose = disposeBackground
+		}
+		animframes = append(animframes, animFrame{
+			img:     img,
+			bounds:  image.Rect(frame.x, frame.y, frame.x+frame.width, frame.y+frame.height),
+			blend:   frame.blend,
+			dispose: dispose,
+			delay:   frame.delay,
+		})
+	}
+
+	anim := composeFrames(screen, color.Transparent, animframes)
+	anim.loopcount = numplays
+	return anim, nil
+}
@@ -0,0 +1,212 @@
+package md
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// every frame is encoded with the same palette, so they all
+// come out of the png encoder with the same header
+var apngPalette = color.Palette{gifColors['.'], gifColors['R'], gifColors['G'], gifColors['B'], gifColors['W']}
+
+const (
+	apngDisposeNone       = 0
+	apngDisposeBackground = 1
+	apngDisposePrevious   = 2
+	apngBlendSource       = 0
+	apngBlendOver         = 1
+)
+
+type apngTestFrame struct {
+	x, y    int
+	pixels  string
+	dispose byte
+	blend   byte
+}
+
+type apngFixture struct {
+	name          string
+	width, height int
+	hiddendefault string // an image in front of the animation that is not part of it
+	frames        []apngTestFrame
+	want          []string
+}
+
+var apngFixtures = []apngFixture{
+	{
+		name:  "source replaces the area, transparent pixels too",
+		width: 3, height: 1,
+		frames: []apngTestFrame{
+			{0, 0, "RRR", apngDisposeNone, apngBlendSource},
+			{1, 0, "G.", apngDisposeNone, apngBlendSource},
+		},
+		want: []string{"RRR", "RG."},
+	},
+	{
+		name:  "over shows the frame below through transparent pixels",
+		width: 3, height: 1,
+		frames: []apngTestFrame{
+			{0, 0, "RRR", apngDisposeNone, apngBlendSource},
+			{1, 0, "G.", apngDisposeNone, apngBlendOver},
+		},
+		want: []string{"RRR", "RGR"},
+	},
+	{
+		name:  "background clears the area of the frame to transparent",
+		width: 3, height: 2,
+		frames: []apngTestFrame{
+			{0, 0, "RRR/RRR", apngDisposeNone, apngBlendSource},
+			{0, 0, "GG", apngDisposeBackground, apngBlendSource},
+			{2, 1, "B", apngDisposeNone, apngBlendOver},
+		},
+		want: []string{"RRR/RRR", "GGR/RRR", "..R/RRB"},
+	},
+	{
+		name:  "previous restores what was there before the frame",
+		width: 3, height: 1,
+		frames: []apngTestFrame{
+			{0, 0, "RRR", apngDisposeNone, apngBlendSource},
+			{1, 0, "G", apngDisposePrevious, apngBlendSource},
+			{2, 0, "B", apngDisposeNone, apngBlendSource},
+		},
+		want: []string{"RRR", "RGR", "RRB"},
+	},
+	{
+		name:  "previous on the first frame is background",
+		width: 3, height: 1,
+		frames: []apngTestFrame{
+			{0, 0, "RRR", apngDisposePrevious, apngBlendSource},
+			{2, 0, "B", apngDisposeNone, apngBlendOver},
+		},
+		want: []string{"RRR", "..B"},
+	},
+	{
+		name:  "the default image without a frame control is skipped",
+		width: 2, height: 1,
+		hiddendefault: "WW",
+		frames: []apngTestFrame{
+			{0, 0, "RR", apngDisposeNone, apngBlendSource},
+			{1, 0, "G", apngDisposeNone, apngBlendSource},
+		},
+		want: []string{"RR", "RG"},
+	},
+}
+
+// encodePNGChunks returns the chunks the png encoder writes for pixels
+func encodePNGChunks(t *testing.T, pixels string) map[string][][]byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, gifFrame(0, 0, apngPalette, pixels)); err != nil {
+		t.Fatal(err)
+	}
+	chunks := make(map[string][][]byte)
+	err := forEachPNGChunk(buf.Bytes(), func(chunktype string, chunk []byte) bool {
+		chunks[chunktype] = append(chunks[chunktype], bytes.Clone(chunk))
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chunks
+}
+
+// encode writes an apng, every frame shows for a tenth of a second
+func (fx apngFixture) encode(t *testing.T, plays int) []byte {
+	t.Helper()
+	first := encodePNGChunks(t, fx.frames[0].pixels)
+	ihdr := first["IHDR"][0]
+	binary.BigEndian.PutUint32(ihdr[0:4], uint32(fx.width))
+	binary.BigEndian.PutUint32(ihdr[4:8], uint32(fx.height))
+
+	data := bytes.Clone(pngSignature)
+	data = append(data, pngChunk("IHDR", ihdr)...)
+	actl := binary.BigEndian.AppendUint32(nil, uint32(len(fx.frames)))
+	actl = binary.BigEndian.AppendUint32(actl, uint32(plays))
+	data = append(data, pngChunk("acTL", actl)...)
+	data = append(data, pngChunk("PLTE", first["PLTE"][0])...)
+	data = append(data, pngChunk("tRNS", first["tRNS"][0])...)
+	if fx.hiddendefault != "" {
+		for _, idat := range encodePNGChunks(t, fx.hiddendefault)["IDAT"] {
+			data = append(data, pngChunk("IDAT", idat)...)
+		}
+	}
+
+	sequence := uint32(0)
+	for i, frame := range fx.frames {
+		lines := rows(frame.pixels)
+		fctl := binary.BigEndian.AppendUint32(nil, sequence)
+		fctl = binary.BigEndian.AppendUint32(fctl, uint32(len(lines[0])))
+		fctl = binary.BigEndian.AppendUint32(fctl, uint32(len(lines)))
+		fctl = binary.BigEndian.AppendUint32(fctl, uint32(frame.x))
+		fctl = binary.BigEndian.AppendUint32(fctl, uint32(frame.y))
+		fctl = binary.BigEndian.AppendUint16(fctl, 1)
+		fctl = binary.BigEndian.AppendUint16(fctl, 10)
+		fctl = append(fctl, frame.dispose, frame.blend)
+		data = append(data, pngChunk("fcTL", fctl)...)
+		sequence++
+
+		for _, idat := range encodePNGChunks(t, frame.pixels)["IDAT"] {
+			if i == 0 && fx.hiddendefault == "" {
+				data = append(data, pngChunk("IDAT", idat)...)
+				continue
+			}
+			fdat := append(binary.BigEndian.AppendUint32(nil, sequence), idat...)
+			data = append(data, pngChunk("fdAT", fdat)...)
+			sequence++
+		}
+	}
+	return append(data, pngChunk("IEND", nil)...)
+}
+
+func TestDecodeAPNG(t *testing.T) {
+	for _, fx := range apngFixtures {
+		t.Run(fx.name, func(t *testing.T) {
+			data := fx.encode(t, 0)
+			if !isAPNG(data) {
+				t.Fatal("not detected as an apng")
+			}
+			anim, err := decodeAPNG(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(anim.frames) != len(fx.want) {
+				t.Fatalf("got %d frames, want %d", len(anim.frames), len(fx.want))
+			}
+			for i, want := range fx.want {
+				compareFrame(t, i, anim.frames[i], want)
+			}
+		})
+	}
+}
+
+func TestDecodeAPNGTiming(t *testing.T) {
+	fx := apngFixtures[0]
+	for _, plays := range []int{0, 1, 3} {
+		anim, err := decodeAPNG(fx.encode(t, plays))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// unlike gif, apng counts every play and 0 is forever too
+		if anim.loopcount != plays {
+			t.Errorf("loopcount is %d, want %d", anim.loopcount, plays)
+		}
+		for i, delay := range anim.delays {
+			if delay != 10 {
+				t.Errorf("frame %d: delay is %d, want 10", i, delay)
+			}
+		}
+	}
+}
+
+func TestIsAPNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, gifFrame(0, 0, apngPalette, "RG")); err != nil {
+		t.Fatal(err)
+	}
+	if isAPNG(buf.Bytes()) {
+		t.Error("a plain png is detected as an apng")
+	}
+}
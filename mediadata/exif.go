@@ -0,0 +1,420 @@
+package md
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"strings"
+	"time"
+)
+
+// https://www.cipa.jp/std/documents/e/DC-X008-Translation-2019-E.pdf
+
+// Metadata is what we understand of the exif data of an image
+type Metadata struct {
+	Make         string
+	Model        string
+	Lens         string
+	CaptureTime  time.Time
+	ExposureTime string // like 1/250
+	FNumber      float64
+	ISO          int
+	FocalLength  float64 // in mm
+	Orientation  int     // 1 to 8, 0 if there is none
+	HasGPS       bool
+	Latitude     float64
+	Longitude    float64
+	Altitude     float64 // in m, negative is below sea level
+}
+
+const (
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagExposureTime     = 0x829A
+	tagFNumber          = 0x829D
+	tagISO              = 0x8827
+	tagDateTimeOriginal = 0x9003
+	tagFocalLength      = 0x920A
+	tagLensMake         = 0xA433
+	tagLensModel        = 0xA434
+	//
+	tagGPSLatitudeRef  = 0x0001
+	tagGPSLatitude     = 0x0002
+	tagGPSLongitudeRef = 0x0003
+	tagGPSLongitude    = 0x0004
+	tagGPSAltitudeRef  = 0x0005
+	tagGPSAltitude     = 0x0006
+)
+
+// the sizes of the tiff field types, 0 for the ones that do not exist
+var tiffTypeSize = [...]int{0, 1, 1, 2, 4, 8, 1, 1, 2, 4, 8, 4, 8}
+
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	data  []byte
+}
+
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+func newTiffReader(data []byte) (*tiffReader, uint32, error) {
+	if len(data) < 8 {
+		return nil, 0, fmt.Errorf("tiff header too short")
+	}
+	tr := &tiffReader{data: data}
+	switch string(data[0:2]) {
+	case "II":
+		tr.order = binary.LittleEndian
+	case "MM":
+		tr.order = binary.BigEndian
+	default:
+		return nil, 0, fmt.Errorf("bad tiff byte order")
+	}
+	if tr.order.Uint16(data[2:4]) != 42 {
+		return nil, 0, fmt.Errorf("bad tiff magic")
+	}
+	return tr, tr.order.Uint32(data[4:8]), nil
+}
+
+// ifd reads all entries of the directory at offset
+func (tr *tiffReader) ifd(offset uint32) map[uint16]tiffEntry {
+	entries := make(map[uint16]tiffEntry)
+	if uint64(offset)+2 > uint64(len(tr.data)) {
+		return entries
+	}
+	count := int(tr.order.Uint16(tr.data[offset:]))
+	pos := int(offset) + 2
+	for i := 0; i < count; i++ {
+		if pos+12 > len(tr.data) {
+			break
+		}
+		entry := tr.data[pos : pos+12]
+		pos += 12
+
+		tag := tr.order.Uint16(entry[0:2])
+		typ := tr.order.Uint16(entry[2:4])
+		n := tr.order.Uint32(entry[4:8])
+		if int(typ) >= len(tiffTypeSize) || tiffTypeSize[typ] == 0 {
+			continue
+		}
+		size := uint64(tiffTypeSize[typ]) * uint64(n)
+		var data []byte
+		if size <= 4 {
+			// small values are stored in the entry itself
+			data = entry[8 : 8+size]
+		} else {
+			valueoffset := uint64(tr.order.Uint32(entry[8:12]))
+			if valueoffset+size > uint64(len(tr.data)) {
+				continue
+			}
+			data = tr.data[valueoffset : valueoffset+size]
+		}
+		entries[tag] = tiffEntry{typ: typ, count: n, data: data}
+	}
+	return entries
+}
+
+func (tr *tiffReader) uint(e tiffEntry) (uint32, bool) {
+	switch e.typ {
+	case 1, 7:
+		if len(e.data) >= 1 {
+			return uint32(e.data[0]), true
+		}
+	case 3:
+		if len(e.data) >= 2 {
+			return uint32(tr.order.Uint16(e.data)), true
+		}
+	case 4:
+		if len(e.data) >= 4 {
+			return tr.order.Uint32(e.data), true
+		}
+	}
+	return 0, false
+}
+
+func (tr *tiffReader) string(e tiffEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(e.data), "\x00"))
+}
+
+// rational returns the i-th fraction of an entry
+func (tr *tiffReader) rational(e tiffEntry, i int) (int64, int64, bool) {
+	if (e.typ != 5 && e.typ != 10) || len(e.data) < (i+1)*8 {
+		return 0, 0, false
+	}
+	num := tr.order.Uint32(e.data[i*8:])
+	den := tr.order.Uint32(e.data[i*8+4:])
+	if e.typ == 10 {
+		return int64(int32(num)), int64(int32(den)), den != 0
+	}
+	return int64(num), int64(den), den != 0
+}
+
+func (tr *tiffReader) float(e tiffEntry, i int) (float64, bool) {
+	num, den, ok := tr.rational(e, i)
+	if !ok {
+		return 0, false
+	}
+	return float64(num) / float64(den), true
+}
+
+// gpsCoordinate turns degrees, minutes and seconds into decimal degrees
+func (tr *tiffReader) gpsCoordinate(e tiffEntry, ref string, negative string) (float64, bool) {
+	var coordinate float64
+	for i, unit := range []float64{1, 60, 3600} {
+		part, ok := tr.float(e, i)
+		if !ok {
+			return 0, false
+		}
+		coordinate += part / unit
+	}
+	if ref == negative {
+		coordinate = -coordinate
+	}
+	return coordinate, true
+}
+
+// parseExif reads the tiff structure that makes up exif data
+func parseExif(data []byte) (*Metadata, error) {
+	tr, offset, err := newTiffReader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &Metadata{}
+	ifd0 := tr.ifd(offset)
+	meta.Make = tr.string(ifd0[tagMake])
+	meta.Model = tr.string(ifd0[tagModel])
+	if orientation, ok := tr.uint(ifd0[tagOrientation]); ok && orientation >= 1 && orientation <= 8 {
+		meta.Orientation = int(orientation)
+	}
+	datetime := tr.string(ifd0[tagDateTime])
+
+	if exifoffset, ok := tr.uint(ifd0[tagExifIFD]); ok {
+		exif := tr.ifd(exifoffset)
+		if original := tr.string(exif[tagDateTimeOriginal]); original != "" {
+			datetime = original
+		}
+		if num, den, ok := tr.rational(exif[tagExposureTime], 0); ok {
+			if num > 0 && num < den {
+				meta.ExposureTime = fmt.Sprintf("1/%0.0f", float64(den)/float64(num))
+			} else {
+				meta.ExposureTime = fmt.Sprintf("%g", float64(num)/float64(den))
+			}
+		}
+		meta.FNumber, _ = tr.float(exif[tagFNumber], 0)
+		if iso, ok := tr.uint(exif[tagISO]); ok {
+			meta.ISO = int(iso)
+		}
+		meta.FocalLength, _ = tr.float(exif[tagFocalLength], 0)
+		meta.Lens = strings.TrimSpace(tr.string(exif[tagLensMake]) + " " + tr.string(exif[tagLensModel]))
+	}
+	if datetime != "" {
+		// exif does not know about timezones
+		meta.CaptureTime, _ = time.ParseInLocation("2006:01:02 15:04:05", datetime, time.Local)
+	}
+
+	if gpsoffset, ok := tr.uint(ifd0[tagGPSIFD]); ok {
+		gps := tr.ifd(gpsoffset)
+		latitude, latok := tr.gpsCoordinate(gps[tagGPSLatitude], tr.string(gps[tagGPSLatitudeRef]), "S")
+		longitude, lonok := tr.gpsCoordinate(gps[tagGPSLongitude], tr.string(gps[tagGPSLongitudeRef]), "W")
+		if latok && lonok {
+			meta.HasGPS = true
+			meta.Latitude = latitude
+			meta.Longitude = longitude
+			meta.Altitude, _ = tr.float(gps[tagGPSAltitude], 0)
+			if ref, ok := tr.uint(gps[tagGPSAltitudeRef]); ok && ref == 1 {
+				meta.Altitude = -meta.Altitude
+			}
+		}
+	}
+
+	return meta, nil
+}
+
+// jpegExif finds the exif segment, which must come before the image data
+func jpegExif(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return nil
+		}
+		marker := data[pos+1]
+		if marker == 0xFF {
+			// fill byte
+			pos++
+			continue
+		}
+		if marker == 0xD8 || (marker >= 0xD0 && marker <= 0xD7) || marker == 0x01 {
+			// markers without a length
+			pos += 2
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			// start of scan or end of image
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil
+		}
+		segment := data[pos+4 : pos+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		pos += 2 + length
+	}
+	return nil
+}
+
+func pngExif(data []byte) []byte {
+	var exif []byte
+	forEachPNGChunk(data, func(chunktype string, chunk []byte) bool {
+		if chunktype == "eXIf" {
+			exif = chunk
+		}
+		return exif == nil && chunktype != "IDAT"
+	})
+	return exif
+}
+
+func webpExif(data []byte) []byte {
+	chunks, err := webpChunks(data)
+	if err != nil {
+		return nil
+	}
+	var exif []byte
+	forEachWebPChunk(chunks, func(fourcc string, chunk []byte) bool {
+		if fourcc == "EXIF" {
+			// some writers keep the jpeg header
+			exif = bytes.TrimPrefix(chunk, []byte("Exif\x00\x00"))
+		}
+		return exif == nil
+	})
+	return exif
+}
+
+// readMetadata returns nil if the file carries no exif data we understand
+func readMetadata(kind string, data []byte) *Metadata {
+	var exif []byte
+	switch kind {
+	case "jpeg":
+		exif = jpegExif(data)
+	case "png":
+		exif = pngExif(data)
+	case "webp":
+		exif = webpExif(data)
+	}
+	if exif == nil {
+		return nil
+	}
+	meta, err := parseExif(exif)
+	if err != nil {
+		return nil
+	}
+	return meta
+}
+
+// orientation works on files without metadata too
+func (meta *Metadata) orientation() int {
+	if meta == nil {
+		return 0
+	}
+	return meta.Orientation
+}
+
+// applyOrientation turns the image upright according to the exif orientation
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	src, ok := img.(*image.RGBA)
+	if !ok {
+		src = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(src, src.Rect, img, bounds.Min, draw.Src)
+	}
+	width := src.Rect.Dx()
+	height := src.Rect.Dy()
+
+	dstwidth, dstheight := width, height
+	if orientation >= 5 {
+		// the sides swap
+		dstwidth, dstheight = height, width
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstwidth, dstheight))
+
+	for y := 0; y < height; y++ {
+		row := src.Pix[y*src.Stride : y*src.Stride+width*4]
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = width-1-x, y
+			case 3: // upside down
+				dx, dy = width-1-x, height-1-y
+			case 4: // upside down and mirrored
+				dx, dy = x, height-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // needs a clockwise turn
+				dx, dy = height-1-y, x
+			case 7: // transversed
+				dx, dy = height-1-y, width-1-x
+			case 8: // needs a counter clockwise turn
+				dx, dy = y, width-1-x
+			}
+			copy(dst.Pix[dy*dst.Stride+dx*4:dy*dst.Stride+dx*4+4], row[x*4:x*4+4])
+		}
+	}
+	return dst
+}
+
+// Fields lists what is known in a human readable form for displaying
+func (meta *Metadata) Fields() [][2]string {
+	var fields [][2]string
+	add := func(name, value string) {
+		if value != "" {
+			fields = append(fields, [2]string{name, value})
+		}
+	}
+
+	add("Camera", strings.TrimSpace(meta.Make+" "+meta.Model))
+	add("Lens", meta.Lens)
+	if !meta.CaptureTime.IsZero() {
+		add("Captured", meta.CaptureTime.Format("2006-01-02 15:04:05"))
+	}
+	add("Exposure", meta.ExposureTime)
+	if meta.FNumber > 0 {
+		add("Aperture", fmt.Sprintf("f/%0.1f", meta.FNumber))
+	}
+	if meta.ISO > 0 {
+		add("ISO", fmt.Sprintf("%d", meta.ISO))
+	}
+	if meta.FocalLength > 0 {
+		add("Focal Length", fmt.Sprintf("%0.0f mm", meta.FocalLength))
+	}
+	if meta.HasGPS {
+		add("Latitude", fmt.Sprintf("%0.6f", meta.Latitude))
+		add("Longitude", fmt.Sprintf("%0.6f", meta.Longitude))
+		if meta.Altitude != 0 {
+			add("Altitude", fmt.Sprintf("%0.0f m", meta.Altitude))
+		}
+	}
+	return fields
+}
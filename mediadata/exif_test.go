@@ -0,0 +1,306 @@
+package md
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+type tiffOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// tiffBuilder writes tiff structures like cameras do, values that do not
+// fit into an entry go behind the directory
+type tiffBuilder struct {
+	order tiffOrder
+	buf   []byte
+}
+
+func newTiffBuilder(order tiffOrder) *tiffBuilder {
+	tb := &tiffBuilder{order: order}
+	if order.String() == binary.LittleEndian.String() {
+		tb.buf = append(tb.buf, "II"...)
+	} else {
+		tb.buf = append(tb.buf, "MM"...)
+	}
+	tb.buf = order.AppendUint16(tb.buf, 42)
+	tb.buf = order.AppendUint32(tb.buf, 0) // set by setFirst
+	return tb
+}
+
+func (tb *tiffBuilder) setFirst(offset uint32) []byte {
+	tb.order.PutUint32(tb.buf[4:], offset)
+	return tb.buf
+}
+
+// ifd appends a directory and returns its offset
+func (tb *tiffBuilder) ifd(entries ...testEntry) uint32 {
+	offset := uint32(len(tb.buf))
+	tb.buf = tb.order.AppendUint16(tb.buf, uint16(len(entries)))
+	valuepos := len(tb.buf) + 12*len(entries) + 4
+	var values []byte
+	for _, e := range entries {
+		tb.buf = tb.order.AppendUint16(tb.buf, e.tag)
+		tb.buf = tb.order.AppendUint16(tb.buf, e.typ)
+		tb.buf = tb.order.AppendUint32(tb.buf, e.count)
+		if len(e.value) <= 4 {
+			inline := make([]byte, 4)
+			copy(inline, e.value)
+			tb.buf = append(tb.buf, inline...)
+			continue
+		}
+		tb.buf = tb.order.AppendUint32(tb.buf, uint32(valuepos+len(values)))
+		values = append(values, e.value...)
+	}
+	tb.buf = tb.order.AppendUint32(tb.buf, 0) // no next directory
+	tb.buf = append(tb.buf, values...)
+	return offset
+}
+
+func (tb *tiffBuilder) ascii(tag uint16, s string) testEntry {
+	return testEntry{tag, 2, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+func (tb *tiffBuilder) short(tag uint16, v uint16) testEntry {
+	return testEntry{tag, 3, 1, tb.order.AppendUint16(nil, v)}
+}
+
+func (tb *tiffBuilder) long(tag uint16, v uint32) testEntry {
+	return testEntry{tag, 4, 1, tb.order.AppendUint32(nil, v)}
+}
+
+// rational takes pairs of numerator and denominator
+func (tb *tiffBuilder) rational(tag uint16, parts ...uint32) testEntry {
+	var value []byte
+	for _, part := range parts {
+		value = tb.order.AppendUint32(value, part)
+	}
+	return testEntry{tag, 5, uint32(len(parts) / 2), value}
+}
+
+// cameraExif is what the exif data of a photo from a camera looks like
+func cameraExif(order tiffOrder, orientation uint16) []byte {
+	tb := newTiffBuilder(order)
+	exif := tb.ifd(
+		tb.rational(tagExposureTime, 1, 250),
+		tb.rational(tagFNumber, 28, 10),
+		tb.short(tagISO, 400),
+		tb.ascii(tagDateTimeOriginal, "2023:07:14 18:30:05"),
+		tb.rational(tagFocalLength, 50, 1),
+		tb.ascii(tagLensMake, "Canon"),
+		tb.ascii(tagLensModel, "EF50mm f/1.8"),
+	)
+	gps := tb.ifd(
+		tb.ascii(tagGPSLatitudeRef, "N"),
+		tb.rational(tagGPSLatitude, 48, 1, 8, 1, 30, 1),
+		tb.ascii(tagGPSLongitudeRef, "W"),
+		tb.rational(tagGPSLongitude, 11, 1, 34, 1, 3015, 100),
+		testEntry{tagGPSAltitudeRef, 1, 1, []byte{1}},
+		tb.rational(tagGPSAltitude, 5205, 10),
+	)
+	ifd0 := tb.ifd(
+		tb.ascii(tagMake, "Canon"),
+		tb.ascii(tagModel, "EOS 5D  "),
+		tb.short(tagOrientation, orientation),
+		tb.ascii(tagDateTime, "2024:01:01 00:00:00"),
+		tb.long(tagExifIFD, exif),
+		tb.long(tagGPSIFD, gps),
+	)
+	return tb.setFirst(ifd0)
+}
+
+func nearly(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestParseExif(t *testing.T) {
+	for _, order := range []tiffOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(order.String(), func(t *testing.T) {
+			meta, err := parseExif(cameraExif(order, 6))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.Make != "Canon" || meta.Model != "EOS 5D" {
+				t.Errorf("camera is %q %q", meta.Make, meta.Model)
+			}
+			if meta.Lens != "Canon EF50mm f/1.8" {
+				t.Errorf("lens is %q", meta.Lens)
+			}
+			if meta.Orientation != 6 {
+				t.Errorf("orientation is %d, want 6", meta.Orientation)
+			}
+			// the original date wins over the one of the last edit
+			want := time.Date(2023, 7, 14, 18, 30, 5, 0, time.Local)
+			if !meta.CaptureTime.Equal(want) {
+				t.Errorf("captured %v, want %v", meta.CaptureTime, want)
+			}
+			if meta.ExposureTime != "1/250" || !nearly(meta.FNumber, 2.8) || meta.ISO != 400 || !nearly(meta.FocalLength, 50) {
+				t.Errorf("exposure is %s f/%g iso %d %gmm", meta.ExposureTime, meta.FNumber, meta.ISO, meta.FocalLength)
+			}
+			if !meta.HasGPS || !nearly(meta.Latitude, 48.141667) || !nearly(meta.Longitude, -11.575042) || !nearly(meta.Altitude, -520.5) {
+				t.Errorf("gps is %v %g %g %g", meta.HasGPS, meta.Latitude, meta.Longitude, meta.Altitude)
+			}
+		})
+	}
+}
+
+func TestParseExifValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry func(tb *tiffBuilder) testEntry
+		check func(meta *Metadata) bool
+	}{
+		{"long exposure", func(tb *tiffBuilder) testEntry { return tb.rational(tagExposureTime, 5, 2) },
+			func(meta *Metadata) bool { return meta.ExposureTime == "2.5" }},
+		{"zero denominator", func(tb *tiffBuilder) testEntry { return tb.rational(tagFNumber, 28, 0) },
+			func(meta *Metadata) bool { return meta.FNumber == 0 }},
+		{"iso as long", func(tb *tiffBuilder) testEntry { return tb.long(tagISO, 12800) },
+			func(meta *Metadata) bool { return meta.ISO == 12800 }},
+		{"unknown type", func(tb *tiffBuilder) testEntry { return testEntry{tagISO, 99, 1, []byte{1, 2, 3, 4}} },
+			func(meta *Metadata) bool { return meta.ISO == 0 }},
+		{"value out of bounds", func(tb *tiffBuilder) testEntry { return testEntry{tagLensModel, 2, 1 << 20, []byte{0, 0, 0, 0}} },
+			func(meta *Metadata) bool { return meta.Lens == "" }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tb := newTiffBuilder(binary.BigEndian)
+			exif := tb.ifd(test.entry(tb))
+			data := tb.setFirst(tb.ifd(tb.long(tagExifIFD, exif)))
+			meta, err := parseExif(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !test.check(meta) {
+				t.Errorf("got %+v", meta)
+			}
+		})
+	}
+}
+
+func TestParseExifBroken(t *testing.T) {
+	valid := cameraExif(binary.LittleEndian, 1)
+	tests := []struct {
+		name    string
+		data    []byte
+		wanterr bool
+	}{
+		{"empty", nil, true},
+		{"bad byte order", append([]byte("XX"), valid[2:]...), true},
+		{"bad magic", append([]byte("II\x2b\x00"), valid[4:]...), true},
+		{"first directory out of bounds", []byte("II\x2a\x00\xff\xff\x00\x00"), false},
+		{"cut off in the middle", valid[:len(valid)/2], false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseExif(test.data)
+			if (err != nil) != test.wanterr {
+				t.Errorf("got error %v, want an error %v", err, test.wanterr)
+			}
+		})
+	}
+
+	// orientations that do not exist are ignored
+	meta, err := parseExif(cameraExif(binary.LittleEndian, 9))
+	if err != nil || meta.Orientation != 0 {
+		t.Errorf("orientation 9 gave %v, %v", meta, err)
+	}
+}
+
+func TestReadMetadataJPEG(t *testing.T) {
+	exif := append([]byte("Exif\x00\x00"), cameraExif(binary.BigEndian, 3)...)
+	jpeg := []byte{0xFF, 0xD8}
+	// a jfif segment in front, like most files have
+	jpeg = append(jpeg, 0xFF, 0xE0, 0x00, 0x06, 'J', 'F', 'I', 'F')
+	jpeg = append(jpeg, 0xFF, 0xE1)
+	jpeg = binary.BigEndian.AppendUint16(jpeg, uint16(len(exif)+2))
+	jpeg = append(jpeg, exif...)
+	jpeg = append(jpeg, 0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9)
+
+	meta := readMetadata("jpeg", jpeg)
+	if meta == nil || meta.Orientation != 3 {
+		t.Fatalf("got %+v", meta)
+	}
+	if readMetadata("jpeg", jpeg[:10]) != nil {
+		t.Error("found exif in a cut off file")
+	}
+	if readMetadata("bmp", jpeg) != nil {
+		t.Error("found exif in a format that has none")
+	}
+}
+
+// letterImage draws rows like "abc/def" with one grey level per letter
+func letterImage(rows string) *image.RGBA {
+	lines := strings.Split(rows, "/")
+	img := image.NewRGBA(image.Rect(0, 0, len(lines[0]), len(lines)))
+	for y, line := range lines {
+		for x := range line {
+			img.Set(x, y, color.Gray{line[x]})
+		}
+	}
+	return img
+}
+
+func letters(img image.Image) string {
+	bounds := img.Bounds()
+	var rows []string
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		var row []byte
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, _, _, _ := img.At(x, y).RGBA()
+			row = append(row, byte(r>>8))
+		}
+		rows = append(rows, string(row))
+	}
+	return strings.Join(rows, "/")
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// what the camera stored, and what it looks like turned upright
+	tests := []struct {
+		orientation int
+		want        string
+	}{
+		{0, "abc/def"},
+		{1, "abc/def"},
+		{2, "cba/fed"},
+		{3, "fed/cba"},
+		{4, "def/abc"},
+		{5, "ad/be/cf"},
+		{6, "da/eb/fc"},
+		{7, "fc/eb/da"},
+		{8, "cf/be/ad"},
+		{9, "abc/def"},
+	}
+	for _, test := range tests {
+		if got := letters(applyOrientation(letterImage("abc/def"), test.orientation)); got != test.want {
+			t.Errorf("orientation %d: got %s, want %s", test.orientation, got, test.want)
+		}
+	}
+}
+
+func TestApplyOrientationOtherImages(t *testing.T) {
+	// not rgba and not starting at 0,0, like a sub image of a decoded file
+	gray := image.NewGray(image.Rect(0, 0, 4, 3))
+	for y, line := range []string{"xxxx", "xabc", "xdef"} {
+		for x := range line {
+			gray.SetGray(x, y, color.Gray{line[x]})
+		}
+	}
+	sub := gray.SubImage(image.Rect(1, 1, 4, 3))
+	if got := letters(applyOrientation(sub, 6)); got != "da/eb/fc" {
+		t.Errorf("got %s, want da/eb/fc", got)
+	}
+}
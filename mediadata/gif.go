@@ -0,0 +1,68 @@
+package md
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+)
+
+// gifScreen returns the logical screen of the gif, which frames are drawn onto
+func gifScreen(g *gif.GIF) image.Rectangle {
+	if g.Config.Width > 0 && g.Config.Height > 0 {
+		return image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	}
+	// broken header, make all frames fit
+	var screen image.Rectangle
+	for _, frame := range g.Image {
+		screen = screen.Union(frame.Bounds())
+	}
+	return image.Rect(0, 0, screen.Max.X, screen.Max.Y)
+}
+
+// gifBackground is what DisposalBackground restores to. Transparent
+// gifs are restored to transparency, which is what browsers do too,
+// everything else gets the background colour from the global palette.
+func gifBackground(g *gif.GIF) color.Color {
+	for _, frame := range g.Image {
+		for _, c := range frame.Palette {
+			if _, _, _, a := c.RGBA(); a == 0 {
+				return color.Transparent
+			}
+		}
+	}
+	if palette, ok := g.Config.ColorModel.(color.Palette); ok && int(g.BackgroundIndex) < len(palette) {
+		return palette[g.BackgroundIndex]
+	}
+	return color.Transparent
+}
+
+func composeGif(g *gif.GIF) *animation {
+	frames := make([]animFrame, len(g.Image))
+	for i, frame := range g.Image {
+		frames[i] = animFrame{
+			img:    frame,
+			bounds: frame.Bounds(),
+			blend:  true,
+		}
+		if i < len(g.Delay) {
+			frames[i].delay = g.Delay[i]
+		}
+		if i < len(g.Disposal) {
+			switch g.Disposal[i] {
+			case gif.DisposalBackground:
+				frames[i].dispose = disposeBackground
+			case gif.DisposalPrevious:
+				frames[i].dispose = disposePrevious
+			}
+		}
+	}
+	anim := composeFrames(gifScreen(g), gifBackground(g), frames)
+	// gif counts the repetitions after the first play
+	switch {
+	case g.LoopCount < 0:
+		anim.loopcount = 1
+	case g.LoopCount > 0:
+		anim.loopcount = g.LoopCount + 1
+	}
+	return anim
+}
@@ -0,0 +1,218 @@
+package md
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"strings"
+	"testing"
+)
+
+// the frames of the fixtures are drawn with letters, one per pixel
+var gifColors = map[byte]color.RGBA{
+	'.': {0, 0, 0, 0},
+	'W': {255, 255, 255, 255},
+	'R': {255, 0, 0, 255},
+	'G': {0, 255, 0, 255},
+	'B': {0, 0, 255, 255},
+}
+
+var (
+	opaquePalette      = color.Palette{gifColors['W'], gifColors['R'], gifColors['G'], gifColors['B']}
+	transparentPalette = color.Palette{gifColors['.'], gifColors['R'], gifColors['G'], gifColors['B']}
+)
+
+func rows(pixels string) []string {
+	return strings.Split(pixels, "/")
+}
+
+// gifFrame draws pixels like "RR/GG" at x, y of the logical screen
+func gifFrame(x, y int, palette color.Palette, pixels string) *image.Paletted {
+	lines := rows(pixels)
+	frame := image.NewPaletted(image.Rect(x, y, x+len(lines[0]), y+len(lines)), palette)
+	for dy, line := range lines {
+		for dx := range line {
+			frame.Set(x+dx, y+dy, gifColors[line[dx]])
+		}
+	}
+	return frame
+}
+
+type gifFixture struct {
+	name       string
+	width      int
+	height     int
+	palette    color.Palette
+	background byte // index into the palette
+	frames     []*image.Paletted
+	disposal   []byte
+	want       []string // every composed frame
+}
+
+var gifFixtures = []gifFixture{
+	{
+		name:  "none keeps the previous frame around smaller ones",
+		width: 4, height: 2,
+		palette: opaquePalette,
+		frames: []*image.Paletted{
+			gifFrame(0, 0, opaquePalette, "RRRR/RRRR"),
+			gifFrame(1, 0, opaquePalette, "GG"),
+			gifFrame(3, 1, opaquePalette, "B"),
+		},
+		disposal: []byte{gif.DisposalNone, gif.DisposalNone, gif.DisposalNone},
+		want:     []string{"RRRR/RRRR", "RGGR/RRRR", "RGGR/RRRB"},
+	},
+	{
+		name:  "background clears only the area of the frame",
+		width: 4, height: 2,
+		palette:    opaquePalette,
+		background: 0,
+		frames: []*image.Paletted{
+			gifFrame(0, 0, opaquePalette, "RRRR/RRRR"),
+			gifFrame(0, 0, opaquePalette, "GG/GG"),
+			gifFrame(3, 1, opaquePalette, "B"),
+		},
+		disposal: []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalNone},
+		want:     []string{"RRRR/RRRR", "GGRR/GGRR", "WWRR/WWRB"},
+	},
+	{
+		name:  "background of a transparent gif is transparent",
+		width: 3, height: 1,
+		palette: transparentPalette,
+		frames: []*image.Paletted{
+			gifFrame(0, 0, transparentPalette, "RGB"),
+			gifFrame(1, 0, transparentPalette, "R"),
+		},
+		disposal: []byte{gif.DisposalBackground, gif.DisposalNone},
+		want:     []string{"RGB", ".R."},
+	},
+	{
+		name:  "previous restores what was there before the frame",
+		width: 4, height: 2,
+		palette: opaquePalette,
+		frames: []*image.Paletted{
+			gifFrame(0, 0, opaquePalette, "RRRR/RRRR"),
+			gifFrame(1, 1, opaquePalette, "GG"),
+			gifFrame(0, 0, opaquePalette, "B"),
+		},
+		disposal: []byte{gif.DisposalNone, gif.DisposalPrevious, gif.DisposalNone},
+		want:     []string{"RRRR/RRRR", "RRRR/RGGR", "BRRR/RRRR"},
+	},
+	{
+		name:  "previous after background",
+		width: 2, height: 2,
+		palette:    opaquePalette,
+		background: 3,
+		frames: []*image.Paletted{
+			gifFrame(0, 0, opaquePalette, "RR/RR"),
+			gifFrame(1, 0, opaquePalette, "G/G"),
+			gifFrame(0, 1, opaquePalette, "W"),
+			gifFrame(0, 0, opaquePalette, "R"),
+		},
+		disposal: []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalPrevious, gif.DisposalNone},
+		want:     []string{"RR/RR", "RG/RG", "RB/WB", "RB/RB"},
+	},
+	{
+		name:  "transparent pixels show the frame below",
+		width: 3, height: 1,
+		palette: transparentPalette,
+		frames: []*image.Paletted{
+			gifFrame(0, 0, transparentPalette, "RRR"),
+			gifFrame(0, 0, transparentPalette, "G.B"),
+		},
+		disposal: []byte{gif.DisposalNone, gif.DisposalNone},
+		want:     []string{"RRR", "GRB"},
+	},
+}
+
+// roundtrip goes through the encoder and decoder, so the fixtures look
+// like what we get from a file
+func (fx gifFixture) roundtrip(t *testing.T) *gif.GIF {
+	t.Helper()
+	g := &gif.GIF{
+		Image:           fx.frames,
+		Delay:           make([]int, len(fx.frames)),
+		Disposal:        fx.disposal,
+		BackgroundIndex: fx.background,
+		Config: image.Config{
+			ColorModel: fx.palette,
+			Width:      fx.width,
+			Height:     fx.height,
+		},
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func compareFrame(t *testing.T, index int, got *image.RGBA, want string) {
+	t.Helper()
+	lines := rows(want)
+	if got.Rect != image.Rect(0, 0, len(lines[0]), len(lines)) {
+		t.Errorf("frame %d: bounds are %v, want the logical screen", index, got.Rect)
+		return
+	}
+	for y, line := range lines {
+		for x := range line {
+			if c := got.RGBAAt(x, y); c != gifColors[line[x]] {
+				t.Errorf("frame %d: pixel %d,%d is %v, want %c", index, x, y, c, line[x])
+			}
+		}
+	}
+}
+
+func TestComposeGif(t *testing.T) {
+	for _, fx := range gifFixtures {
+		t.Run(fx.name, func(t *testing.T) {
+			anim := composeGif(fx.roundtrip(t))
+			if len(anim.frames) != len(fx.want) {
+				t.Fatalf("got %d frames, want %d", len(anim.frames), len(fx.want))
+			}
+			for i, want := range fx.want {
+				compareFrame(t, i, anim.frames[i], want)
+			}
+		})
+	}
+}
+
+func TestComposeGifBrokenScreen(t *testing.T) {
+	// without a logical screen the frames decide how large it is
+	g := &gif.GIF{
+		Image: []*image.Paletted{
+			gifFrame(0, 0, opaquePalette, "RR"),
+			gifFrame(1, 1, opaquePalette, "GG"),
+		},
+		Delay:    []int{0, 0},
+		Disposal: []byte{gif.DisposalNone, gif.DisposalNone},
+	}
+	anim := composeGif(g)
+	compareFrame(t, 0, anim.frames[0], "RR./...")
+	compareFrame(t, 1, anim.frames[1], "RR./.GG")
+}
+
+func TestComposeGifTiming(t *testing.T) {
+	g := &gif.GIF{
+		Image: []*image.Paletted{
+			gifFrame(0, 0, opaquePalette, "R"),
+			gifFrame(0, 0, opaquePalette, "G"),
+		},
+		Delay:     []int{0, 25},
+		LoopCount: 2,
+		Config:    image.Config{ColorModel: opaquePalette, Width: 1, Height: 1},
+	}
+	anim := composeGif(g)
+	if anim.delays[0] != 10 || anim.delays[1] != 25 {
+		t.Errorf("delays are %v, want [10 25]", anim.delays)
+	}
+	// two repetitions after the first play
+	if anim.loopcount != 3 {
+		t.Errorf("loopcount is %d, want 3", anim.loopcount)
+	}
+}
@@ -0,0 +1,49 @@
+package md
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/storage"
+)
+
+// ImageInfo is what can be learned about an image without decoding it
+type ImageInfo struct {
+	Width    int // as displayed, after applying the orientation
+	Height   int
+	Captured time.Time // zero if the file does not say
+}
+
+// the exif data sits in front of the image data in all formats we read it
+// from, so a bit of the file is enough and a whole folder stays cheap
+const imageInfoReadLimit = 1024 * 1024
+
+// ReadImageInfo reads the dimensions and the capture date from the file header
+func ReadImageInfo(uri fyne.URI) (ImageInfo, error) {
+	res, err := storage.Reader(uri)
+	if err != nil {
+		return ImageInfo{}, err
+	}
+	defer res.Close()
+	data, err := io.ReadAll(io.LimitReader(res, imageInfoReadLimit))
+	if err != nil {
+		return ImageInfo{}, err
+	}
+
+	config, imageKind, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return ImageInfo{}, err
+	}
+	info := ImageInfo{Width: config.Width, Height: config.Height}
+	if meta := readMetadata(imageKind, data); meta != nil {
+		info.Captured = meta.CaptureTime
+		if meta.Orientation >= 5 {
+			// turned by 90 degrees
+			info.Width, info.Height = info.Height, info.Width
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,154 @@
+package md
+
+import (
+	"container/list"
+	"image"
+)
+
+type cacheEntry struct {
+	key  string
+	desc ImageDescriptor
+	size int64
+}
+
+// mediaCache is a byte accounted lru cache, the front
+// of the list is the most recently shown image
+type mediaCache struct {
+	entries   map[string]*list.Element
+	lru       *list.List
+	size      int64
+	budget    int64 // <= 0 means unlimited
+	protected map[string]struct{}
+}
+
+func newMediaCache(budget int64) *mediaCache {
+	return &mediaCache{
+		entries:   make(map[string]*list.Element),
+		lru:       list.New(),
+		budget:    budget,
+		protected: make(map[string]struct{}),
+	}
+}
+
+// get and put also work before the cache was initialised,
+// nothing is found and nothing is kept then
+func (mc *mediaCache) get(key string) (ImageDescriptor, bool) {
+	if mc == nil {
+		return ImageDescriptor{}, false
+	}
+	elem, ok := mc.entries[key]
+	if !ok {
+		return ImageDescriptor{}, false
+	}
+	mc.lru.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).desc, true
+}
+
+func (mc *mediaCache) put(key string, desc ImageDescriptor) {
+	if mc == nil {
+		return
+	}
+	size := descriptorSize(&desc)
+	if elem, ok := mc.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		mc.size += size - entry.size
+		entry.desc = desc
+		entry.size = size
+		mc.lru.MoveToFront(elem)
+	} else {
+		mc.entries[key] = mc.lru.PushFront(&cacheEntry{key: key, desc: desc, size: size})
+		mc.size += size
+	}
+	mc.evict()
+}
+
+// clear drops every entry but keeps the protected keys, they are still in use
+func (mc *mediaCache) clear() {
+	clear(mc.entries)
+	mc.lru.Init()
+	mc.size = 0
+}
+
+func (mc *mediaCache) remove(key string) {
+	elem, ok := mc.entries[key]
+	if !ok {
+		return
+	}
+	mc.removeElement(elem)
+}
+
+// removeFunc drops every entry drop is true for, protected or not
+func (mc *mediaCache) removeFunc(drop func(desc *ImageDescriptor) bool) {
+	for elem := mc.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if drop(&elem.Value.(*cacheEntry).desc) {
+			mc.removeElement(elem)
+		}
+		elem = next
+	}
+}
+
+func (mc *mediaCache) removeElement(elem *list.Element) {
+	entry := mc.lru.Remove(elem).(*cacheEntry)
+	delete(mc.entries, entry.key)
+	mc.size -= entry.size
+}
+
+// evict drops the least recently shown entries until we are within
+// the budget again. protected entries are skipped, so if they alone
+// are over budget we just stay over budget.
+func (mc *mediaCache) evict() {
+	if mc.budget <= 0 {
+		return
+	}
+	elem := mc.lru.Back()
+	for mc.size > mc.budget && elem != nil {
+		prev := elem.Prev()
+		if _, isprotected := mc.protected[elem.Value.(*cacheEntry).key]; !isprotected {
+			mc.removeElement(elem)
+		}
+		elem = prev
+	}
+}
+
+func (mc *mediaCache) setBudget(budget int64) {
+	mc.budget = budget
+	mc.evict()
+}
+
+func (mc *mediaCache) setProtected(keys []string) {
+	mc.protected = make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		mc.protected[key] = struct{}{}
+	}
+}
+
+// descriptorSize returns the decoded size in bytes of all frames
+func descriptorSize(desc *ImageDescriptor) int64 {
+	var size int64
+	for _, img := range desc.Images {
+		if img == nil || img.Image == nil {
+			continue
+		}
+		size += imageSize(img.Image)
+	}
+	return size
+}
+
+func imageSize(img image.Image) int64 {
+	switch img := img.(type) {
+	case *image.RGBA:
+		return int64(len(img.Pix))
+	case *image.NRGBA:
+		return int64(len(img.Pix))
+	case *image.Gray:
+		return int64(len(img.Pix))
+	case *image.Paletted:
+		return int64(len(img.Pix))
+	case *image.YCbCr:
+		return int64(len(img.Y) + len(img.Cb) + len(img.Cr))
+	}
+	// assume 4 bytes per pixel for everything else
+	bounds := img.Bounds()
+	return int64(bounds.Dx()) * int64(bounds.Dy()) * 4
+}
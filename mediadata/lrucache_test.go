@@ -0,0 +1,137 @@
+package md
+
+import (
+	"image"
+	"slices"
+	"testing"
+
+	"fyne.io/fyne/v2/canvas"
+)
+
+// descOfSize is an rgba image of size bytes, 4 per pixel
+func descOfSize(size int) ImageDescriptor {
+	img := image.NewRGBA(image.Rect(0, 0, size/4, 1))
+	return ImageDescriptor{Images: []*canvas.Image{{Image: img}}, valid: true}
+}
+
+// keys lists the cache from the most to the least recently shown
+func (mc *mediaCache) keys() []string {
+	var keys []string
+	for elem := mc.lru.Front(); elem != nil; elem = elem.Next() {
+		keys = append(keys, elem.Value.(*cacheEntry).key)
+	}
+	return keys
+}
+
+func TestCacheEvictionOrder(t *testing.T) {
+	mc := newMediaCache(300)
+	mc.put("a", descOfSize(100))
+	mc.put("b", descOfSize(100))
+	mc.put("c", descOfSize(100))
+	// a was shown again, so b is the oldest now
+	if _, ok := mc.get("a"); !ok {
+		t.Fatal("a is not cached")
+	}
+	mc.put("d", descOfSize(100))
+	if got, want := mc.keys(), []string{"d", "a", "c"}; !slices.Equal(got, want) {
+		t.Errorf("cached %v, want %v", got, want)
+	}
+
+	// a large one pushes out as many as it takes
+	mc.put("e", descOfSize(200))
+	if got, want := mc.keys(), []string{"e", "d"}; !slices.Equal(got, want) {
+		t.Errorf("cached %v, want %v", got, want)
+	}
+
+	// one over the budget on its own is not kept around
+	mc.put("f", descOfSize(400))
+	if got := mc.keys(); len(got) != 0 {
+		t.Errorf("cached %v, want nothing", got)
+	}
+}
+
+func TestCacheAccounting(t *testing.T) {
+	mc := newMediaCache(0)
+	check := func(what string, want int64) {
+		t.Helper()
+		if mc.size != want {
+			t.Errorf("%s: size is %d, want %d", what, mc.size, want)
+		}
+	}
+	mc.put("a", descOfSize(100))
+	mc.put("b", descOfSize(200))
+	check("put", 300)
+	mc.put("a", descOfSize(40))
+	check("replaced", 240)
+	mc.put("invalid", ImageDescriptor{})
+	check("invalid", 240)
+	mc.remove("b")
+	check("removed", 40)
+	mc.remove("missing")
+	check("removed missing", 40)
+	mc.put("c", descOfSize(400))
+	mc.removeFunc(func(desc *ImageDescriptor) bool { return desc.valid })
+	check("removed valid", 0)
+	if got := mc.keys(); !slices.Equal(got, []string{"invalid"}) {
+		t.Errorf("cached %v, want only invalid", got)
+	}
+
+	// unlimited until there is a budget
+	for _, key := range []string{"x", "y", "z"} {
+		mc.put(key, descOfSize(1000))
+	}
+	check("unlimited", 3000)
+	mc.setBudget(1500)
+	check("budget", 1000)
+	mc.clear()
+	check("clear", 0)
+	if len(mc.entries) != 0 || mc.lru.Len() != 0 {
+		t.Errorf("clear left %d entries", len(mc.entries))
+	}
+}
+
+func TestCacheProtected(t *testing.T) {
+	mc := newMediaCache(200)
+	mc.put("a", descOfSize(100))
+	mc.put("b", descOfSize(100))
+	mc.setProtected([]string{"a", "b"})
+	// they are kept even though they are the oldest, the new one goes instead
+	mc.put("c", descOfSize(100))
+	if got, want := mc.keys(), []string{"b", "a"}; !slices.Equal(got, want) {
+		t.Errorf("cached %v, want %v", got, want)
+	}
+
+	// the next call replaces them
+	mc.setProtected([]string{"a"})
+	mc.put("d", descOfSize(100))
+	if got, want := mc.keys(), []string{"d", "a"}; !slices.Equal(got, want) {
+		t.Errorf("cached %v, want %v", got, want)
+	}
+
+	// when they are over budget on their own, we stay over budget
+	mc.setProtected([]string{"a", "d"})
+	mc.setBudget(100)
+	if got, want := mc.keys(), []string{"d", "a"}; !slices.Equal(got, want) || mc.size != 200 {
+		t.Errorf("cached %v of %d bytes, want %v", got, mc.size, want)
+	}
+}
+
+func TestInvalidateImageCacheKeepsProtected(t *testing.T) {
+	var md MediaData
+	// nothing happens before the cache is there
+	if _, found := md.mediacache.get("a"); found {
+		t.Error("found a in no cache")
+	}
+	md.mediacache.put("a", descOfSize(100))
+
+	md.InitialiseImageCache()
+	md.SetCacheBudget(1)
+	md.ProtectFromEviction([]string{"shown"})
+	md.InvalidateImageCache()
+	if md.CacheUsage() != 0 {
+		t.Errorf("%d bytes left after invalidating", md.CacheUsage())
+	}
+	if _, ok := md.mediacache.protected["shown"]; !ok {
+		t.Error("invalidating dropped the protected set")
+	}
+}
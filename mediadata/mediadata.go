@@ -1,11 +1,13 @@
 package md
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"image"
-	"image/draw"
 	"image/gif"
+	"io"
 	"os"
 	"runtime"
 	"sync"
@@ -17,7 +19,8 @@ import (
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/storage"
-	"github.com/anthonynsimon/bild/transform"
+
+	afs "github.com/BieHDC/fic/archivefs"
 )
 
 type ImageType int
@@ -28,22 +31,34 @@ const (
 )
 
 type ImageDescriptor struct {
-	Type   ImageType
-	Images []*canvas.Image
-	Delays []int
-	valid  bool
+	Type      ImageType
+	Images    []*canvas.Image
+	Delays    []int
+	LoopCount int // how often an animation should be played, 0 is forever
+	// the size of the source, the cached images might be smaller
+	Width, Height int
+	Metadata      *Metadata // nil if the file has none
+	valid         bool
 }
 
 type MediaData struct {
-	mediacache map[string]ImageDescriptor
-	medialock  sync.RWMutex
-	iscaching  atomic.Bool
-	cacherlock sync.Mutex
+	mediacache  *mediaCache
+	medialock   sync.Mutex
+	cachebudget int64
+	iscaching   atomic.Bool
+	cacherlock  sync.Mutex
+	policy      decodePolicy
+	policylock  sync.Mutex
 }
 
 func (md *MediaData) InvalidateImageCache() {
 	md.medialock.Lock()
-	md.mediacache = make(map[string]ImageDescriptor)
+	if md.mediacache == nil {
+		md.mediacache = newMediaCache(md.cachebudget)
+	} else {
+		// the protected set stays, what is shown is still shown
+		md.mediacache.clear()
+	}
 	md.medialock.Unlock()
 }
 
@@ -51,6 +66,81 @@ func (md *MediaData) InitialiseImageCache() {
 	md.InvalidateImageCache()
 }
 
+// InvalidateImage drops a single file from the cache, for example
+// because it has changed on disk
+func (md *MediaData) InvalidateImage(uristring string) {
+	md.medialock.Lock()
+	if md.mediacache != nil {
+		md.mediacache.remove(uristring)
+		md.mediacache.remove(thumbnailCacheKey(uristring))
+	}
+	md.medialock.Unlock()
+}
+
+// InvalidateSizedDown drops the images that were sized down to less than
+// maxside, so they get decoded again at the larger size when shown next
+func (md *MediaData) InvalidateSizedDown(maxside int) {
+	md.medialock.Lock()
+	if md.mediacache != nil {
+		md.mediacache.removeFunc(func(desc *ImageDescriptor) bool {
+			if !desc.valid || len(desc.Images) == 0 || desc.Images[0].Image == nil {
+				return false
+			}
+			bounds := desc.Images[0].Image.Bounds()
+			cached := max(bounds.Dx(), bounds.Dy())
+			return cached < max(desc.Width, desc.Height) && cached < maxside
+		})
+	}
+	md.medialock.Unlock()
+}
+
+// KnownInvalid tells if we already tried the file and it was no image
+func (md *MediaData) KnownInvalid(uri fyne.URI) bool {
+	uristring := uri.String()
+	md.medialock.Lock()
+	defer md.medialock.Unlock()
+	if md.mediacache == nil {
+		return false
+	}
+	for _, key := range []string{uristring, thumbnailCacheKey(uristring)} {
+		if cache, found := md.mediacache.get(key); found && !cache.valid {
+			return true
+		}
+	}
+	return false
+}
+
+// SetCacheBudget sets the maximum amount of decoded image data in MB
+// we keep around, 0 means unlimited
+func (md *MediaData) SetCacheBudget(budget int64) {
+	md.medialock.Lock()
+	md.cachebudget = budget * 1024 * 1024
+	if md.mediacache != nil {
+		md.mediacache.setBudget(md.cachebudget)
+	}
+	md.medialock.Unlock()
+}
+
+// ProtectFromEviction marks the given uris as currently in use,
+// they will not be evicted until the next call replaces them
+func (md *MediaData) ProtectFromEviction(uris []string) {
+	md.medialock.Lock()
+	if md.mediacache != nil {
+		md.mediacache.setProtected(uris)
+	}
+	md.medialock.Unlock()
+}
+
+// CacheUsage returns the decoded size of all cached images in bytes
+func (md *MediaData) CacheUsage() int64 {
+	md.medialock.Lock()
+	defer md.medialock.Unlock()
+	if md.mediacache == nil {
+		return 0
+	}
+	return md.mediacache.size
+}
+
 func (md *MediaData) CancelCurrentCachetask() {
 	md.iscaching.Store(false)
 	md.cacherlock.Lock()
@@ -106,131 +196,210 @@ func (md *MediaData) CacheTask(filelist []fyne.URI, status func(string, bool), m
 	status(fmt.Sprintf("Caching done, took %0.2f seconds", time.Since(starttime).Seconds()), true)
 }
 
-func bToMb(b int64) int64 {
+var ErrFileTooLarge = errors.New("file too large")
+
+// ErrUndecodable means the file could be read, but is not an image we understand
+var ErrUndecodable = errors.New("invalid file")
+
+func undecodable(err error) error {
+	return fmt.Errorf("%w: %w", ErrUndecodable, err)
+}
+
+func BToMb(b int64) int64 {
 	return b / 1024 / 1024
 }
 
 func (md *MediaData) CacheImage(uri fyne.URI, maxfilesize int64) (*ImageDescriptor, error) {
+	return md.cacheImage(uri, maxfilesize, nil)
+}
+
+// cacheImage hands the image to decoded before it is sized down,
+// if it was not cached already and could be decoded
+func (md *MediaData) cacheImage(uri fyne.URI, maxfilesize int64, decoded func(source image.Image, orientation int)) (*ImageDescriptor, error) {
 	uristring := uri.String()
-	md.medialock.RLock()
-	cache, found := md.mediacache[uristring]
-	md.medialock.RUnlock()
+	md.medialock.Lock()
+	cache, found := md.mediacache.get(uristring)
+	md.medialock.Unlock()
 	if found {
 		if cache.valid {
 			return &cache, nil
 		} else {
-			return nil, fmt.Errorf("invalid file")
+			return nil, ErrUndecodable
 		}
 	}
 
-	file, err := os.Open(uri.Path())
+	sz, err := fileSize(uri)
 	if err != nil {
 		return nil, err
 	}
-
-	stat, err := file.Stat()
-	if err != nil || stat.IsDir() {
-		// it really shouldnt be able to be a dir at this point
-		return nil, err
-	}
-
-	sz := stat.Size()
 	maxsize := int64(1024 * 1024 * maxfilesize)
 	if sz > maxsize {
-		//fmt.Printf("%s -- %d\n", uri.Path(), bToMb(sz))
-		return nil, fmt.Errorf("file too large: %d MB", bToMb(sz))
+		//fmt.Printf("%s -- %d\n", uri.Path(), BToMb(sz))
+		return nil, fmt.Errorf("%w: %d MB", ErrFileTooLarge, BToMb(sz))
 	}
 
 	res, err := storage.Reader(uri)
 	if err != nil {
 		return nil, err
 	}
-
-	_, imageKind, err := image.DecodeConfig(res)
+	defer res.Close()
+	// the metadata and animation chunks need the raw file. having it in
+	// memory also keeps read errors apart from files we cannot decode.
+	data, err := io.ReadAll(res)
 	if err != nil {
-		res.Close()
 		return nil, err
 	}
-	res.Close()
-	//fmt.Println(uri.String(), "is a", imageKind)
 
-	// it is easier to reopen the filestream than anything else
-	res, err = storage.Reader(uri)
+	_, imageKind, err := image.DecodeConfig(bytes.NewReader(data))
 	if err != nil {
-		return nil, err
+		return nil, undecodable(err)
 	}
-	defer res.Close()
+	//fmt.Println(uri.String(), "is a", imageKind)
 
+	policy := md.decodePolicy()
 	imgdesc := ImageDescriptor{}
 	defer func() {
 		// works like charm
 		md.medialock.Lock()
-		md.mediacache[uristring] = imgdesc
+		md.mediacache.put(uristring, imgdesc)
 		//fmt.Println("cached", uri)
 		md.medialock.Unlock()
 	}()
 
 	switch imageKind {
 	case "gif":
-		//fixme should we resize large gifs too?
-		//would be trivial, but who generally has >10mb large gifs?
-		//would this impact quality too much then?
-		gogif, err := gif.DecodeAll(res)
+		gogif, err := gif.DecodeAll(bytes.NewReader(data))
 		if err != nil {
-			return nil, err
+			return nil, undecodable(err)
 		}
-
-		img := make([]*canvas.Image, len(gogif.Image))
-		dimension := image.Rect(0, 0, gogif.Image[0].Bounds().Dx(), gogif.Image[0].Bounds().Dy())
-		last := image.NewRGBA(dimension)
-		for i, frame := range gogif.Image {
-			// process image
-			current := image.NewRGBA(dimension)
-			draw.Draw(current, last.Bounds(), last, last.Rect.Min, draw.Src)
-			draw.Draw(current, frame.Bounds(), frame, frame.Rect.Min, draw.Over)
-			last = current
-
-			img[i] = canvas.NewImageFromImage(current)
-			img[i].FillMode = canvas.ImageFillContain
-			img[i].ScaleMode = canvas.ImageScaleSmooth
-
-			// fixup delays if required
-			if gogif.Delay[i] < 1 {
-				// fix gifs with no delay to the default of 10
-				// this is what other programs do
-				gogif.Delay[i] = 10
+		anim := composeGif(gogif)
+		if decoded != nil && len(anim.frames) > 0 {
+			decoded(anim.frames[0], 0)
+		}
+		imgdesc.setAnimation(anim, policy)
+
+	case "png", "webp":
+		// both can be animated, which the standard decoders do not know about
+		var anim *animation
+		if imageKind == "png" && isAPNG(data) {
+			anim, err = decodeAPNG(data)
+		} else if imageKind == "webp" && isAnimatedWebP(data) {
+			anim, err = decodeAnimatedWebP(data)
+		}
+		if err != nil {
+			return nil, undecodable(err)
+		}
+		if anim != nil {
+			if decoded != nil && len(anim.frames) > 0 {
+				decoded(anim.frames[0], 0)
 			}
+			imgdesc.setAnimation(anim, policy)
+			break
 		}
 
-		imgdesc.Type = ImageAnimated
-		imgdesc.Images = img
-		imgdesc.Delays = gogif.Delay
+		goimg, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, undecodable(err)
+		}
+		imgdesc.Metadata = readMetadata(imageKind, data)
+		if decoded != nil {
+			decoded(goimg, imgdesc.Metadata.orientation())
+		}
+		imgdesc.setStatic(goimg, policy)
 
 	default:
-		goimg, _, err := image.Decode(res)
+		goimg, _, err := image.Decode(bytes.NewReader(data))
 		if err != nil {
-			return nil, err
+			return nil, undecodable(err)
 		}
+		imgdesc.Metadata = readMetadata(imageKind, data)
+		if decoded != nil {
+			decoded(goimg, imgdesc.Metadata.orientation())
+		}
+		imgdesc.setStatic(goimg, policy)
+	}
+
+	imgdesc.valid = true
+	return &imgdesc, nil
+}
 
-		imgsizeX := goimg.Bounds().Dx()
-		imgsizeY := goimg.Bounds().Dy()
-		const maxSide = 1024 //fixme up to debate
-		if imgsizeX > maxSide || imgsizeY > maxSide {
-			newsizeX, newsizeY := calculateNewResolution(imgsizeX, imgsizeY, maxSide)
-			//fmt.Println("Sizing down", imgsizeX, "x", imgsizeY, "to", newsizeX, "x", newsizeY)
-			goimg = transform.Resize(goimg, newsizeX, newsizeY, transform.NearestNeighbor)
+// LoadFullResolution decodes the image without sizing it down. the
+// result is not cached, it is meant for looking at the details of a
+// single image and would blow the cache budget otherwise.
+func (md *MediaData) LoadFullResolution(uri fyne.URI) (image.Image, error) {
+	res, err := storage.Reader(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	data, err := io.ReadAll(res)
+	if err != nil {
+		return nil, err
+	}
+
+	goimg, imageKind, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if meta := readMetadata(imageKind, data); meta != nil {
+		goimg = applyOrientation(goimg, meta.Orientation)
+	}
+	return goimg, nil
+}
+
+func (imgdesc *ImageDescriptor) setStatic(goimg image.Image, policy decodePolicy) {
+	imgdesc.Width = goimg.Bounds().Dx()
+	imgdesc.Height = goimg.Bounds().Dy()
+
+	goimg = policy.fit(goimg)
+	if imgdesc.Metadata != nil {
+		// after sizing down there is a lot less to turn around
+		goimg = applyOrientation(goimg, imgdesc.Metadata.Orientation)
+		if imgdesc.Metadata.Orientation >= 5 {
+			imgdesc.Width, imgdesc.Height = imgdesc.Height, imgdesc.Width
 		}
+	}
+
+	img := canvas.NewImageFromImage(goimg)
+	img.FillMode = canvas.ImageFillContain
+	img.ScaleMode = canvas.ImageScaleSmooth
 
-		img := canvas.NewImageFromImage(goimg)
-		img.FillMode = canvas.ImageFillContain
-		img.ScaleMode = canvas.ImageScaleSmooth
+	imgdesc.Type = ImageStatic
+	imgdesc.Images = append(imgdesc.Images, img)
+}
 
-		imgdesc.Type = ImageStatic
-		imgdesc.Images = append(imgdesc.Images, img)
+func (imgdesc *ImageDescriptor) setAnimation(anim *animation, policy decodePolicy) {
+	img := make([]*canvas.Image, len(anim.frames))
+	for i, frame := range anim.frames {
+		img[i] = canvas.NewImageFromImage(policy.fit(frame))
+		img[i].FillMode = canvas.ImageFillContain
+		img[i].ScaleMode = canvas.ImageScaleSmooth
 	}
 
-	imgdesc.valid = true
-	return &imgdesc, nil
+	imgdesc.Type = ImageAnimated
+	imgdesc.Images = img
+	imgdesc.Delays = anim.delays
+	imgdesc.LoopCount = anim.loopcount
+	if len(anim.frames) > 0 {
+		imgdesc.Width = anim.frames[0].Rect.Dx()
+		imgdesc.Height = anim.frames[0].Rect.Dy()
+	}
+}
+
+func fileSize(uri fyne.URI) (int64, error) {
+	if afs.IsArchiveURI(uri) {
+		return afs.Size(uri)
+	}
+
+	stat, err := os.Stat(uri.Path())
+	if err != nil {
+		return 0, err
+	}
+	if stat.IsDir() {
+		// it really shouldnt be able to be a dir at this point
+		return 0, fmt.Errorf("is a directory")
+	}
+	return stat.Size(), nil
 }
 
 func calculateNewResolution(width, height, maxside int) (int, int) {
@@ -0,0 +1,64 @@
+package md
+
+import (
+	"image"
+
+	"github.com/anthonynsimon/bild/transform"
+)
+
+const DefaultMaxSide = 1024
+
+// the names of the filters that can be used to size images down
+var ResampleFilters = []string{"NearestNeighbor", "Linear", "CatmullRom", "Lanczos"}
+
+const DefaultResampleFilter = "Linear"
+
+func resampleFilter(name string) transform.ResampleFilter {
+	switch name {
+	case "NearestNeighbor":
+		return transform.NearestNeighbor
+	case "CatmullRom":
+		return transform.CatmullRom
+	case "Lanczos":
+		return transform.Lanczos
+	default:
+		return transform.Linear
+	}
+}
+
+// decodePolicy is how large decoded images are kept in the cache
+type decodePolicy struct {
+	maxside int
+	filter  transform.ResampleFilter
+}
+
+// SetDecodePolicy sets the longest side images are sized down to and
+// the filter used for it. Already cached images are not touched.
+func (md *MediaData) SetDecodePolicy(maxside int, filter string) {
+	md.policylock.Lock()
+	md.policy = decodePolicy{
+		maxside: max(maxside, 1),
+		filter:  resampleFilter(filter),
+	}
+	md.policylock.Unlock()
+}
+
+func (md *MediaData) decodePolicy() decodePolicy {
+	md.policylock.Lock()
+	defer md.policylock.Unlock()
+	if md.policy.maxside < 1 {
+		return decodePolicy{maxside: DefaultMaxSide, filter: resampleFilter(DefaultResampleFilter)}
+	}
+	return md.policy
+}
+
+// fit sizes the image down if any side is longer than allowed
+func (p decodePolicy) fit(img image.Image) image.Image {
+	width := img.Bounds().Dx()
+	height := img.Bounds().Dy()
+	if width <= p.maxside && height <= p.maxside {
+		return img
+	}
+	newwidth, newheight := calculateNewResolution(width, height, p.maxside)
+	return transform.Resize(img, max(newwidth, 1), max(newheight, 1), p.filter)
+}
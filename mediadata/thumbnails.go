@@ -0,0 +1,309 @@
+package md
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"github.com/anthonynsimon/bild/transform"
+)
+
+// https://specifications.freedesktop.org/thumbnail-spec/latest/
+
+type thumbnailFlavor struct {
+	name string
+	size int
+}
+
+var thumbnailFlavors = []thumbnailFlavor{
+	{"normal", 128},
+	{"large", 256},
+	{"x-large", 512},
+	{"xx-large", 1024},
+}
+
+// picks the smallest flavor that is at least size big
+func flavorForSize(size int) thumbnailFlavor {
+	for _, flavor := range thumbnailFlavors {
+		if flavor.size >= size {
+			return flavor
+		}
+	}
+	return thumbnailFlavors[len(thumbnailFlavors)-1]
+}
+
+func thumbnailRoot() string {
+	cache := os.Getenv("XDG_CACHE_HOME")
+	if cache == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		cache = filepath.Join(home, ".cache")
+	}
+	return filepath.Join(cache, "thumbnails")
+}
+
+// the name our fail markers are stored under
+func failFolderName() string {
+	version := ""
+	if app := fyne.CurrentApp(); app != nil {
+		version = app.Metadata().Version
+	}
+	if version == "" {
+		version = "0.1.1"
+	}
+	return "fic-" + version
+}
+
+// the spec wants the escaped uri, which fyne does not give us
+func canonicalURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+func thumbnailName(canonical string) string {
+	sum := md5.Sum([]byte(canonical))
+	return hex.EncodeToString(sum[:]) + ".png"
+}
+
+type thumbnailKey struct {
+	path      string // of the original file
+	canonical string
+	mtime     int64
+}
+
+func newThumbnailKey(uri fyne.URI) (*thumbnailKey, error) {
+	if uri.Scheme() != "file" {
+		return nil, fmt.Errorf("thumbnails are only supported for local files")
+	}
+	stat, err := os.Stat(uri.Path())
+	if err != nil {
+		return nil, err
+	}
+	path, err := filepath.Abs(uri.Path())
+	if err != nil {
+		return nil, err
+	}
+	return &thumbnailKey{
+		path:      path,
+		canonical: canonicalURI(path),
+		mtime:     stat.ModTime().Unix(),
+	}, nil
+}
+
+// load returns the thumbnail if it is present and not stale
+func (tk *thumbnailKey) load(flavor thumbnailFlavor) (image.Image, error) {
+	root := thumbnailRoot()
+	if root == "" {
+		return nil, fmt.Errorf("no thumbnail directory")
+	}
+	// thumbnails in the place of the original file are not supported,
+	// they might be stale without us being able to tell
+	return readThumbnail(filepath.Join(root, flavor.name, thumbnailName(tk.canonical)), tk)
+}
+
+// hasFailed reports if we have already failed on this exact file
+func (tk *thumbnailKey) hasFailed() bool {
+	root := thumbnailRoot()
+	if root == "" {
+		return false
+	}
+	_, err := readThumbnail(filepath.Join(root, "fail", failFolderName(), thumbnailName(tk.canonical)), tk)
+	return err == nil
+}
+
+// store sizes the source down to the flavor, what we show is sized
+// for the window and might be smaller than the flavor asks for
+func (tk *thumbnailKey) store(flavor thumbnailFlavor, source image.Image, orientation int) error {
+	root := thumbnailRoot()
+	if root == "" {
+		return fmt.Errorf("no thumbnail directory")
+	}
+
+	img := source
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > flavor.size || height > flavor.size {
+		newwidth, newheight := calculateNewResolution(width, height, flavor.size)
+		img = transform.Resize(img, max(newwidth, 1), max(newheight, 1), transform.Linear)
+	}
+	img = applyOrientation(img, orientation)
+
+	return writeThumbnail(filepath.Join(root, flavor.name), thumbnailName(tk.canonical), img, tk)
+}
+
+func (tk *thumbnailKey) storeFailed() error {
+	root := thumbnailRoot()
+	if root == "" {
+		return fmt.Errorf("no thumbnail directory")
+	}
+	// the spec wants an empty png for failures
+	return writeThumbnail(filepath.Join(root, "fail", failFolderName()), thumbnailName(tk.canonical), image.NewNRGBA(image.Rect(0, 0, 1, 1)), tk)
+}
+
+func readThumbnail(path string, tk *thumbnailKey) (image.Image, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	texts, err := pngTextChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	if texts["Thumb::URI"] != tk.canonical {
+		return nil, fmt.Errorf("thumbnail belongs to a different file")
+	}
+	mtime, err := strconv.ParseInt(texts["Thumb::MTime"], 10, 64)
+	if err != nil || mtime != tk.mtime {
+		return nil, fmt.Errorf("thumbnail is stale")
+	}
+
+	return png.Decode(bytes.NewReader(data))
+}
+
+// pngTextChunks collects all tEXt chunks in front of the image data
+func pngTextChunks(data []byte) (map[string]string, error) {
+	texts := make(map[string]string)
+	err := forEachPNGChunk(data, func(chunktype string, chunk []byte) bool {
+		switch chunktype {
+		case "tEXt":
+			keyword, text, found := bytes.Cut(chunk, []byte{0})
+			if found {
+				texts[string(keyword)] = string(text)
+			}
+		case "IDAT", "IEND":
+			// the spec says the metadata must be in front of the data
+			return false
+		}
+		return true
+	})
+	return texts, err
+}
+
+func pngTextChunk(keyword, text string) []byte {
+	data := append([]byte(keyword), 0)
+	return pngChunk("tEXt", append(data, text...))
+}
+
+func writeThumbnail(dir, name string, img image.Image, tk *thumbnailKey) error {
+	var encoded bytes.Buffer
+	err := png.Encode(&encoded, img)
+	if err != nil {
+		return err
+	}
+
+	// the image/png encoder always writes IHDR first, the text goes right after it
+	const ihdrEnd = 8 + 12 + 13
+	data := encoded.Bytes()
+	if len(data) < ihdrEnd {
+		return fmt.Errorf("encoded png is too short")
+	}
+	final := make([]byte, 0, len(data)+256)
+	final = append(final, data[:ihdrEnd]...)
+	final = append(final, pngTextChunk("Thumb::URI", tk.canonical)...)
+	final = append(final, pngTextChunk("Thumb::MTime", strconv.FormatInt(tk.mtime, 10))...)
+	if stat, err := os.Stat(tk.path); err == nil {
+		final = append(final, pngTextChunk("Thumb::Size", strconv.FormatInt(stat.Size(), 10))...)
+	}
+	final = append(final, pngTextChunk("Software", "Fast Image Cycler")...)
+	final = append(final, data[ihdrEnd:]...)
+
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file and rename it, so other programs never see half a thumbnail
+	tmp, err := os.CreateTemp(dir, "fic-*.png")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(final)
+	if err == nil {
+		err = tmp.Chmod(0600)
+	}
+	err = errors.Join(err, tmp.Close())
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, name))
+}
+
+func thumbnailCacheKey(uristring string) string {
+	return "thumbnail:" + uristring
+}
+
+// CacheThumbnail returns a preview of at least size pixels. If the
+// full image is cached already that is returned, otherwise the on-disk
+// thumbnail cache is asked before we fall back to decoding the file.
+func (md *MediaData) CacheThumbnail(uri fyne.URI, maxfilesize int64, size int) (*ImageDescriptor, error) {
+	uristring := uri.String()
+	thumbkey := thumbnailCacheKey(uristring)
+	md.medialock.Lock()
+	cache, found := md.mediacache.get(uristring)
+	if !found {
+		cache, found = md.mediacache.get(thumbkey)
+	}
+	md.medialock.Unlock()
+	if found {
+		if cache.valid {
+			return &cache, nil
+		} else {
+			return nil, ErrUndecodable
+		}
+	}
+
+	tk, err := newThumbnailKey(uri)
+	if err != nil {
+		// not a local file, nothing we can do here
+		return md.CacheImage(uri, maxfilesize)
+	}
+	if tk.hasFailed() {
+		md.medialock.Lock()
+		md.mediacache.put(thumbkey, ImageDescriptor{})
+		md.medialock.Unlock()
+		return nil, ErrUndecodable
+	}
+
+	flavor := flavorForSize(size)
+	thumb, err := tk.load(flavor)
+	if err == nil {
+		img := canvas.NewImageFromImage(thumb)
+		img.FillMode = canvas.ImageFillContain
+		img.ScaleMode = canvas.ImageScaleSmooth
+		imgdesc := ImageDescriptor{
+			Type:   ImageStatic,
+			Images: []*canvas.Image{img},
+			valid:  true,
+		}
+		md.medialock.Lock()
+		md.mediacache.put(thumbkey, imgdesc)
+		md.medialock.Unlock()
+		return &imgdesc, nil
+	}
+
+	imgdesc, err := md.cacheImage(uri, maxfilesize, func(source image.Image, orientation int) {
+		// there is nothing to do about failing to write it
+		tk.store(flavor, source, orientation)
+	})
+	if err != nil {
+		// only a file we cannot decode is broken for good, read errors
+		// can go away and the size limit is a setting
+		if errors.Is(err, ErrUndecodable) {
+			tk.storeFailed()
+		}
+		return nil, err
+	}
+	return imgdesc, nil
+}
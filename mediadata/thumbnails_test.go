@@ -0,0 +1,184 @@
+package md
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/test"
+)
+
+func writePNG(t *testing.T, path string, width, height int) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, image.NewNRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// thumbnailTest makes a picture and a thumbnail cache of its own
+func thumbnailTest(t *testing.T, width, height int) (string, string) {
+	test.NewApp()
+	cache := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cache)
+	path := filepath.Join(t.TempDir(), "a picture.png")
+	writePNG(t, path, width, height)
+	return path, filepath.Join(cache, "thumbnails")
+}
+
+func keyFor(t *testing.T, path string) *thumbnailKey {
+	tk, err := newThumbnailKey(storage.NewFileURI(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tk
+}
+
+// touch moves the mtime on by a whole second, which is what the spec stores
+func touch(t *testing.T, path string) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := stat.ModTime().Add(2 * time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestThumbnailRoundTrip(t *testing.T) {
+	path, root := thumbnailTest(t, 10, 10)
+	tk := keyFor(t, path)
+	if want := "file://" + filepath.ToSlash(filepath.Dir(path)) + "/a%20picture.png"; tk.canonical != want {
+		t.Errorf("the uri is %s, want %s", tk.canonical, want)
+	}
+
+	flavor := flavorForSize(100)
+	if _, err := tk.load(flavor); err == nil {
+		t.Error("loaded a thumbnail that was never stored")
+	}
+	if err := tk.store(flavor, image.NewNRGBA(image.Rect(0, 0, 400, 200)), 0); err != nil {
+		t.Fatal(err)
+	}
+	thumb, err := tk.load(flavor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := thumb.Bounds().Size(); size != image.Pt(128, 64) {
+		t.Errorf("the thumbnail is %v, want it sized down to 128", size)
+	}
+
+	// what other programs look at
+	data, err := os.ReadFile(filepath.Join(root, "normal", thumbnailName(tk.canonical)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	texts, err := pngTextChunks(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if texts["Thumb::URI"] != tk.canonical {
+		t.Errorf("Thumb::URI is %q, want %q", texts["Thumb::URI"], tk.canonical)
+	}
+	if texts["Thumb::MTime"] != strconv.FormatInt(tk.mtime, 10) {
+		t.Errorf("Thumb::MTime is %q, want %d", texts["Thumb::MTime"], tk.mtime)
+	}
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		t.Errorf("the text chunks broke the png: %v", err)
+	}
+}
+
+func TestThumbnailStale(t *testing.T) {
+	path, _ := thumbnailTest(t, 10, 10)
+	flavor := flavorForSize(100)
+	if err := keyFor(t, path).store(flavor, image.NewNRGBA(image.Rect(0, 0, 10, 10)), 0); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, path)
+	if _, err := keyFor(t, path).load(flavor); err == nil {
+		t.Error("the thumbnail was used after the file changed")
+	}
+
+	// a thumbnail of another file under the same name is not used either
+	other := keyFor(t, path)
+	other.canonical = "file:///somewhere/else.png"
+	other.store(flavor, image.NewNRGBA(image.Rect(0, 0, 10, 10)), 0)
+	os.Rename(
+		filepath.Join(thumbnailRoot(), flavor.name, thumbnailName(other.canonical)),
+		filepath.Join(thumbnailRoot(), flavor.name, thumbnailName(keyFor(t, path).canonical)),
+	)
+	if _, err := keyFor(t, path).load(flavor); err == nil {
+		t.Error("the thumbnail of another file was used")
+	}
+}
+
+func TestThumbnailFailMarker(t *testing.T) {
+	path, root := thumbnailTest(t, 10, 10)
+	tk := keyFor(t, path)
+	if tk.hasFailed() {
+		t.Fatal("failed before trying")
+	}
+	if err := tk.storeFailed(); err != nil {
+		t.Fatal(err)
+	}
+	if !keyFor(t, path).hasFailed() {
+		t.Error("the fail marker was not found")
+	}
+	// the spec wants them in a folder of their own per program
+	if _, err := os.Stat(filepath.Join(root, "fail", failFolderName(), thumbnailName(tk.canonical))); err != nil {
+		t.Error(err)
+	}
+	// the file might be fixed now
+	touch(t, path)
+	if keyFor(t, path).hasFailed() {
+		t.Error("the fail marker was used after the file changed")
+	}
+}
+
+// TestCacheThumbnail checks the thumbnail is made from the source, the
+// cached image is sized for the window and can be smaller than it
+func TestCacheThumbnail(t *testing.T) {
+	path, root := thumbnailTest(t, 1200, 600)
+	var md MediaData
+	md.InitialiseImageCache()
+	md.SetDecodePolicy(300, DefaultResampleFilter)
+
+	uri := storage.NewFileURI(path)
+	imgdesc, err := md.CacheThumbnail(uri, 100, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width := imgdesc.Images[0].Image.Bounds().Dx(); width != 300 {
+		t.Errorf("the cached image is %d wide, want 300", width)
+	}
+	thumb, err := keyFor(t, path).load(flavorForSize(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := thumb.Bounds().Size(); size != image.Pt(1024, 512) {
+		t.Errorf("the thumbnail is %v, want 1024x512", size)
+	}
+
+	// files we can not decode get a fail marker
+	broken := filepath.Join(filepath.Dir(path), "broken.png")
+	os.WriteFile(broken, []byte("not a png"), 0644)
+	if _, err := md.CacheThumbnail(storage.NewFileURI(broken), 100, 128); !errors.Is(err, ErrUndecodable) {
+		t.Errorf("decoding a broken file gave %v", err)
+	}
+	if !keyFor(t, broken).hasFailed() {
+		t.Error("there is no fail marker for the broken file")
+	}
+	if _, err := os.Stat(filepath.Join(root, "normal", thumbnailName(keyFor(t, broken).canonical))); err == nil {
+		t.Error("the broken file got a thumbnail")
+	}
+}
@@ -0,0 +1,177 @@
+package md
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+
+	"golang.org/x/image/webp"
+)
+
+// https://developers.google.com/speed/webp/docs/riff_container
+
+const webpFlagAnimation = 0x02
+const webpFlagAlpha = 0x10
+
+// forEachWebPChunk calls fn for every chunk until it returns false
+func forEachWebPChunk(data []byte, fn func(fourcc string, chunk []byte) bool) {
+	for len(data) >= 8 {
+		size := binary.LittleEndian.Uint32(data[4:8])
+		if uint64(size)+8 > uint64(len(data)) {
+			return
+		}
+		if !fn(string(data[0:4]), data[8:8+size]) {
+			return
+		}
+		// chunks are padded to an even size
+		next := 8 + uint64(size) + uint64(size&1)
+		if next > uint64(len(data)) {
+			return
+		}
+		data = data[next:]
+	}
+}
+
+func webpChunks(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("not a webp")
+	}
+	return data[12:], nil
+}
+
+func webpChunk(fourcc string, data []byte) []byte {
+	chunk := make([]byte, 0, len(data)+9)
+	chunk = append(chunk, fourcc...)
+	chunk = binary.LittleEndian.AppendUint32(chunk, uint32(len(data)))
+	chunk = append(chunk, data...)
+	if len(data)&1 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func uint24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
+
+func putUint24(b []byte, v int) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
+
+func isAnimatedWebP(data []byte) bool {
+	chunks, err := webpChunks(data)
+	if err != nil {
+		return false
+	}
+	animated := false
+	forEachWebPChunk(chunks, func(fourcc string, chunk []byte) bool {
+		if fourcc == "VP8X" && len(chunk) >= 1 {
+			animated = chunk[0]&webpFlagAnimation != 0
+		}
+		return false
+	})
+	return animated
+}
+
+// webpFrameFile wraps the bitstream of a single frame into a file the
+// decoder understands, frames without a bitstream end up being rejected
+func webpFrameFile(width, height int, framedata []byte) []byte {
+	var alph, bitstream []byte
+	forEachWebPChunk(framedata, func(fourcc string, chunk []byte) bool {
+		switch fourcc {
+		case "ALPH":
+			alph = webpChunk(fourcc, chunk)
+		case "VP8 ", "VP8L":
+			bitstream = webpChunk(fourcc, chunk)
+			return false
+		}
+		return true
+	})
+
+	body := []byte("WEBP")
+	if alph != nil && bytes.HasPrefix(bitstream, []byte("VP8 ")) {
+		// lossy with alpha needs the extended header
+		vp8x := make([]byte, 10)
+		vp8x[0] = webpFlagAlpha
+		putUint24(vp8x[4:7], width-1)
+		putUint24(vp8x[7:10], height-1)
+		body = append(body, webpChunk("VP8X", vp8x)...)
+		body = append(body, alph...)
+	}
+	body = append(body, bitstream...)
+
+	file := []byte("RIFF")
+	file = binary.LittleEndian.AppendUint32(file, uint32(len(body)))
+	return append(file, body...)
+}
+
+func decodeAnimatedWebP(data []byte) (*animation, error) {
+	chunks, err := webpChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var screen image.Rectangle
+	var loopcount int
+	var frames []animFrame
+	var frameerr error
+	forEachWebPChunk(chunks, func(fourcc string, chunk []byte) bool {
+		switch fourcc {
+		case "VP8X":
+			if len(chunk) < 10 {
+				frameerr = fmt.Errorf("webp header too short")
+				return false
+			}
+			screen = image.Rect(0, 0, uint24(chunk[4:7])+1, uint24(chunk[7:10])+1)
+		case "ANIM":
+			if len(chunk) >= 6 {
+				loopcount = int(binary.LittleEndian.Uint16(chunk[4:6]))
+			}
+		case "ANMF":
+			if len(chunk) < 16+8 {
+				frameerr = fmt.Errorf("webp frame too short")
+				return false
+			}
+			x := uint24(chunk[0:3]) * 2
+			y := uint24(chunk[3:6]) * 2
+			width := uint24(chunk[6:9]) + 1
+			height := uint24(chunk[9:12]) + 1
+			duration := uint24(chunk[12:15])
+			flags := chunk[15]
+
+			img, err := webp.Decode(bytes.NewReader(webpFrameFile(width, height, chunk[16:])))
+			if err != nil {
+				frameerr = fmt.Errorf("webp frame %d: %w", len(frames), err)
+				return false
+			}
+
+			frame := animFrame{
+				img:    img,
+				bounds: image.Rect(x, y, x+width, y+height),
+				blend:  flags&0x02 == 0,
+				delay:  (duration + 5) / 10,
+			}
+			if flags&0x01 != 0 {
+				frame.dispose = disposeBackground
+			}
+			frames = append(frames, frame)
+		}
+		return true
+	})
+	if frameerr != nil {
+		return nil, frameerr
+	}
+	if len(frames) < 1 {
+		return nil, fmt.Errorf("webp animation without frames")
+	}
+
+	// the background colour of the ANIM chunk is only a hint,
+	// browsers use transparency and so do we
+	anim := composeFrames(screen, color.Transparent, frames)
+	anim.loopcount = loopcount
+	return anim, nil
+}
@@ -0,0 +1,225 @@
+package md
+
+import (
+	"encoding/binary"
+	"image/color"
+	"slices"
+	"testing"
+)
+
+// bitWriter writes the least significant bits first, like vp8l wants them
+type bitWriter struct {
+	data  []byte
+	nbits uint
+}
+
+func (bw *bitWriter) write(value uint32, n uint) {
+	for i := range n {
+		if bw.nbits%8 == 0 {
+			bw.data = append(bw.data, 0)
+		}
+		if value&(1<<i) != 0 {
+			bw.data[len(bw.data)-1] |= 1 << (bw.nbits % 8)
+		}
+		bw.nbits++
+	}
+}
+
+// encodeVP8L writes a lossless bitstream of the pixels. the fixture colors
+// only use 0 and 255 for every channel, so each channel gets a simple prefix
+// code of at most two symbols and every pixel is written as a literal.
+func encodeVP8L(pixels string) []byte {
+	lines := rows(pixels)
+	width, height := len(lines[0]), len(lines)
+	var colors []color.RGBA
+	for _, line := range lines {
+		for x := range line {
+			colors = append(colors, gifColors[line[x]])
+		}
+	}
+	// in the order of the prefix codes
+	channels := []func(c color.RGBA) uint8{
+		func(c color.RGBA) uint8 { return c.G },
+		func(c color.RGBA) uint8 { return c.R },
+		func(c color.RGBA) uint8 { return c.B },
+		func(c color.RGBA) uint8 { return c.A },
+	}
+
+	var bw bitWriter
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	bw.write(1, 1) // alpha is used
+	bw.write(0, 3) // version
+	bw.write(0, 1) // no transforms
+	bw.write(0, 1) // no color cache
+	bw.write(0, 1) // no meta prefix codes
+
+	used := make([][]uint8, len(channels))
+	for i, channel := range channels {
+		for _, c := range colors {
+			value := channel(c)
+			if len(used[i]) == 0 || (len(used[i]) == 1 && used[i][0] != value) {
+				used[i] = append(used[i], value)
+			}
+		}
+		// sorted, so the listing order and the canonical order agree
+		slices.Sort(used[i])
+		bw.write(1, 1) // simple code
+		bw.write(uint32(len(used[i])-1), 1)
+		bw.write(1, 1) // the first symbol has 8 bits
+		for _, symbol := range used[i] {
+			bw.write(uint32(symbol), 8)
+		}
+	}
+	// distance, never used
+	bw.write(1, 1)
+	bw.write(0, 1)
+	bw.write(0, 1)
+	bw.write(0, 1)
+
+	for _, c := range colors {
+		for i, channel := range channels {
+			if len(used[i]) < 2 {
+				// a single symbol takes no bits
+				continue
+			}
+			bit := uint32(0)
+			if channel(c) == used[i][1] {
+				bit = 1
+			}
+			bw.write(bit, 1)
+		}
+	}
+	return bw.data
+}
+
+type webpTestFrame struct {
+	x, y      int // have to be even
+	pixels    string
+	blend     bool
+	disposebg bool
+}
+
+type webpFixture struct {
+	name          string
+	width, height int
+	frames        []webpTestFrame
+	want          []string
+}
+
+var webpFixtures = []webpFixture{
+	{
+		name:  "blending shows the frame below through transparent pixels",
+		width: 4, height: 1,
+		frames: []webpTestFrame{
+			{0, 0, "RRRR", false, false},
+			{2, 0, "G.", true, false},
+		},
+		want: []string{"RRRR", "RRGR"},
+	},
+	{
+		name:  "without blending the area is replaced, transparent pixels too",
+		width: 4, height: 1,
+		frames: []webpTestFrame{
+			{0, 0, "RRRR", false, false},
+			{2, 0, "G.", false, false},
+		},
+		want: []string{"RRRR", "RRG."},
+	},
+	{
+		name:  "disposing clears the area of the frame to transparent",
+		width: 4, height: 2,
+		frames: []webpTestFrame{
+			{0, 0, "RRRR/RRRR", false, false},
+			{0, 0, "GG", false, true},
+			{2, 0, "BB/BB", true, false},
+		},
+		want: []string{"RRRR/RRRR", "GGRR/RRRR", "..BB/RRBB"},
+	},
+	{
+		name:  "frames below the top left",
+		width: 4, height: 4,
+		frames: []webpTestFrame{
+			{0, 0, "..../..../..../....", false, false},
+			{2, 2, "RG/BW", true, false},
+		},
+		want: []string{"..../..../..../....", "..../..../..RG/..BW"},
+	},
+}
+
+// encode writes an animated webp, every frame shows for duration milliseconds
+func (fx webpFixture) encode(loops, duration int) []byte {
+	vp8x := make([]byte, 10)
+	vp8x[0] = webpFlagAnimation | webpFlagAlpha
+	putUint24(vp8x[4:7], fx.width-1)
+	putUint24(vp8x[7:10], fx.height-1)
+	body := append([]byte("WEBP"), webpChunk("VP8X", vp8x)...)
+
+	// a white background, which we do not use
+	anim := []byte{255, 255, 255, 255}
+	anim = binary.LittleEndian.AppendUint16(anim, uint16(loops))
+	body = append(body, webpChunk("ANIM", anim)...)
+
+	for _, frame := range fx.frames {
+		lines := rows(frame.pixels)
+		anmf := make([]byte, 16)
+		putUint24(anmf[0:3], frame.x/2)
+		putUint24(anmf[3:6], frame.y/2)
+		putUint24(anmf[6:9], len(lines[0])-1)
+		putUint24(anmf[9:12], len(lines)-1)
+		putUint24(anmf[12:15], duration)
+		if !frame.blend {
+			anmf[15] |= 0x02
+		}
+		if frame.disposebg {
+			anmf[15] |= 0x01
+		}
+		anmf = append(anmf, webpChunk("VP8L", encodeVP8L(frame.pixels))...)
+		body = append(body, webpChunk("ANMF", anmf)...)
+	}
+
+	file := []byte("RIFF")
+	file = binary.LittleEndian.AppendUint32(file, uint32(len(body)))
+	return append(file, body...)
+}
+
+func TestDecodeAnimatedWebP(t *testing.T) {
+	for _, fx := range webpFixtures {
+		t.Run(fx.name, func(t *testing.T) {
+			data := fx.encode(0, 100)
+			if !isAnimatedWebP(data) {
+				t.Fatal("not detected as animated")
+			}
+			anim, err := decodeAnimatedWebP(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(anim.frames) != len(fx.want) {
+				t.Fatalf("got %d frames, want %d", len(anim.frames), len(fx.want))
+			}
+			for i, want := range fx.want {
+				compareFrame(t, i, anim.frames[i], want)
+			}
+		})
+	}
+}
+
+func TestDecodeAnimatedWebPTiming(t *testing.T) {
+	fx := webpFixtures[0]
+	for _, loops := range []int{0, 1, 3} {
+		anim, err := decodeAnimatedWebP(fx.encode(loops, 250))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 0 is forever, like ours
+		if anim.loopcount != loops {
+			t.Errorf("loopcount is %d, want %d", anim.loopcount, loops)
+		}
+		for i, delay := range anim.delays {
+			if delay != 25 {
+				t.Errorf("frame %d: delay is %d, want 25", i, delay)
+			}
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package md
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// https://developer.adobe.com/xmp/docs/XMPNamespaces/xmp/
+
+const (
+	xmpNamespace = "http://ns.adobe.com/xap/1.0/"
+	rdfNamespace = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	// for what the standard has nothing for, other tools leave it alone
+	ficNamespace = "https://github.com/BieHDC/fic/xmp/1.0/"
+)
+
+// the labels other tools know about, anything else is kept as is
+var Labels = []string{"Red", "Yellow", "Green", "Blue", "Purple"}
+
+type Rating struct {
+	Stars int    // 0 to 5, 0 is unrated and -1 rejected
+	Label string // empty if there is none
+	Kept  bool   // picked while culling
+}
+
+// SidecarPath returns the sidecar of the file at path. we look for the
+// photo.xmp other tools write, then photo.jpg.xmp, and if there is
+// neither, a new one gets the first name.
+func SidecarPath(path string) string {
+	candidates := []string{
+		strings.TrimSuffix(path, filepath.Ext(path)) + ".xmp",
+		path + ".xmp",
+	}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return candidates[0]
+}
+
+// ReadRating returns the rating from the sidecar of the file at path,
+// no sidecar is no rating
+func ReadRating(path string) (Rating, error) {
+	data, err := os.ReadFile(SidecarPath(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return Rating{}, nil
+	}
+	if err != nil {
+		return Rating{}, err
+	}
+	return parseRating(data)
+}
+
+func parseRating(data []byte) (Rating, error) {
+	var rating Rating
+	set := func(name xml.Name, value string) {
+		value = strings.TrimSpace(value)
+		switch name {
+		case xml.Name{Space: xmpNamespace, Local: "Rating"}:
+			// some write fractions, negative ones mean rejected
+			stars, err := strconv.ParseFloat(value, 64)
+			if err == nil && stars < 0 {
+				rating.Stars = -1
+			} else if err == nil {
+				rating.Stars = min(int(stars), 5)
+			}
+		case xml.Name{Space: xmpNamespace, Local: "Label"}:
+			rating.Label = value
+		case xml.Name{Space: ficNamespace, Local: "Kept"}:
+			rating.Kept = strings.EqualFold(value, "True")
+		}
+	}
+
+	// the decoder resolves the prefixes, whatever the file bound them to
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var inside xml.Name // the property we are reading the text of
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return rating, nil
+		}
+		if err != nil {
+			return rating, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			for _, attr := range tok.Attr {
+				set(attr.Name, attr.Value)
+			}
+			inside = tok.Name
+		case xml.CharData:
+			set(inside, string(tok))
+		case xml.EndElement:
+			inside = xml.Name{}
+		}
+	}
+}
+
+const newSidecar = `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/"/>
+ </rdf:RDF>
+</x:xmpmeta>
+`
+
+// WriteRating puts the rating into the sidecar of the file at path,
+// everything else in there is left alone
+func WriteRating(path string, rating Rating) error {
+	sidecar := SidecarPath(path)
+	data, err := os.ReadFile(sidecar)
+	perm := os.FileMode(0o644)
+	if errors.Is(err, os.ErrNotExist) {
+		data = []byte(newSidecar)
+	} else if err != nil {
+		return err
+	} else if info, err := os.Stat(sidecar); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	updated, err := setRating(data, rating)
+	if err != nil {
+		return fmt.Errorf("%s: %w", sidecar, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(sidecar), ".fic-*.xmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(updated)
+	if closeerr := tmp.Close(); err == nil {
+		err = closeerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), sidecar)
+}
+
+// sidecarProperty is something of the rating we write into the sidecar
+type sidecarProperty struct {
+	name   xml.Name            // with the namespace uri as space
+	prefix string              // used if the file has none bound to the namespace
+	value  func(Rating) string // empty leaves it out
+}
+
+var sidecarProperties = []sidecarProperty{
+	{xml.Name{Space: xmpNamespace, Local: "Rating"}, "xmp", func(r Rating) string {
+		if r.Stars == 0 {
+			return ""
+		}
+		return strconv.Itoa(r.Stars)
+	}},
+	{xml.Name{Space: xmpNamespace, Local: "Label"}, "xmp", func(r Rating) string {
+		return r.Label
+	}},
+	{xml.Name{Space: ficNamespace, Local: "Kept"}, "fic", func(r Rating) string {
+		if !r.Kept {
+			return ""
+		}
+		return "True"
+	}},
+}
+
+// rawName puts the prefix back in front, the encoder would try to
+// resolve it into a namespace otherwise
+func rawName(name xml.Name) xml.Name {
+	if name.Space == "" {
+		return name
+	}
+	return xml.Name{Local: name.Space + ":" + name.Local}
+}
+
+// xmlScopes tracks the prefixes bound while going through the raw tokens
+type xmlScopes []map[string]string
+
+func (xs *xmlScopes) push(attrs []xml.Attr) {
+	bound := make(map[string]string)
+	for _, attr := range attrs {
+		if attr.Name.Space == "xmlns" {
+			bound[attr.Name.Local] = attr.Value
+		} else if attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+			bound[""] = attr.Value
+		}
+	}
+	*xs = append(*xs, bound)
+}
+
+func (xs *xmlScopes) pop() {
+	*xs = (*xs)[:len(*xs)-1]
+}
+
+func (xs xmlScopes) bind(prefix, space string) {
+	xs[len(xs)-1][prefix] = space
+}
+
+func (xs xmlScopes) lookup(prefix string) (string, bool) {
+	for i := len(xs) - 1; i >= 0; i-- {
+		if space, ok := xs[i][prefix]; ok {
+			return space, true
+		}
+	}
+	return "", false
+}
+
+// resolve turns the prefix into the namespace uri, attributes without
+// a prefix are in no namespace, elements in the default one
+func (xs xmlScopes) resolve(name xml.Name, isattr bool) xml.Name {
+	if name.Space == "" && isattr {
+		return name
+	}
+	space, _ := xs.lookup(name.Space)
+	return xml.Name{Space: space, Local: name.Local}
+}
+
+// prefixFor returns a prefix bound to space, binding one on the element
+// that was pushed last if there is none yet
+func (xs xmlScopes) prefixFor(space, preferred string, attrs *[]xml.Attr) string {
+	for i := len(xs) - 1; i >= 0; i-- {
+		for prefix, bound := range xs[i] {
+			// an inner scope might have bound the prefix to something else
+			if bound == space && prefix != "" {
+				if inner, _ := xs.lookup(prefix); inner == space {
+					return prefix
+				}
+			}
+		}
+	}
+	prefix := preferred
+	for i := 2; ; i++ {
+		if _, taken := xs.lookup(prefix); !taken {
+			break
+		}
+		prefix = preferred + strconv.Itoa(i)
+	}
+	xs.bind(prefix, space)
+	*attrs = append(*attrs, xml.Attr{Name: xml.Name{Space: "xmlns", Local: prefix}, Value: space})
+	return prefix
+}
+
+// setRating puts what changed as attributes into the first rdf:Description.
+// the changed properties are dropped from every description before, the
+// ones that did not change stay where and how they are.
+func setRating(data []byte, rating Rating) ([]byte, error) {
+	current, err := parseRating(data)
+	if err != nil {
+		return nil, err
+	}
+	changed := make(map[xml.Name]bool)
+	for _, prop := range sidecarProperties {
+		if prop.value(current) != prop.value(rating) {
+			changed[prop.name] = true
+		}
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var out bytes.Buffer
+	enc := xml.NewEncoder(&out)
+
+	var scopes xmlScopes
+	done := false
+	depth := 0
+	descdepth := -1 // of the description we are in
+	skipping := 0   // inside of an old property element
+
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if skipping > 0 {
+				skipping++
+				continue
+			}
+			scopes.push(t.Attr)
+			name := scopes.resolve(t.Name, false)
+			if descdepth >= 0 && depth == descdepth+1 && changed[name] {
+				scopes.pop()
+				skipping = 1
+				continue
+			}
+			if name == (xml.Name{Space: rdfNamespace, Local: "Description"}) {
+				descdepth = depth
+				attrs := make([]xml.Attr, 0, len(t.Attr)+len(sidecarProperties))
+				for _, attr := range t.Attr {
+					if !changed[scopes.resolve(attr.Name, true)] {
+						attrs = append(attrs, attr)
+					}
+				}
+				if !done {
+					done = true
+					for _, prop := range sidecarProperties {
+						value := prop.value(rating)
+						if !changed[prop.name] || value == "" {
+							continue
+						}
+						prefix := scopes.prefixFor(prop.name.Space, prop.prefix, &attrs)
+						attrs = append(attrs, xml.Attr{Name: xml.Name{Space: prefix, Local: prop.name.Local}, Value: value})
+					}
+				}
+				t.Attr = attrs
+			}
+			t.Name = rawName(t.Name)
+			for i := range t.Attr {
+				t.Attr[i].Name = rawName(t.Attr[i].Name)
+			}
+			tok = t
+
+		case xml.EndElement:
+			depth--
+			if skipping > 0 {
+				skipping--
+				continue
+			}
+			scopes.pop()
+			if depth < descdepth {
+				descdepth = -1
+			}
+			t.Name = rawName(t.Name)
+			tok = t
+
+		default:
+			if skipping > 0 {
+				continue
+			}
+		}
+
+		err = enc.EncodeToken(tok)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !done {
+		return nil, errors.New("there is no rdf:Description to put the rating in")
+	}
+	err = enc.Flush()
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,178 @@
+package md
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// sidecar wraps descriptions like the tools out there write them
+func sidecar(descriptions ...string) string {
+	return `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/" x:xmptk="XMP Core 4.4.0-Exiv2">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+` + strings.Join(descriptions, "\n") + `
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+}
+
+const (
+	xmpns = `xmlns:xmp="http://ns.adobe.com/xap/1.0/"`
+	dcns  = `xmlns:dc="http://purl.org/dc/elements/1.1/"`
+)
+
+func TestParseRating(t *testing.T) {
+	tests := []struct {
+		name string
+		xmp  string
+		want Rating
+	}{
+		{"nothing", sidecar(`<rdf:Description rdf:about=""/>`), Rating{}},
+		{"attributes", sidecar(`<rdf:Description rdf:about="" ` + xmpns + ` xmp:Rating="4" xmp:Label="Red"/>`), Rating{Stars: 4, Label: "Red"}},
+		{"elements", sidecar(`<rdf:Description rdf:about="" ` + xmpns + `>
+   <xmp:Rating> 2 </xmp:Rating>
+   <xmp:Label>To Print</xmp:Label>
+  </rdf:Description>`), Rating{Stars: 2, Label: "To Print"}},
+		{"rejected", sidecar(`<rdf:Description ` + xmpns + ` xmp:Rating="-1"/>`), Rating{Stars: -1}},
+		{"fractions", sidecar(`<rdf:Description ` + xmpns + ` xmp:Rating="3.5"/>`), Rating{Stars: 3}},
+		{"negative fractions", sidecar(`<rdf:Description ` + xmpns + ` xmp:Rating="-0.5"/>`), Rating{Stars: -1}},
+		{"too many stars", sidecar(`<rdf:Description ` + xmpns + ` xmp:Rating="7"/>`), Rating{Stars: 5}},
+		{"not a number", sidecar(`<rdf:Description ` + xmpns + ` xmp:Rating="lots"/>`), Rating{}},
+		{"another prefix", sidecar(`<rdf:Description xmlns:xap="http://ns.adobe.com/xap/1.0/" xap:Rating="1"/>`), Rating{Stars: 1}},
+		{"kept", sidecar(`<rdf:Description xmlns:fic="https://github.com/BieHDC/fic/xmp/1.0/" fic:Kept="True"/>`), Rating{Kept: true}},
+		{"another namespace", sidecar(`<rdf:Description xmlns:other="urn:other" other:Rating="5" other:Label="Blue"/>`), Rating{}},
+		{"in a later description", sidecar(
+			`<rdf:Description rdf:about="" `+dcns+`><dc:format>image/jpeg</dc:format></rdf:Description>`,
+			`<rdf:Description rdf:about="" `+xmpns+`><xmp:Rating>2</xmp:Rating></rdf:Description>`,
+		), Rating{Stars: 2}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseRating([]byte(test.xmp))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+
+	if _, err := parseRating([]byte("<x:xmpmeta><rdf:RDF>")); err == nil {
+		t.Error("no error for a cut off file")
+	}
+}
+
+func TestSetRating(t *testing.T) {
+	other := `<rdf:Description rdf:about="" ` + dcns + `>
+   <dc:subject><rdf:Bag><rdf:li>holiday</rdf:li></rdf:Bag></dc:subject>
+  </rdf:Description>`
+	tests := []struct {
+		name   string
+		xmp    string
+		rating Rating
+		// what has to be in there afterwards, and what not
+		contains, missing []string
+	}{
+		{"new sidecar", newSidecar, Rating{Stars: 3, Label: "Green"},
+			[]string{`xmp:Rating="3"`, `xmp:Label="Green"`}, nil},
+		{"the reject stays when labelling", sidecar(`<rdf:Description rdf:about="" ` + xmpns + ` xmp:Rating="-1"/>`), Rating{Stars: -1, Label: "Red"},
+			[]string{`xmp:Rating="-1"`, `xmp:Label="Red"`}, nil},
+		{"rejecting", newSidecar, Rating{Stars: -1},
+			[]string{`xmp:Rating="-1"`}, []string{"Label"}},
+		{"unrating drops it", sidecar(`<rdf:Description ` + xmpns + ` xmp:Rating="4" xmp:Label="Blue"/>`), Rating{Stars: 0, Label: "Blue"},
+			[]string{`xmp:Label="Blue"`}, []string{"Rating"}},
+		{"elements that did not change stay elements", sidecar(`<rdf:Description ` + xmpns + `><xmp:Label>Blue</xmp:Label><xmp:Rating>1</xmp:Rating></rdf:Description>`), Rating{Stars: 5, Label: "Blue"},
+			[]string{`<xmp:Label>Blue</xmp:Label>`, `xmp:Rating="5"`}, []string{"<xmp:Rating>"}},
+		{"the old one is in a later description", sidecar(other, `<rdf:Description rdf:about="" `+xmpns+` xmp:CreatorTool="darktable"><xmp:Rating>2</xmp:Rating></rdf:Description>`), Rating{Stars: 5},
+			[]string{`xmp:Rating="5"`, `xmp:CreatorTool="darktable"`, "<rdf:li>holiday</rdf:li>"}, []string{"<xmp:Rating>"}},
+		{"another prefix", sidecar(`<rdf:Description xmlns:xap="http://ns.adobe.com/xap/1.0/" xap:Rating="1"/>`), Rating{Stars: 2},
+			[]string{`xap:Rating="2"`}, []string{"xmp:"}},
+		{"rdf bound to another prefix", `<x:xmpmeta xmlns:x="adobe:ns:meta/"><r:RDF xmlns:r="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <r:Description xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:Rating="2"/></r:RDF></x:xmpmeta>`, Rating{Stars: 4},
+			[]string{`xmp:Rating="4"`}, []string{`"2"`}},
+		{"the namespace is only bound later", sidecar(other, `<rdf:Description xmlns:xap="http://ns.adobe.com/xap/1.0/" xap:Rating="2"/>`), Rating{Stars: 3},
+			[]string{`xmp:Rating="3"`, `xmlns:xmp="http://ns.adobe.com/xap/1.0/"`}, []string{"xap:Rating"}},
+		{"xmp is something else", sidecar(`<rdf:Description xmlns:xmp="urn:not-xmp" xmp:Rating="whatever"/>`), Rating{Stars: 5},
+			[]string{`xmp2:Rating="5"`, `xmp:Rating="whatever"`}, nil},
+		{"a default namespace", sidecar(`<rdf:Description><Rating xmlns="http://ns.adobe.com/xap/1.0/">2</Rating></rdf:Description>`), Rating{Stars: 1},
+			[]string{`xmp:Rating="1"`}, []string{"<Rating"}},
+		{"keeping leaves the rating alone", sidecar(`<rdf:Description ` + xmpns + ` xmp:Rating="-1"/>`), Rating{Stars: -1, Kept: true},
+			[]string{`xmp:Rating="-1"`, `fic:Kept="True"`, `xmlns:fic="https://github.com/BieHDC/fic/xmp/1.0/"`}, nil},
+		{"not keeping anymore", sidecar(`<rdf:Description xmlns:fic="https://github.com/BieHDC/fic/xmp/1.0/" fic:Kept="True" ` + xmpns + ` xmp:Rating="2"/>`), Rating{Stars: 2},
+			[]string{`xmp:Rating="2"`}, []string{"Kept"}},
+		{"the rest is left alone", sidecar(other), Rating{Stars: 1},
+			[]string{"<rdf:li>holiday</rdf:li>", `<?xpacket end="w"?>`, `x:xmptk="XMP Core 4.4.0-Exiv2"`}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			updated, err := setRating([]byte(test.xmp), test.rating)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseRating(updated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.rating {
+				t.Errorf("reads back as %+v, want %+v", got, test.rating)
+			}
+			for _, s := range test.contains {
+				if strings.Count(string(updated), s) != 1 {
+					t.Errorf("%s is not in there once:\n%s", s, updated)
+				}
+			}
+			for _, s := range test.missing {
+				if strings.Contains(string(updated), s) {
+					t.Errorf("%s is still in there:\n%s", s, updated)
+				}
+			}
+		})
+	}
+
+	if _, err := setRating([]byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"/>`), Rating{Stars: 1}); err == nil {
+		t.Error("no error without a description")
+	}
+}
+
+func TestWriteRating(t *testing.T) {
+	dir := t.TempDir()
+	photo := filepath.Join(dir, "photo.jpg")
+	if err := WriteRating(photo, Rating{Stars: 4, Label: "Red"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "photo.xmp")); err != nil {
+		t.Errorf("no new sidecar: %v", err)
+	}
+	if got, err := ReadRating(photo); err != nil || got != (Rating{Stars: 4, Label: "Red"}) {
+		t.Errorf("read %+v, %v", got, err)
+	}
+
+	// the one a tool already wrote is used, and keeps its permissions
+	raw := filepath.Join(dir, "raw.cr2")
+	if err := os.WriteFile(raw+".xmp", []byte(sidecar(`<rdf:Description `+xmpns+` xmp:Rating="-1"/>`)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ReadRating(raw); err != nil || got.Stars != -1 {
+		t.Errorf("read %+v, %v", got, err)
+	}
+	if err := WriteRating(raw, Rating{Stars: -1, Label: "Purple"}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(raw + ".xmp")
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("sidecar is %v, %v", info, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "raw.xmp")); err == nil {
+		t.Error("wrote a second sidecar")
+	}
+	if got, _ := ReadRating(raw); got != (Rating{Stars: -1, Label: "Purple"}) {
+		t.Errorf("read %+v", got)
+	}
+
+	if got, err := ReadRating(filepath.Join(dir, "none.jpg")); err != nil || got != (Rating{}) {
+		t.Errorf("no sidecar read %+v, %v", got, err)
+	}
+}
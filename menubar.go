@@ -3,7 +3,10 @@ package main
 import (
 	"fmt"
 	"runtime"
+	"slices"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -15,12 +18,15 @@ import (
 
 	"fyne.io/fyne/v2/cmd/fyne_settings/settings"
 
+	afs "github.com/BieHDC/fic/archivefs"
 	ilp "github.com/BieHDC/fic/imagelistplayer"
+	md "github.com/BieHDC/fic/mediadata"
 )
 
 type Menubar struct {
 	imgplayer      *ilp.ImagePlayer
 	selectedfolder string
+	framestats     playbackStats
 }
 
 type contextMenuButton struct {
@@ -66,7 +72,9 @@ func (v *Viewer) makeMenu(w fyne.Window) fyne.CanvasObject {
 			setNewFolder(lu)
 		}, w)
 
-		fo.SetLocation(v.rootdir)
+		if !afs.IsArchiveURI(v.rootdir) {
+			fo.SetLocation(v.rootdir)
+		}
 		fo.Show()
 		fo.Resize(fo.MinSize().Add(fo.MinSize()))
 	})
@@ -109,16 +117,81 @@ func (v *Viewer) makeMenu(w fyne.Window) fyne.CanvasObject {
 		}
 		return uint(max(1, asuint))
 	}
+	maxcachesize := newNumEntry()
+	cachesize := func() uint {
+		asuint, err := strconv.Atoi(maxcachesize.Text)
+		if err != nil {
+			return DefaultSettings.maxcachesize
+		}
+		return uint(max(0, asuint))
+	}
+	maxcachedside := newNumEntry()
+	cachedside := func() uint {
+		asuint, err := strconv.Atoi(maxcachedside.Text)
+		if err != nil {
+			return DefaultSettings.maxcachedside
+		}
+		return uint(max(0, asuint))
+	}
+	resamplefilter := widget.NewSelect(md.ResampleFilters, func(_ string) {})
+	includeextensions := widget.NewEntry()
+	includeextensions.SetPlaceHolder("jpg, png")
+	excludeextensions := widget.NewEntry()
+	excludeextensions.SetPlaceHolder("psd, txt")
+	ignorepatterns := widget.NewMultiLineEntry()
+	ignorepatterns.SetPlaceHolder("one pattern per line, like render-cache/")
+	hidedotfiles := widget.NewCheck("", func(_ bool) {})
+	followsymlinks := widget.NewCheck("", func(_ bool) {})
+	opencommand := widget.NewEntry()
+	opencommand.SetPlaceHolder("gimp {}")
+	cullkeys := widget.NewMultiLineEntry()
+	cullkeys.SetPlaceHolder("1=/photos/best")
+	rejectfolder := widget.NewEntry()
+	cullcopy := widget.NewCheck("", func(_ bool) {})
+	maxundo := newNumEntry()
+	undolimit := func() uint {
+		asuint, err := strconv.Atoi(maxundo.Text)
+		if err != nil {
+			return DefaultSettings.undolimit
+		}
+		return uint(max(1, asuint))
+	}
 	ficsettings := widget.NewForm(
 		NewFormItemWithHintText("Include Subfolders", subfolders, "Used when selecting a folder"),
 		NewFormItemWithHintText("Max Worker Threads", threads, "How many threads are loading images"),
 		NewFormItemWithHintText("Max File Size in MB", maxfilesize, "Do not accidentally load too big images"),
+		NewFormItemWithHintText("Max Cache Size in MB", maxcachesize, "Least recently shown images get evicted, 0 is unlimited"),
+		NewFormItemWithHintText("Max Cached Side in Pixels", maxcachedside, "Larger images are sized down, 0 matches the window"),
+		NewFormItemWithHintText("Resampling Filter", resamplefilter, "Used when sizing down, slower ones look better"),
+		NewFormItemWithHintText("Always Show", includeextensions, "Extensions shown without checking if they are images"),
+		NewFormItemWithHintText("Never Show", excludeextensions, "Extensions that are never shown"),
+		NewFormItemWithHintText("Ignore Patterns", ignorepatterns, "Skipped while loading, a .ficignore in a folder works the same"),
+		NewFormItemWithHintText("Hide Dotfiles", hidedotfiles, "Skip files and folders starting with a dot"),
+		NewFormItemWithHintText("Follow Symlinks", followsymlinks, "Show what links point to, loops are skipped"),
+		NewFormItemWithHintText("Open With Command", opencommand, "Used from the context menu, {} is the file"),
+		NewFormItemWithHintText("Culling Keys", cullkeys, "One KEY=action per line, like Ctrl+1=rate 1: keep, reject, trash, undo, rate 0-5, label Red or a folder to move to"),
+		NewFormItemWithHintText("Reject Folder", rejectfolder, "Relative to the folder of the file unless absolute, it is left out of the tree"),
+		NewFormItemWithHintText("Copy to Folders", cullcopy, "Copy instead of move when culling into a folder"),
+		NewFormItemWithHintText("Undo Steps", maxundo, "How many culling steps can be undone"),
 	)
 
 	resetSettingWidgetsValues := func() {
 		subfolders.Checked = v.includesubfolders
 		threads.Text = fmt.Sprintf("%d", v.maxworkers)
 		maxfilesize.Text = fmt.Sprintf("%d", v.maxfilesize)
+		maxcachesize.Text = fmt.Sprintf("%d", v.maxcachesize)
+		maxcachedside.Text = fmt.Sprintf("%d", v.maxcachedside)
+		resamplefilter.Selected = v.resamplefilter
+		includeextensions.Text = v.includeextensions
+		excludeextensions.Text = v.excludeextensions
+		ignorepatterns.Text = v.ignorepatterns
+		hidedotfiles.Checked = v.hidedotfiles
+		followsymlinks.Checked = v.followsymlinks
+		opencommand.Text = v.opencommand
+		cullkeys.Text = v.cullkeys
+		rejectfolder.Text = v.rejectfolder
+		cullcopy.Checked = v.cullcopy
+		maxundo.Text = fmt.Sprintf("%d", v.undolimit)
 	}
 	resetSettingWidgetsValues()
 
@@ -129,12 +202,53 @@ func (v *Viewer) makeMenu(w fyne.Window) fyne.CanvasObject {
 			runtime.GC()
 			v.setStatus("Cache has been cleared")
 		}),
+		fyne.NewMenuItem("Toggle Metadata (I)", func() { v.toggleMetadataPanel() }),
+		fyne.NewMenuItem("Restore From Trash", func() { v.restoreTrashed() }),
+		fyne.NewMenuItem("Copy Kept Paths", func() {
+			// of the folder that is played, the others were not looked at
+			paths := v.keptFiles()
+			slices.Sort(paths)
+			w.Clipboard().SetContent(strings.Join(paths, "\n"))
+			v.setStatus(fmt.Sprintf("Copied %d kept paths", len(paths)))
+		}),
 		fyne.NewMenuItemSeparator(),
 		fyne.NewMenuItem("Fic Settings", func() {
 			dialog.ShowCustomConfirm("Fic Settings", "Save", "Defaults", ficsettings,
 				func(save bool) {
 					if save {
-						v.ApplySettings(workers(), filesize(), subfolders.Checked)
+						changed := v.Settings
+						changed.maxworkers = workers()
+						changed.maxfilesize = filesize()
+						changed.maxcachesize = cachesize()
+						changed.maxcachedside = cachedside()
+						changed.resamplefilter = resamplefilter.Selected
+						changed.includesubfolders = subfolders.Checked
+						changed.includeextensions = includeextensions.Text
+						changed.excludeextensions = excludeextensions.Text
+						changed.ignorepatterns = ignorepatterns.Text
+						changed.hidedotfiles = hidedotfiles.Checked
+						changed.followsymlinks = followsymlinks.Checked
+						changed.opencommand = opencommand.Text
+						changed.cullkeys = cullkeys.Text
+						changed.rejectfolder = rejectfolder.Text
+						changed.cullcopy = cullcopy.Checked
+						changed.undolimit = undolimit()
+						policychanged := changed.maxcachedside != v.maxcachedside || changed.resamplefilter != v.resamplefilter
+						filterchanged := changed.includeextensions != v.includeextensions || changed.excludeextensions != v.excludeextensions ||
+							changed.ignorepatterns != v.ignorepatterns || changed.hidedotfiles != v.hidedotfiles ||
+							changed.followsymlinks != v.followsymlinks || changed.rejectfolder != v.rejectfolder
+						v.ApplySettings(changed)
+						v.SetCacheBudget(int64(v.maxcachesize))
+						v.applyDecodeSettings(w.Canvas())
+						if policychanged {
+							// everything cached was sized for the old policy
+							v.InvalidateImageCache()
+						}
+						if filterchanged {
+							// the walker has to decide again what goes in
+							v.refreshFileTree(v.rootdir)
+							v.filetree.Refresh()
+						}
 						v.SetNewFolder(v.selectedfolder, true, false) //dont seek when the flag is switched
 						if subfolders.Checked {
 							v.setStatus("Subfolders will be included")
@@ -166,7 +280,8 @@ func (v *Viewer) makeMenubar(w fyne.Window) fyne.CanvasObject {
 	next := widget.NewButtonWithIcon("Next", theme.NavigateNextIcon(), func() { v.imgplayer.Next() })
 
 	v.imgplayer.SetOnPlayFunc(func() {
-		go v.CacheTask(v.filestringsToURI(v.imgplayer.List()),
+		v.framestats.reset()
+		go v.CacheTask(v.filestringsToURI(v.imgplayer.Playlist()),
 			func(s string, _ bool) {
 				v.setStatus(s)
 			}, int64(v.maxworkers), int64(v.maxfilesize))
@@ -195,6 +310,55 @@ func (v *Viewer) makeMenubar(w fyne.Window) fyne.CanvasObject {
 		}()
 	})
 
+	stopatend := widget.NewCheck("Stop at End", func(b bool) {
+		v.imgplayer.SetStopAtEnd(b)
+	})
+	// dropping frames would break the promise of no skip, only one can be on
+	noskip := widget.NewCheck("No Skip", nil)
+	dropframes := widget.NewCheck("Drop Frames", nil)
+	noskip.OnChanged = func(b bool) {
+		if b {
+			dropframes.SetChecked(false)
+			dropframes.Disable()
+		} else {
+			dropframes.Enable()
+		}
+	}
+	dropframes.OnChanged = func(b bool) {
+		v.imgplayer.SetDropFrames(b)
+		if b {
+			noskip.Disable()
+		} else {
+			noskip.Enable()
+		}
+	}
+
+	shufflemodes := map[string]ilp.ShuffleMode{
+		"In Order": ilp.ShuffleOff,
+		"Shuffle":  ilp.ShuffleStable,
+		"Random":   ilp.ShuffleExhaust,
+	}
+	reshuffle := widget.NewButtonWithIcon("", theme.ViewRefreshIcon(), func() {
+		v.imgplayer.Reseed(0)
+	})
+	reshuffle.Disable()
+	shuffle := widget.NewSelect([]string{"In Order", "Shuffle", "Random"}, func(s string) {
+		mode := shufflemodes[s]
+		v.imgplayer.SetShuffle(mode)
+		if mode == ilp.ShuffleOff {
+			reshuffle.Disable()
+		} else {
+			reshuffle.Enable()
+		}
+	})
+	shuffle.SetSelectedIndex(0)
+
+	v.imgplayer.SetOnEndedFunc(func() {
+		playpause.SetText("Play")
+		playpause.SetIcon(theme.MediaPlayIcon())
+		v.setStatus("Reached the end of the list")
+	})
+
 	var precache *widget.Button
 	precache = widget.NewButtonWithIcon("Cache Folder", theme.MediaRecordIcon(), func() {
 		if v.IsCaching() {
@@ -205,7 +369,7 @@ func (v *Viewer) makeMenubar(w fyne.Window) fyne.CanvasObject {
 		} else {
 			precache.SetIcon(theme.MediaStopIcon())
 			precache.SetText("Stop Caching")
-			go v.CacheTask(v.filestringsToURI(v.imgplayer.List()),
+			go v.CacheTask(v.filestringsToURI(v.imgplayer.Playlist()),
 				func(s string, done bool) {
 					v.setStatus(s)
 					if done {
@@ -231,7 +395,12 @@ func (v *Viewer) makeMenubar(w fyne.Window) fyne.CanvasObject {
 
 	estimatedplaytime := widget.NewLabel("")
 	estimatedplaytimeupdate := func() {
-		estimate := (float64(v.imgplayer.Len()) * speed.Value) / 1000
+		perfile := speed.Value / 1000
+		if fps, _ := v.imgplayer.Throughput(); fps > 0 {
+			// while playing we know how fast we really are
+			perfile = 1 / fps
+		}
+		estimate := float64(v.imgplayer.Len()) * perfile
 		estimatedplaytime.SetText(fmt.Sprintf("%0.2f seconds", estimate))
 	}
 	speed.OnChanged = func(f float64) {
@@ -239,52 +408,100 @@ func (v *Viewer) makeMenubar(w fyne.Window) fyne.CanvasObject {
 		speedasstring.SetText(fmt.Sprintf("%04.0f", f))
 	}
 
+	// the player asks after showing a file how long it should stay
+	var lastfailed atomic.Bool
+	v.imgplayer.SetFrameDurationFunc(func(_ int) time.Duration {
+		if lastfailed.Load() {
+			//move on quicker if the image was not an image
+			return 0
+		}
+		return time.Duration(speed.Value) * time.Millisecond
+	})
+
 	v.imgplayer.SetOnFrameFunc(func(index int, data []string, block bool) {
+		framestart := time.Now()
 		if index >= len(data) {
 			index = 0
 		}
 		
+		v.protectPlayerWindow(index, data)
+
 		seeker.SetValue(float64(index))
 		seeker.Refresh()
 		//we unselect, so we can reclick the folder to display the preview
 		v.filetree.UnselectAll()
 
-		uri, ok := v.filetreedata.Values[data[index]]
+		uri, ok := v.treeData().URI(data[index])
 		if !ok {
 			v.setStatus(data[index] + " failed: does not exist")
+			if block {
+				lastfailed.Store(true)
+				v.framestats.frame(false, false)
+			}
 			return
 		}
 
+		failed := false
 		li := v.filetree.IsBranch(uri.String())
 		if li {
 			defer v.displayLoadingScreen("Generating previews")()
-			v.displayPreview(v.filetreedata.Ids[data[index]])
+			v.displayPreview(v.treeData().Children(data[index]))
 		} else {
 			err := v.displayImage(uri)
 			if err != nil {
 				v.setStatus(data[index] + " failed: " + err.Error())
-				//move on quicker if the image was not an image
-				block = false
+				failed = true
 			}
 		}
 		v.setFileNumber(index, v.imgplayer.Len())
 
-		if block {
-			time.Sleep(time.Duration(speed.Value) * time.Millisecond)
+		if !block {
+			return
 		}
+
+		// the player does the waiting, we only hold it back until
+		// the frame is on the screen if we must not skip any
+		lastfailed.Store(failed)
+		target := time.Duration(speed.Value) * time.Millisecond
+		switch {
+		case failed:
+			v.framestats.frame(false, false)
+		case noskip.Checked:
+			shown := v.presenter.waitPresented(presentTimeout)
+			elapsed := time.Since(framestart)
+			v.framestats.frame(shown, shown && elapsed > target)
+		default:
+			v.framestats.frame(true, false)
+		}
+		fps, skipped := v.imgplayer.Throughput()
+		v.setPlaybackStats(v.framestats.String(target, fps, skipped))
+		estimatedplaytimeupdate()
 	})
-	v.imgplayer.SetOnDataChangedFunc(func() {
+	updateseekerbounds := func() {
 		low, high := v.imgplayer.GetSeekerBounds()
 		seeker.Min = float64(low)
 		seeker.Max = float64(high)
+		seeker.Refresh()
 		estimatedplaytimeupdate()
+	}
+	v.imgplayer.SetOnDataChangedFunc(func() {
+		updateseekerbounds()
 		v.filetree.OnSelected(v.selected)
 	})
+	v.imgplayer.SetOnListUpdatedFunc(func() {
+		updateseekerbounds()
+		// the current file may be somewhere else now, but it is already displayed
+		seeker.Value = float64(v.imgplayer.Cursor())
+		seeker.Refresh()
+		v.setFileNumber(v.imgplayer.Cursor(), v.imgplayer.Len())
+	})
 
 	ticker := time.NewTicker(5 * time.Second)
 	go func() {
 		for {
 			<-ticker.C
+			// the window might have been resized
+			v.applyDecodePolicy(w.Canvas())
 			ramusedpercent := v.refreshMemoryUsage()
 			if ramusedpercent > 80 {
 				precache.Importance = widget.DangerImportance
@@ -306,6 +523,11 @@ func (v *Viewer) makeMenubar(w fyne.Window) fyne.CanvasObject {
 				next,
 				playpause,
 				stop,
+				stopatend,
+				noskip,
+				dropframes,
+				shuffle,
+				reshuffle,
 				speedasstring,
 			),
 			estimatedplaytime,
@@ -315,22 +537,32 @@ func (v *Viewer) makeMenubar(w fyne.Window) fyne.CanvasObject {
 	)
 }
 
-func (v *Viewer) filestringsToURI(files []string) []fyne.URI {
-	uris := make([]fyne.URI, 0, len(files))
-	for _, index := range files {
-		uri, ok := v.filetreedata.Values[index]
-		if !ok {
-			continue
-		}
-		uris = append(uris, uri)
+// images around the cursor are shown next either way,
+// evicting them from the cache would just waste work
+const cacheProtectWindow = 8
+
+func (v *Viewer) protectPlayerWindow(index int, data []string) {
+	numitems := len(data)
+	if numitems < 1 {
+		v.ProtectFromEviction(nil)
+		return
 	}
-	return uris
+	window := make([]string, 0, min(numitems, cacheProtectWindow*2+1))
+	for i := max(-cacheProtectWindow, -numitems/2); i <= min(cacheProtectWindow, numitems/2); i++ {
+		window = append(window, data[((index+i)%numitems+numitems)%numitems])
+	}
+	v.ProtectFromEviction(window)
+}
+
+func (v *Viewer) filestringsToURI(files []string) []fyne.URI {
+	return v.treeData().URIs(files)
 }
 
 func (v *Viewer) walksubfolder(child string) []string {
-	result := make([]string, 0, len(v.filetreedata.Ids[child]))
-	for _, file := range v.filetreedata.Ids[child] {
-		if len(v.filetreedata.Ids[file]) > 0 {
+	children := v.treeData().Children(child)
+	result := make([]string, 0, len(children))
+	for _, file := range children {
+		if v.treeData().IsFolder(file) {
 			//folder inside folder
 			result = append(result, v.walksubfolder(file)...)
 			continue
@@ -346,14 +578,31 @@ func (v *Viewer) SetNewFolder(selectedfolder string, force bool, seek bool) {
 	}
 	v.selectedfolder = selectedfolder
 
+	filelist, newoffset := v.collectFolder(v.selectedfolder, v.imgplayer.RealCursor())
+
+	v.imgplayer.SetNewData(filelist)
+	if seek && newoffset < len(filelist) {
+		// the offset is in the real order, which is not the play order when shuffled
+		v.imgplayer.SeekToData(filelist[newoffset])
+	}
+}
+
+// RefreshFolder rebuilds the list of the selected folder after
+// the filetree has changed, without moving off the current file
+func (v *Viewer) RefreshFolder() {
+	filelist, _ := v.collectFolder(v.selectedfolder, 0)
+	v.imgplayer.UpdateData(filelist)
+}
+
+func (v *Viewer) collectFolder(selectedfolder string, internaloffset int) ([]string, int) {
+	children := v.treeData().Children(selectedfolder)
 	// it is most of the time around the selected folder len
-	filelist := make([]string, 0, len(v.filetreedata.Ids[v.selectedfolder]))
+	filelist := make([]string, 0, len(children))
 
-	internaloffset := v.imgplayer.Cursor()
 	newoffset := 0
-	for offset, file := range v.filetreedata.Ids[v.selectedfolder] {
-		clen := len(v.filetreedata.Ids[file])
-		if v.includesubfolders && clen > 0 {
+	for offset, file := range children {
+		isfolder := v.treeData().IsFolder(file)
+		if v.includesubfolders && isfolder {
 			//is a folder, walk it
 			subfiles := v.walksubfolder(file)
 			filelist = append(filelist, subfiles...)
@@ -363,15 +612,25 @@ func (v *Viewer) SetNewFolder(selectedfolder string, force bool, seek bool) {
 			}
 			continue
 		}
-		if clen > 0 {
+		if isfolder {
 			continue
 		}
 
 		filelist = append(filelist, file)
 	}
 
-	v.imgplayer.SetNewData(filelist)
-	if seek {
-		v.imgplayer.SeekTo(newoffset)
+	current := ""
+	if newoffset < len(filelist) {
+		current = filelist[newoffset]
 	}
+	filelist = v.filterByRating(filelist)
+	v.sortFileList(filelist)
+	if current != "" {
+		// find the same file again after filtering and sorting
+		newoffset = max(0, slices.Index(filelist, current))
+	} else {
+		newoffset = len(filelist)
+	}
+
+	return filelist, newoffset
 }
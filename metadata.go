@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+
+	md "github.com/BieHDC/fic/mediadata"
+)
+
+func (v *Viewer) makeMetadataPanel() fyne.CanvasObject {
+	v.metadataform = widget.NewForm()
+	v.metadatapanel = container.NewBorder(
+		widget.NewLabelWithStyle("Metadata", fyne.TextAlignCenter, fyne.TextStyle{Bold: true}),
+		nil, nil, nil,
+		container.NewVScroll(v.metadataform),
+	)
+	v.metadatapanel.Hide()
+	v.setMetadata(nil)
+	return v.metadatapanel
+}
+
+func (v *Viewer) toggleMetadataPanel() {
+	if v.metadatapanel.Visible() {
+		v.metadatapanel.Hide()
+	} else {
+		v.metadatapanel.Show()
+	}
+}
+
+// setMetadata shows what we know about the current file, nil clears it
+func (v *Viewer) setMetadata(img *md.ImageDescriptor) {
+	items := []*widget.FormItem{}
+	if img != nil {
+		items = append(items, widget.NewFormItem("Size", widget.NewLabel(fmt.Sprintf("%dx%d", img.Width, img.Height))))
+		if img.Metadata != nil {
+			for _, field := range img.Metadata.Fields() {
+				items = append(items, widget.NewFormItem(field[0], widget.NewLabel(field[1])))
+			}
+		}
+	}
+	if len(items) < 2 {
+		items = append(items, widget.NewFormItem("", widget.NewLabel("No metadata")))
+	}
+	v.metadataform.Items = items
+	v.metadataform.Refresh()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+)
+
+// fyne has no hook for buffer swaps, but a raster is only generated
+// on the draw thread when it gets painted after a refresh. an
+// invisible one on top of the viewer tells us when a frame made it
+// onto the screen.
+type presentProbe struct {
+	raster  *canvas.Raster
+	lock    sync.Mutex
+	waiting []chan struct{}
+}
+
+// how long a frame may take to be painted before we count it as dropped
+const presentTimeout = 2 * time.Second
+
+func newPresentProbe() *presentProbe {
+	pp := &presentProbe{}
+	pp.raster = canvas.NewRaster(func(_, _ int) image.Image {
+		pp.lock.Lock()
+		for _, presented := range pp.waiting {
+			close(presented)
+		}
+		pp.waiting = nil
+		pp.lock.Unlock()
+		return image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	})
+	pp.raster.Resize(fyne.NewSquareSize(1))
+	return pp
+}
+
+// waitPresented blocks until the next paint of the canvas, returns
+// false if that did not happen in time, like on a minimised window
+func (pp *presentProbe) waitPresented(timeout time.Duration) bool {
+	presented := make(chan struct{})
+	pp.lock.Lock()
+	pp.waiting = append(pp.waiting, presented)
+	pp.lock.Unlock()
+	pp.raster.Refresh()
+
+	select {
+	case <-presented:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+type playbackStats struct {
+	lock    sync.Mutex
+	shown   int
+	delayed int // shown, but later than the speed allows
+	dropped int // failed to load or never painted
+}
+
+func (ps *playbackStats) reset() {
+	ps.lock.Lock()
+	ps.shown = 0
+	ps.delayed = 0
+	ps.dropped = 0
+	ps.lock.Unlock()
+}
+
+func (ps *playbackStats) frame(shown, delayed bool) {
+	ps.lock.Lock()
+	if shown {
+		ps.shown++
+	} else {
+		ps.dropped++
+	}
+	if delayed {
+		ps.delayed++
+	}
+	ps.lock.Unlock()
+}
+
+// fps and skipped come from the player, which measures the schedule
+func (ps *playbackStats) String(target time.Duration, fps float64, skipped int) string {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	return fmt.Sprintf("%0.1f/%0.1f fps | Delayed: %d | Dropped: %d",
+		fps, float64(time.Second)/float64(target), ps.delayed, ps.dropped+skipped)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/widget"
+
+	dg "github.com/BieHDC/fic/dynamicgrid"
+	fc "github.com/BieHDC/fic/filecard"
+	gp "github.com/BieHDC/fic/gifplayer"
+	md "github.com/BieHDC/fic/mediadata"
+)
+
+// roughly the size a filecard displays its image at
+const previewThumbnailSize = 256
+
+type previewInfo struct {
+	index int //for sorting
+	uri   fyne.URI
+	img   *md.ImageDescriptor
+}
+
+// previewSession fills the preview grid of one folder. it ends when
+// something else is displayed.
+type previewSession struct {
+	v      *Viewer
+	files  []string
+	grid   *fyne.Container
+	cancel chan struct{}
+
+	mu sync.Mutex
+	// bumped when the grid wants more, older runs drop what they find
+	run     int
+	amount  int
+	running bool
+	fresh   bool // nothing of the current run is shown yet
+	found   []previewInfo
+	cards   []fyne.CanvasObject
+}
+
+// stopPreviews is called from the player as well as the ui, only the one
+// that swapped the session out gets to stop it
+func (v *Viewer) stopPreviews() {
+	if ps := v.previews.Swap(nil); ps != nil {
+		ps.stop()
+	}
+}
+
+func (ps *previewSession) stop() {
+	// under the lock, so finish does not replace what comes next
+	ps.mu.Lock()
+	close(ps.cancel)
+	ps.mu.Unlock()
+}
+
+func (v *Viewer) displayPreview(files []string) {
+	const imagesPerViewRows = 2
+	const imagesPerViewColums = 2
+
+	v.setMetadata(nil)
+	v.rating.Set("")
+
+	ps := &previewSession{
+		v:      v,
+		files:  files,
+		cancel: make(chan struct{}),
+	}
+	ps.grid = dg.NewDynamicGrid(imagesPerViewRows, imagesPerViewColums, ps.requestMore)
+	if old := v.previews.Swap(ps); old != nil {
+		old.stop()
+	}
+
+	ps.mu.Lock()
+	ps.startNotLocked(imagesPerViewRows * imagesPerViewColums)
+	ps.mu.Unlock()
+	v.setMainContainer(ps.grid)
+}
+
+// requestMore is asked by the grid when it has room for amount cards.
+// it reports exhausted once a run has finished without finding enough.
+func (ps *previewSession) requestMore(amount int) ([]fyne.CanvasObject, bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	// without cards the grid can not know how many fit
+	if len(ps.cards) > 0 && amount > ps.amount {
+		ps.startNotLocked(amount)
+	}
+	return slices.Clone(ps.cards), !ps.running && len(ps.cards) < amount
+}
+
+func (ps *previewSession) startNotLocked(amount int) {
+	ps.run++
+	ps.amount = amount
+	ps.running = true
+	// the old cards stay until the first new one arrives
+	ps.fresh = true
+	go ps.sample(ps.run, amount)
+}
+
+func (ps *previewSession) stale(run int) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.staleNotLocked(run)
+}
+
+func (ps *previewSession) staleNotLocked(run int) bool {
+	select {
+	case <-ps.cancel:
+		return true
+	default:
+	}
+	return run != ps.run
+}
+
+// sample picks amount images spread evenly over the folder. every stratum
+// is looked at in parallel, if some of them have no images in them the
+// rest of the candidates gets stratified again for the missing ones.
+func (ps *previewSession) sample(run, amount int) {
+	v := ps.v
+	candidates := make([]int, 0, len(ps.files))
+	uris := make([]fyne.URI, len(ps.files))
+	for index, file := range ps.files {
+		if v.filetree.IsBranch(file) {
+			// dont care about dirs
+			continue
+		}
+		uri, ok := v.treeData().URI(file)
+		if !ok || v.KnownInvalid(uri) {
+			continue
+		}
+		uris[index] = uri
+		candidates = append(candidates, index)
+	}
+
+	sem := make(chan struct{}, max(1, v.maxworkers))
+	found := 0
+	for found < amount && len(candidates) > 0 {
+		strata := stratify(candidates, amount-found)
+		tried := make([]int, len(strata))
+		var added atomic.Int64
+		var wg sync.WaitGroup
+		wg.Add(len(strata))
+		for s, stratum := range strata {
+			go func(s int, stratum []int) {
+				defer wg.Done()
+				for _, index := range stratum {
+					if ps.stale(run) {
+						return
+					}
+					tried[s]++
+					sem <- struct{}{}
+					img, err := v.CacheThumbnail(uris[index], int64(v.maxfilesize), previewThumbnailSize)
+					<-sem
+					if err != nil || img == nil {
+						// ignore this non-image
+						continue
+					}
+					if ps.add(run, previewInfo{index: index, uri: uris[index], img: img}) {
+						added.Add(1)
+					}
+					return
+				}
+			}(s, stratum)
+		}
+		wg.Wait()
+		if ps.stale(run) {
+			return
+		}
+		found += int(added.Load())
+
+		var rest []int
+		for s, stratum := range strata {
+			rest = append(rest, stratum[tried[s]:]...)
+		}
+		slices.Sort(rest)
+		candidates = rest
+	}
+	ps.finish(run)
+}
+
+// stratify splits the candidates into amount equally sized strata. each
+// stratum lists its candidates from the middle outwards.
+func stratify(candidates []int, amount int) [][]int {
+	n := len(candidates)
+	amount = min(amount, n)
+	strata := make([][]int, 0, amount)
+	for s := 0; s < amount; s++ {
+		lo, hi := s*n/amount, (s+1)*n/amount
+		mid := lo + (hi-lo)/2
+		stratum := make([]int, 0, hi-lo)
+		for d := 0; len(stratum) < hi-lo; d++ {
+			if mid+d < hi {
+				stratum = append(stratum, candidates[mid+d])
+			}
+			if d > 0 && mid-d >= lo {
+				stratum = append(stratum, candidates[mid-d])
+			}
+		}
+		strata = append(strata, stratum)
+	}
+	return strata
+}
+
+func (ps *previewSession) add(run int, target previewInfo) bool {
+	card := ps.v.makeFilecard(target)
+
+	ps.mu.Lock()
+	if run != ps.run {
+		ps.mu.Unlock()
+		return false
+	}
+	if ps.fresh {
+		ps.found = nil
+		ps.cards = nil
+		ps.fresh = false
+	}
+	at, _ := slices.BinarySearchFunc(ps.found, target, func(a, b previewInfo) int {
+		return cmp.Compare(a.index, b.index)
+	})
+	ps.found = slices.Insert(ps.found, at, target)
+	ps.cards = slices.Insert(ps.cards, at, card)
+	ps.grid.Objects = slices.Clone(ps.cards)
+	numfound := len(ps.cards)
+	ps.mu.Unlock()
+
+	ps.grid.Refresh()
+	ps.v.setStatus(fmt.Sprintf("Generating Previews (%d/%d)", numfound, ps.amount))
+	return true
+}
+
+func (ps *previewSession) finish(run int) {
+	ps.mu.Lock()
+	if run != ps.run {
+		ps.mu.Unlock()
+		return
+	}
+	ps.running = false
+	if ps.fresh {
+		ps.found = nil
+		ps.cards = nil
+	}
+	ps.grid.Objects = slices.Clone(ps.cards)
+	numfound := len(ps.cards)
+	ps.mu.Unlock()
+
+	if numfound == 0 {
+		// we have found no usable image anywhere (empty folder, or no images).
+		// the user might have moved on to a file meanwhile, that stays.
+		ps.mu.Lock()
+		defer ps.mu.Unlock()
+		if !ps.staleNotLocked(run) {
+			ps.v.setMainContainer(container.NewCenter(widget.NewLabel("Nothing to display")))
+		}
+		return
+	}
+	ps.grid.Refresh()
+	ps.v.setStatus("Previews finished Generating.")
+}
+
+func (v *Viewer) makeFilecard(target previewInfo) fyne.CanvasObject {
+	uri := target.uri
+	img := target.img
+
+	var disp fyne.CanvasObject
+	if img.Type == md.ImageAnimated {
+		disp = gp.NewMinimalGifPlayer(img.Images, img.Delays, img.LoopCount)
+	} else {
+		disp = img.Images[0]
+	}
+	card := fc.NewFileCard(uri.Name(), disp)
+	if rating := v.ratings.get(uri); rating != (md.Rating{}) {
+		card.WithBadge(ratingText(rating), labelColor(rating.Label))
+	}
+	return card.WithCallback(func(_ *fyne.PointEvent) {
+		v.revealInTree(uri)
+	}).WithSecondaryCallback(func(pe *fyne.PointEvent) {
+		v.showFileMenu(uri, card, pe)
+	})
+}
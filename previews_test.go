@@ -0,0 +1,76 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestStratify(t *testing.T) {
+	tests := []struct {
+		name       string
+		candidates []int
+		amount     int
+		want       [][]int
+	}{
+		{"nothing", nil, 4, [][]int{}},
+		{"one each", []int{3, 5, 9}, 3, [][]int{{3}, {5}, {9}}},
+		{"more wanted than there are", []int{1, 2}, 5, [][]int{{1}, {2}}},
+		{"middle outwards", []int{0, 1, 2, 3, 4}, 1, [][]int{{2, 3, 1, 4, 0}}},
+		{"even strata", []int{0, 1, 2, 3, 4, 5, 6, 7}, 2, [][]int{{2, 3, 1, 0}, {6, 7, 5, 4}}},
+		{"uneven strata", []int{10, 11, 12, 13, 14, 15, 16}, 3, [][]int{{11, 10}, {13, 12}, {15, 16, 14}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := stratify(test.candidates, test.amount)
+			if !slices.EqualFunc(got, test.want, slices.Equal) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStratifyCoversEverything(t *testing.T) {
+	candidates := make([]int, 101)
+	for i := range candidates {
+		candidates[i] = i * 2
+	}
+	for amount := 1; amount <= len(candidates); amount++ {
+		var all []int
+		for _, stratum := range stratify(candidates, amount) {
+			if len(stratum) == 0 {
+				t.Fatalf("%d strata: an empty one", amount)
+			}
+			all = append(all, stratum...)
+		}
+		slices.Sort(all)
+		if !slices.Equal(all, candidates) {
+			t.Fatalf("%d strata: every candidate should be in exactly one", amount)
+		}
+	}
+}
+
+// TestStopPreviewsConcurrently stops like the player and the ui do at the
+// same time, closing a session twice would panic
+func TestStopPreviewsConcurrently(t *testing.T) {
+	v := &Viewer{}
+	for range 100 {
+		ps := &previewSession{v: v, cancel: make(chan struct{})}
+		v.previews.Store(ps)
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				v.stopPreviews()
+			}()
+		}
+		wg.Wait()
+		if !ps.stale(ps.run) {
+			t.Fatal("the session was not stopped")
+		}
+		if v.previews.Load() != nil {
+			t.Fatal("the session is still there")
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/widget"
+
+	afs "github.com/BieHDC/fic/archivefs"
+	md "github.com/BieHDC/fic/mediadata"
+)
+
+// ratingCache saves us from reading the sidecars again and again
+type ratingCache struct {
+	mu      sync.Mutex
+	ratings map[string]md.Rating
+}
+
+func (rc *ratingCache) get(uri fyne.URI) md.Rating {
+	id := uri.String()
+	rc.mu.Lock()
+	rating, ok := rc.ratings[id]
+	rc.mu.Unlock()
+	if ok {
+		return rating
+	}
+	if !afs.IsArchiveURI(uri) {
+		// a broken sidecar is the same as none
+		rating, _ = md.ReadRating(uri.Path())
+	}
+	rc.set(id, rating)
+	return rating
+}
+
+func (rc *ratingCache) set(id string, rating md.Rating) {
+	rc.mu.Lock()
+	if rc.ratings == nil {
+		rc.ratings = make(map[string]md.Rating)
+	}
+	rc.ratings[id] = rating
+	rc.mu.Unlock()
+}
+
+func (rc *ratingCache) forget(id string) {
+	rc.mu.Lock()
+	delete(rc.ratings, id)
+	rc.mu.Unlock()
+}
+
+// forgetSidecar drops the ratings the sidecar might belong to,
+// photo.xmp can be the one of photo.jpg as well as of photo.cr2
+func (rc *ratingCache) forgetSidecar(id string) {
+	stem := strings.TrimSuffix(id, path.Ext(id))
+	rc.mu.Lock()
+	for file := range rc.ratings {
+		if file == stem || strings.TrimSuffix(file, path.Ext(file)) == stem {
+			delete(rc.ratings, file)
+		}
+	}
+	rc.mu.Unlock()
+}
+
+func ratingText(rating md.Rating) string {
+	var parts []string
+	if rating.Stars > 0 {
+		parts = append(parts, fmt.Sprintf("%d/5", rating.Stars))
+	} else if rating.Stars < 0 {
+		parts = append(parts, "Rejected")
+	}
+	if rating.Label != "" {
+		parts = append(parts, rating.Label)
+	}
+	if rating.Kept {
+		parts = append(parts, "Kept")
+	}
+	return strings.Join(parts, " ")
+}
+
+// labelColor is nil for labels we do not know
+func labelColor(label string) color.Color {
+	switch label {
+	case "Red":
+		return color.NRGBA{R: 220, G: 50, B: 47, A: 255}
+	case "Yellow":
+		return color.NRGBA{R: 230, G: 190, B: 30, A: 255}
+	case "Green":
+		return color.NRGBA{R: 70, G: 170, B: 70, A: 255}
+	case "Blue":
+		return color.NRGBA{R: 50, G: 110, B: 220, A: 255}
+	case "Purple":
+		return color.NRGBA{R: 150, G: 70, B: 190, A: 255}
+	}
+	return nil
+}
+
+func (v *Viewer) showRating(uri fyne.URI) {
+	v.rating.Set(ratingText(v.ratings.get(uri)))
+}
+
+// rateFile writes the changed rating into the sidecar
+func (v *Viewer) rateFile(id string, uri fyne.URI, change func(md.Rating) md.Rating) error {
+	if afs.IsArchiveURI(uri) {
+		return errors.New("files inside of archives can not have a sidecar")
+	}
+	// someone else might have changed it since we looked
+	previous, err := md.ReadRating(uri.Path())
+	if err != nil {
+		return err
+	}
+	rating := change(previous)
+	err = md.WriteRating(uri.Path(), rating)
+	if err != nil {
+		return err
+	}
+	v.ratings.set(id, rating)
+	v.culled.push(cullOp{id: id, rated: true, previous: previous}, int(v.undolimit))
+	v.showRating(uri)
+	if text := ratingText(rating); text != "" {
+		v.setStatus("Rated " + uri.Name() + " " + text)
+	} else {
+		v.setStatus("Removed the rating of " + uri.Name())
+	}
+	return nil
+}
+
+// prefetchRatings reads the sidecars in parallel, going through the
+// files afterwards would do it one by one
+func (v *Viewer) prefetchRatings(files []string) {
+	sem := make(chan struct{}, max(1, v.maxworkers))
+	var wg sync.WaitGroup
+	for _, file := range files {
+		uri, ok := v.treeData().URI(file)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(uri fyne.URI) {
+			v.ratings.get(uri)
+			<-sem
+			wg.Done()
+		}(uri)
+	}
+	wg.Wait()
+}
+
+// keptFiles are the paths of the files in the player that were kept
+func (v *Viewer) keptFiles() []string {
+	files := v.imgplayer.List()
+	v.prefetchRatings(files)
+	var paths []string
+	for _, file := range files {
+		if uri, ok := v.treeData().URI(file); ok && v.ratings.get(uri).Kept {
+			paths = append(paths, uri.Path())
+		}
+	}
+	return paths
+}
+
+// filterByRating drops the files below the minimum rating
+func (v *Viewer) filterByRating(files []string) []string {
+	if v.minrating == 0 {
+		return files
+	}
+	v.prefetchRatings(files)
+	return slices.DeleteFunc(files, func(file string) bool {
+		uri, ok := v.treeData().URI(file)
+		return !ok || v.ratings.get(uri).Stars < int(v.minrating)
+	})
+}
+
+var minRatingNames = []string{"All Ratings", "1+ Stars", "2+ Stars", "3+ Stars", "4+ Stars", "5 Stars"}
+
+func (v *Viewer) makeRatingFilter() fyne.CanvasObject {
+	minrating := widget.NewSelect(minRatingNames, nil)
+	minrating.Selected = minRatingNames[min(v.minrating, uint(len(minRatingNames)-1))]
+	minrating.OnChanged = func(s string) {
+		v.minrating = uint(max(slices.Index(minRatingNames, s), 0))
+		v.SetNewFolder(v.selectedfolder, true, false)
+		v.setStatus("Showing " + s)
+	}
+	return minrating
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	md "github.com/BieHDC/fic/mediadata"
+)
+
+func TestForgetSidecar(t *testing.T) {
+	tests := []struct {
+		sidecar string
+		left    []string
+	}{
+		// the one other tools write is shared by everything with the name
+		{"file:///shoot/a.xmp", []string{"file:///shoot/ab.jpg", "file:///other/a.jpg", "file:///shoot/b.jpg"}},
+		{"file:///shoot/a.jpg.xmp", []string{"file:///shoot/a.cr2", "file:///shoot/ab.jpg", "file:///other/a.jpg", "file:///shoot/b.jpg"}},
+		{"file:///shoot/c.xmp", []string{"file:///shoot/a.jpg", "file:///shoot/a.cr2", "file:///shoot/ab.jpg", "file:///other/a.jpg", "file:///shoot/b.jpg"}},
+	}
+	for _, test := range tests {
+		var rc ratingCache
+		for _, id := range []string{"file:///shoot/a.jpg", "file:///shoot/a.cr2", "file:///shoot/ab.jpg", "file:///other/a.jpg", "file:///shoot/b.jpg"} {
+			rc.set(id, md.Rating{Stars: 3})
+		}
+		rc.forgetSidecar(test.sidecar)
+		var left []string
+		for id := range rc.ratings {
+			left = append(left, id)
+		}
+		slices.Sort(left)
+		want := slices.Clone(test.left)
+		slices.Sort(want)
+		if !slices.Equal(left, want) {
+			t.Errorf("%s: left %v, want %v", test.sidecar, left, want)
+		}
+	}
+}
+
+func TestRatingText(t *testing.T) {
+	tests := []struct {
+		rating md.Rating
+		want   string
+	}{
+		{md.Rating{}, ""},
+		{md.Rating{Stars: 3}, "3/5"},
+		{md.Rating{Stars: -1}, "Rejected"},
+		{md.Rating{Label: "Red"}, "Red"},
+		{md.Rating{Kept: true}, "Kept"},
+		{md.Rating{Stars: 5, Label: "To Print", Kept: true}, "5/5 To Print Kept"},
+	}
+	for _, test := range tests {
+		if got := ratingText(test.rating); got != test.want {
+			t.Errorf("%+v: got %q, want %q", test.rating, got, test.want)
+		}
+	}
+}
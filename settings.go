@@ -1,33 +1,105 @@
 package main
 
 import (
+	"sync"
+
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/data/validation"
 	"fyne.io/fyne/v2/driver/mobile"
 	"fyne.io/fyne/v2/widget"
+
+	md "github.com/BieHDC/fic/mediadata"
 )
 
 type Settings struct {
 	maxworkers        uint
 	maxfilesize       uint
+	maxcachesize      uint
+	maxcachedside     uint // 0 matches the window
+	resamplefilter    string
 	includesubfolders bool
+	includeextensions string // shown without looking at the content
+	excludeextensions string // never shown
+	ignorepatterns    string // like a .ficignore in the root folder
+	hidedotfiles      bool
+	followsymlinks    bool
+	opencommand       string // {} is replaced by the file
+	cullkeys          string // one KEY=action per line
+	rejectfolder      string // relative to the folder of the file unless absolute
+	cullcopy          bool   // copy to the target folders instead of moving
+	undolimit         uint
+	minrating         uint // the player only gets files rated at least this
+	sortby            string
+	sortdescending    bool
 	//windowsize
 	winx       float32
 	winy       float32
 	fullscreen bool
 }
 
+// the number keys move into folders, with ctrl they rate and label
+// like the number keys do in other tools
+const defaultCullKeys = `K=keep
+X=reject
+BackSpace=undo
+Delete=trash
+1=sorted/1
+2=sorted/2
+3=sorted/3
+4=sorted/4
+5=sorted/5
+6=sorted/6
+7=sorted/7
+8=sorted/8
+9=sorted/9
+Ctrl+0=rate 0
+Ctrl+1=rate 1
+Ctrl+2=rate 2
+Ctrl+3=rate 3
+Ctrl+4=rate 4
+Ctrl+5=rate 5
+Ctrl+6=label Red
+Ctrl+7=label Yellow
+Ctrl+8=label Green
+Ctrl+9=label Blue`
+
 var DefaultSettings = Settings{
 	maxworkers:        8,
 	maxfilesize:       100,
+	maxcachesize:      4096,
+	maxcachedside:     md.DefaultMaxSide,
+	resamplefilter:    md.DefaultResampleFilter,
 	includesubfolders: true,
+	excludeextensions: "psd, xcf, kra",
+	ignorepatterns:    "__MACOSX/",
+	hidedotfiles:      false,
+	cullkeys:          defaultCullKeys,
+	rejectfolder:      "rejected",
+	undolimit:         50,
+	sortby:            "Name",
 }
 
 func (s *Settings) LoadSettings() {
 	app := fyne.CurrentApp()
 	s.maxworkers = uint(app.Preferences().IntWithFallback("maxworkers", int(DefaultSettings.maxworkers)))
 	s.maxfilesize = uint(app.Preferences().IntWithFallback("maxfilesize", int(DefaultSettings.maxfilesize)))
+	s.maxcachesize = uint(app.Preferences().IntWithFallback("maxcachesize", int(DefaultSettings.maxcachesize)))
+	s.maxcachedside = uint(app.Preferences().IntWithFallback("maxcachedside", int(DefaultSettings.maxcachedside)))
+	s.resamplefilter = app.Preferences().StringWithFallback("resamplefilter", DefaultSettings.resamplefilter)
 	s.includesubfolders = app.Preferences().BoolWithFallback("includesubfolders", DefaultSettings.includesubfolders)
+	s.includeextensions = app.Preferences().StringWithFallback("includeextensions", DefaultSettings.includeextensions)
+	s.excludeextensions = app.Preferences().StringWithFallback("excludeextensions", DefaultSettings.excludeextensions)
+	s.ignorepatterns = app.Preferences().StringWithFallback("ignorepatterns", DefaultSettings.ignorepatterns)
+	s.hidedotfiles = app.Preferences().BoolWithFallback("hidedotfiles", DefaultSettings.hidedotfiles)
+	s.followsymlinks = app.Preferences().BoolWithFallback("followsymlinks", DefaultSettings.followsymlinks)
+	s.opencommand = app.Preferences().StringWithFallback("opencommand", DefaultSettings.opencommand)
+	s.cullkeys = app.Preferences().StringWithFallback("cullkeys", DefaultSettings.cullkeys)
+	s.rejectfolder = app.Preferences().StringWithFallback("rejectfolder", DefaultSettings.rejectfolder)
+	s.cullcopy = app.Preferences().BoolWithFallback("cullcopy", DefaultSettings.cullcopy)
+	s.undolimit = uint(app.Preferences().IntWithFallback("undolimit", int(DefaultSettings.undolimit)))
+	s.minrating = uint(app.Preferences().IntWithFallback("minrating", int(DefaultSettings.minrating)))
+	s.sortby = app.Preferences().StringWithFallback("sortby", DefaultSettings.sortby)
+	s.sortdescending = app.Preferences().BoolWithFallback("sortdescending", DefaultSettings.sortdescending)
 	//
 	s.winx = float32(app.Preferences().FloatWithFallback("winx", 800))
 	s.winy = float32(app.Preferences().FloatWithFallback("winy", 600))
@@ -37,24 +109,101 @@ func (s *Settings) LoadSettings() {
 func (s *Settings) LoadDefaults() {
 	s.maxworkers = DefaultSettings.maxworkers
 	s.maxfilesize = DefaultSettings.maxfilesize
+	s.maxcachesize = DefaultSettings.maxcachesize
+	s.maxcachedside = DefaultSettings.maxcachedside
+	s.resamplefilter = DefaultSettings.resamplefilter
 	s.includesubfolders = DefaultSettings.includesubfolders
+	s.includeextensions = DefaultSettings.includeextensions
+	s.excludeextensions = DefaultSettings.excludeextensions
+	s.ignorepatterns = DefaultSettings.ignorepatterns
+	s.hidedotfiles = DefaultSettings.hidedotfiles
+	s.followsymlinks = DefaultSettings.followsymlinks
+	s.opencommand = DefaultSettings.opencommand
+	s.cullkeys = DefaultSettings.cullkeys
+	s.rejectfolder = DefaultSettings.rejectfolder
+	s.cullcopy = DefaultSettings.cullcopy
+	s.undolimit = DefaultSettings.undolimit
 }
 
 func (s *Settings) SaveSettings(winx, winy float32, fullscreen bool) {
 	app := fyne.CurrentApp()
 	app.Preferences().SetInt("maxworkers", int(s.maxworkers))
 	app.Preferences().SetInt("maxfilesize", int(s.maxfilesize))
+	app.Preferences().SetInt("maxcachesize", int(s.maxcachesize))
+	app.Preferences().SetInt("maxcachedside", int(s.maxcachedside))
+	app.Preferences().SetString("resamplefilter", s.resamplefilter)
 	app.Preferences().SetBool("includesubfolders", s.includesubfolders)
+	app.Preferences().SetString("includeextensions", s.includeextensions)
+	app.Preferences().SetString("excludeextensions", s.excludeextensions)
+	app.Preferences().SetString("ignorepatterns", s.ignorepatterns)
+	app.Preferences().SetBool("hidedotfiles", s.hidedotfiles)
+	app.Preferences().SetBool("followsymlinks", s.followsymlinks)
+	app.Preferences().SetString("opencommand", s.opencommand)
+	app.Preferences().SetString("cullkeys", s.cullkeys)
+	app.Preferences().SetString("rejectfolder", s.rejectfolder)
+	app.Preferences().SetBool("cullcopy", s.cullcopy)
+	app.Preferences().SetInt("undolimit", int(s.undolimit))
+	app.Preferences().SetInt("minrating", int(s.minrating))
+	app.Preferences().SetString("sortby", s.sortby)
+	app.Preferences().SetBool("sortdescending", s.sortdescending)
 	//
 	app.Preferences().SetFloat("winx", float64(winx))
 	app.Preferences().SetFloat("winy", float64(winy))
 	app.Preferences().SetBool("fullscreen", fullscreen)
 }
 
-func (s *Settings) ApplySettings(maxworkers, maxfilesize uint, includesubfolders bool) {
-	s.maxworkers = maxworkers
-	s.maxfilesize = maxfilesize
-	s.includesubfolders = includesubfolders
+// ApplySettings takes over what was changed in the settings dialog,
+// which works on a copy of the current settings
+func (s *Settings) ApplySettings(changed Settings) {
+	*s = changed
+}
+
+// decodeSettings is the part of the settings the ticker needs. it gets its
+// own copy, as the settings dialog replaces the settings while it runs.
+type decodeSettings struct {
+	lock    sync.Mutex
+	maxside int // from the settings, 0 follows the window
+	filter  string
+	decoded int // the max side the cache decodes to right now
+}
+
+// applyDecodeSettings hands changed resolution settings to the cache
+func (v *Viewer) applyDecodeSettings(c fyne.Canvas) {
+	v.decode.lock.Lock()
+	v.decode.maxside, v.decode.filter = int(v.maxcachedside), v.resamplefilter
+	v.decode.lock.Unlock()
+	v.applyDecodePolicy(c)
+}
+
+// applyDecodePolicy sets the max side of the cache. 0 in the settings
+// follows the canvas in pixels, which is the screen when fullscreen. when
+// that grows, the images that were sized down for less are decoded again.
+func (v *Viewer) applyDecodePolicy(c fyne.Canvas) {
+	size := c.Size()
+	width, height := c.PixelCoordinateForPosition(fyne.NewPos(size.Width, size.Height))
+
+	v.decode.lock.Lock()
+	maxside := v.decode.maxside
+	if maxside == 0 {
+		maxside = max(width, height)
+		if maxside < 1 {
+			// not shown yet
+			maxside = md.DefaultMaxSide
+		}
+	}
+	grown := v.decode.decoded > 0 && maxside > v.decode.decoded
+	v.decode.decoded = maxside
+	// under the lock, so the last one to run wins
+	v.SetDecodePolicy(maxside, v.decode.filter)
+	v.decode.lock.Unlock()
+
+	if grown {
+		v.InvalidateSizedDown(maxside)
+		// the shown image was sized for the smaller window too
+		if current := v.imgplayer.Current(); current != "" && !v.treeData().IsFolder(current) {
+			v.imgplayer.SeekToData(current)
+		}
+	}
 }
 
 func NewFormItemWithHintText(text string, o fyne.CanvasObject, hint string) *widget.FormItem {
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"cmp"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	afs "github.com/BieHDC/fic/archivefs"
+	ft "github.com/BieHDC/fic/filetree"
+	md "github.com/BieHDC/fic/mediadata"
+)
+
+type sortKey int
+
+const (
+	sortName sortKey = iota
+	sortModified
+	sortSize
+	sortDimensions
+	sortCaptured
+)
+
+// also what is stored in the settings
+var sortKeyNames = []string{"Name", "Modified", "Size", "Dimensions", "Capture Date"}
+
+func sortKeyFromName(name string) sortKey {
+	i := slices.Index(sortKeyNames, name)
+	if i < 0 {
+		return sortName
+	}
+	return sortKey(i)
+}
+
+type sortOrder struct {
+	key        sortKey
+	descending bool
+}
+
+// needsImage is true if the files have to be opened to sort them
+func (so sortOrder) needsImage() bool {
+	return so.key == sortDimensions || so.key == sortCaptured
+}
+
+type sortInfo struct {
+	stated  bool
+	size    int64
+	modtime time.Time
+	probed  bool
+	image   md.ImageInfo
+}
+
+// the keys are expensive to get, so we keep them until the file changes
+type sortInfoCache struct {
+	lock  sync.Mutex
+	infos map[string]sortInfo
+}
+
+func (sc *sortInfoCache) get(uri fyne.URI, needimage bool) sortInfo {
+	id := uri.String()
+	sc.lock.Lock()
+	info := sc.infos[id]
+	sc.lock.Unlock()
+	if info.stated && (info.probed || !needimage) {
+		return info
+	}
+
+	if !info.stated {
+		info.size, info.modtime = statFile(uri)
+		info.stated = true
+	}
+	if needimage && !info.probed {
+		// not being an image is fine, it just has no dimensions or date
+		info.image, _ = md.ReadImageInfo(uri)
+		info.probed = true
+	}
+
+	sc.lock.Lock()
+	if sc.infos == nil {
+		sc.infos = make(map[string]sortInfo)
+	}
+	sc.infos[id] = info
+	sc.lock.Unlock()
+	return info
+}
+
+func (sc *sortInfoCache) forget(id string) {
+	sc.lock.Lock()
+	delete(sc.infos, id)
+	sc.lock.Unlock()
+}
+
+// statFile returns zero for what it cannot find out, like folders or archive members
+func statFile(uri fyne.URI) (int64, time.Time) {
+	if afs.IsArchiveURI(uri) {
+		size, _ := afs.Size(uri)
+		return size, time.Time{}
+	}
+	stat, err := os.Stat(uri.Path())
+	if err != nil || !stat.Mode().IsRegular() {
+		return 0, time.Time{}
+	}
+	return stat.Size(), stat.ModTime()
+}
+
+func (v *Viewer) sortOrder() sortOrder {
+	return sortOrder{key: sortKeyFromName(v.sortby), descending: v.sortdescending}
+}
+
+// cached is what get would return, without going to the disk for it
+func (sc *sortInfoCache) cached(uri fyne.URI, needimage bool) (sortInfo, bool) {
+	sc.lock.Lock()
+	info := sc.infos[uri.String()]
+	sc.lock.Unlock()
+	return info, info.stated && (info.probed || !needimage)
+}
+
+func (v *Viewer) compareFunc(order sortOrder) func(uria, urib fyne.URI) int {
+	return compareBy(order, &v.sortinfo)
+}
+
+// compareBy compares files, the ones with the same key are sorted by name.
+// it never reads from the disk, as the tree is locked while it runs. what
+// was not prefetched is sorted by name.
+func compareBy(order sortOrder, sc *sortInfoCache) func(uria, urib fyne.URI) int {
+	return func(uria, urib fyne.URI) int {
+		c := 0
+		if order.key != sortName {
+			infoa, oka := sc.cached(uria, order.needsImage())
+			infob, okb := sc.cached(urib, order.needsImage())
+			if oka && okb {
+				c = compareInfo(order.key, infoa, infob)
+			}
+		}
+		if c == 0 {
+			c = ft.CompareNatural(uria.Name(), urib.Name())
+		}
+		if order.descending {
+			return -c
+		}
+		return c
+	}
+}
+
+func compareInfo(key sortKey, a, b sortInfo) int {
+	switch key {
+	case sortModified:
+		return a.modtime.Compare(b.modtime)
+	case sortSize:
+		return cmp.Compare(a.size, b.size)
+	case sortDimensions:
+		return cmp.Or(
+			cmp.Compare(a.image.Width*a.image.Height, b.image.Width*b.image.Height),
+			cmp.Compare(a.image.Width, b.image.Width),
+		)
+	case sortCaptured:
+		return a.image.Captured.Compare(b.image.Captured)
+	}
+	return 0
+}
+
+// prefetch reads the keys in parallel, sorting only looks at what was read
+func (sc *sortInfoCache) prefetch(order sortOrder, uris []fyne.URI, workers int) {
+	if order.key == sortName {
+		return
+	}
+	sem := make(chan struct{}, max(1, workers))
+	var wg sync.WaitGroup
+	wg.Add(len(uris))
+	for _, uri := range uris {
+		sem <- struct{}{}
+		go func(uri fyne.URI) {
+			sc.get(uri, order.needsImage())
+			<-sem
+			wg.Done()
+		}(uri)
+	}
+	wg.Wait()
+}
+
+func (v *Viewer) sortFileTree(tree *ft.Filetreemaps) {
+	order := v.sortOrder()
+	v.sortinfo.prefetch(order, tree.Files(), int(v.maxworkers))
+	tree.Sort(v.compareFunc(order))
+}
+
+// sortFileList sorts a list of files collected from the tree. by name it
+// already is in order, otherwise subfolders get mixed in with each other.
+func (v *Viewer) sortFileList(files []string) {
+	order := v.sortOrder()
+	if order.key == sortName {
+		return
+	}
+	uris := make(map[string]fyne.URI, len(files))
+	prefetch := make([]fyne.URI, 0, len(files))
+	for _, file := range files {
+		if uri, ok := v.treeData().URI(file); ok {
+			uris[file] = uri
+			prefetch = append(prefetch, uri)
+		}
+	}
+	v.sortinfo.prefetch(order, prefetch, int(v.maxworkers))
+	compare := v.compareFunc(order)
+	slices.SortStableFunc(files, func(a, b string) int {
+		uria, oka := uris[a]
+		urib, okb := uris[b]
+		if !oka || !okb {
+			return ft.CompareNatural(a, b)
+		}
+		return compare(uria, urib)
+	})
+}
+
+// applySort sorts everything again while staying on the file that is shown
+func (v *Viewer) applySort() {
+	v.setStatus("Sorting by " + v.sortby + "...")
+	go func() {
+		v.sortFileTree(v.treeData())
+		v.filetree.Refresh()
+		v.RefreshFolder()
+		if v.selected != "" {
+			v.filetree.ScrollTo(v.selected)
+		}
+		v.setStatus("Sorted by " + v.sortby)
+	}()
+}
+
+func (v *Viewer) makeSortControls() fyne.CanvasObject {
+	direction := widget.NewButtonWithIcon("", theme.MoveDownIcon(), nil)
+	updatedirection := func() {
+		if v.sortdescending {
+			direction.SetIcon(theme.MoveUpIcon())
+		} else {
+			direction.SetIcon(theme.MoveDownIcon())
+		}
+	}
+	updatedirection()
+	direction.OnTapped = func() {
+		v.sortdescending = !v.sortdescending
+		updatedirection()
+		v.applySort()
+	}
+
+	sortby := widget.NewSelect(sortKeyNames, nil)
+	sortby.Selected = sortKeyNames[sortKeyFromName(v.sortby)]
+	sortby.OnChanged = func(s string) {
+		v.sortby = s
+		v.applySort()
+	}
+
+	return container.NewHBox(sortby, direction)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/storage"
+
+	md "github.com/BieHDC/fic/mediadata"
+)
+
+func TestCompareBy(t *testing.T) {
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	files := map[string]sortInfo{
+		"a.png": {size: 300, modtime: day.Add(2 * time.Hour), image: md.ImageInfo{Width: 20, Height: 10, Captured: day}},
+		"b.png": {size: 100, modtime: day, image: md.ImageInfo{Width: 10, Height: 20, Captured: day.Add(time.Hour)}},
+		"c.png": {size: 200, modtime: day.Add(time.Hour), image: md.ImageInfo{Width: 40, Height: 40}},
+		// the same keys as b, so it comes after it by name
+		"d.png": {size: 100, modtime: day, image: md.ImageInfo{Width: 10, Height: 20, Captured: day.Add(time.Hour)}},
+	}
+	sc := sortInfoCache{infos: make(map[string]sortInfo)}
+	var uris []fyne.URI
+	for name, info := range files {
+		uri := storage.NewFileURI("/shoot/" + name)
+		info.stated, info.probed = true, true
+		sc.infos[uri.String()] = info
+		uris = append(uris, uri)
+	}
+
+	tests := []struct {
+		key  sortKey
+		want []string
+	}{
+		{sortName, []string{"a.png", "b.png", "c.png", "d.png"}},
+		{sortModified, []string{"b.png", "d.png", "c.png", "a.png"}},
+		{sortSize, []string{"b.png", "d.png", "c.png", "a.png"}},
+		// the same area goes by width
+		{sortDimensions, []string{"b.png", "d.png", "a.png", "c.png"}},
+		// no date is the oldest
+		{sortCaptured, []string{"c.png", "a.png", "b.png", "d.png"}},
+	}
+	for _, test := range tests {
+		for _, descending := range []bool{false, true} {
+			sorted := slices.Clone(uris)
+			slices.SortFunc(sorted, compareBy(sortOrder{key: test.key, descending: descending}, &sc))
+			var got []string
+			for _, uri := range sorted {
+				got = append(got, uri.Name())
+			}
+			want := slices.Clone(test.want)
+			if descending {
+				slices.Reverse(want)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("%s descending %v: got %v, want %v", sortKeyNames[test.key], descending, got, want)
+			}
+		}
+	}
+}
+
+// TestCompareByNeverReads sorts what was not prefetched by name, as
+// reading the files would happen with the tree locked
+func TestCompareByNeverReads(t *testing.T) {
+	dir := t.TempDir()
+	var uris []fyne.URI
+	for name, size := range map[string]int{"a.png": 30, "b.png": 10, "c.png": 20} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		uris = append(uris, storage.NewFileURI(path))
+	}
+	names := func() []string {
+		var names []string
+		for _, uri := range uris {
+			names = append(names, uri.Name())
+		}
+		return names
+	}
+
+	var sc sortInfoCache
+	order := sortOrder{key: sortSize}
+	slices.SortFunc(uris, compareBy(order, &sc))
+	if got := names(); !slices.Equal(got, []string{"a.png", "b.png", "c.png"}) {
+		t.Errorf("without prefetching got %v, want them by name", got)
+	}
+	if len(sc.infos) != 0 {
+		t.Errorf("comparing read %d files", len(sc.infos))
+	}
+
+	sc.prefetch(order, uris, 2)
+	slices.SortFunc(uris, compareBy(order, &sc))
+	if got := names(); !slices.Equal(got, []string{"b.png", "c.png", "a.png"}) {
+		t.Errorf("after prefetching got %v, want them by size", got)
+	}
+}
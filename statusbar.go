@@ -6,8 +6,10 @@ import (
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/data/binding"
+	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 
+	md "github.com/BieHDC/fic/mediadata"
 	memory "github.com/BieHDC/fic/memquery"
 )
 
@@ -16,12 +18,19 @@ type Statusbar struct {
 	currentfilename binding.String
 	xoutofy         binding.String
 	memusage        binding.String
+	playstats       binding.String
+	rating          binding.String
+	cancelwalk      *widget.Button
 }
 
 func (v *Viewer) setFileNumber(cf, sum int) {
 	v.xoutofy.Set(fmt.Sprintf("(%d/%d)", cf+1, sum))
 }
 
+func (v *Viewer) setPlaybackStats(s string) {
+	v.playstats.Set(s)
+}
+
 func (v *Viewer) setStatus(s string) {
 	v.statusbar.Set(s)
 }
@@ -35,7 +44,7 @@ func (v *Viewer) refreshMemoryUsage() uint {
 
 	rampc := (float64(mi.MemoryTotal-mi.MemoryFree) / float64(mi.MemoryTotal)) * 100
 	swappc := (float64(mi.SwapTotal-mi.SwapFree) / float64(mi.SwapTotal)) * 100
-	v.memusage.Set(fmt.Sprintf("Ram%%: %0.0f | Swap%%: %0.0f", rampc, swappc))
+	v.memusage.Set(fmt.Sprintf("Cache: %d MB | Ram%%: %0.0f | Swap%%: %0.0f", md.BToMb(v.CacheUsage()), rampc, swappc))
 	return uint(rampc)
 }
 
@@ -48,16 +57,27 @@ func (v *Viewer) makeStatusbar() fyne.CanvasObject {
 	v.memusage = binding.NewString()
 	v.refreshMemoryUsage()
 
+	v.playstats = binding.NewString()
+	v.rating = binding.NewString()
+
+	v.cancelwalk = widget.NewButtonWithIcon("Stop Loading", theme.CancelIcon(), v.cancelTreeWalk)
+	v.cancelwalk.Hide()
+
 	v.statusbar = binding.NewString()
 	v.statusbar.Set("Ready")
 	return container.NewBorder(
 		nil,
 		nil,
 		container.NewHBox(
+			v.cancelwalk,
 			widget.NewLabelWithData(v.xoutofy),
 			widget.NewLabelWithData(v.currentfilename),
 		),
-		widget.NewLabelWithData(v.memusage),
+		container.NewHBox(
+			widget.NewLabelWithData(v.rating),
+			widget.NewLabelWithData(v.playstats),
+			widget.NewLabelWithData(v.memusage),
+		),
 		container.NewHScroll(widget.NewLabelWithData(v.statusbar)),
 	)
 }
@@ -0,0 +1,27 @@
+//go:build darwin
+// +build darwin
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// copyImageToClipboard hands the png to the system,
+// fyne can only put text into the clipboard
+func copyImageToClipboard(data []byte) error {
+	tmp, err := os.CreateTemp("", "fic-*.png")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	tmp.Close()
+	if err != nil {
+		return err
+	}
+	script := fmt.Sprintf("set the clipboard to (read (POSIX file %q) as «class PNGf»)", tmp.Name())
+	return exec.Command("osascript", "-e", script).Run()
+}
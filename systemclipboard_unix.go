@@ -0,0 +1,28 @@
+//go:build !windows && !darwin
+// +build !windows,!darwin
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// copyImageToClipboard hands the png to whatever the desktop uses,
+// fyne can only put text into the clipboard
+func copyImageToClipboard(data []byte) error {
+	var cmd *exec.Cmd
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		cmd = exec.Command("wl-copy", "--type", "image/png")
+	} else {
+		cmd = exec.Command("xclip", "-selection", "clipboard", "-target", "image/png", "-in")
+	}
+	cmd.Stdin = bytes.NewReader(data)
+	err := cmd.Run()
+	if errors.Is(err, exec.ErrNotFound) {
+		return errors.New("copying images needs wl-copy or xclip installed")
+	}
+	return err
+}
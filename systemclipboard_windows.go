@@ -0,0 +1,30 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// copyImageToClipboard hands the png to the system,
+// fyne can only put text into the clipboard
+func copyImageToClipboard(data []byte) error {
+	tmp, err := os.CreateTemp("", "fic-*.png")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	tmp.Close()
+	if err != nil {
+		return err
+	}
+	path := strings.ReplaceAll(tmp.Name(), "'", "''")
+	script := fmt.Sprintf("Add-Type -AssemblyName System.Windows.Forms,System.Drawing; "+
+		"$img = [System.Drawing.Image]::FromFile('%s'); [System.Windows.Forms.Clipboard]::SetImage($img); $img.Dispose()", path)
+	return exec.Command("powershell", "-NoProfile", "-STA", "-Command", script).Run()
+}
@@ -0,0 +1,140 @@
+package trash
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// https://specifications.freedesktop.org/trash-spec/latest/
+
+var ErrUnsupported = errors.New("there is no trash on this system")
+
+// Item is a file in the trash, keep it around to restore the file
+type Item struct {
+	Original string // where it was before
+	Trashed  string // where it is now
+	info     string
+}
+
+// Trash moves the file at path into the trash of its filesystem
+func Trash(path string) (*Item, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Lstat(path); err != nil {
+		return nil, err
+	}
+	trashdir, topdir, err := trashFor(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range []string{"files", "info"} {
+		err = os.MkdirAll(filepath.Join(trashdir, dir), 0o700)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// in the home trash the path is absolute, elsewhere relative to the top directory
+	infopath := path
+	if topdir != "" {
+		infopath, err = filepath.Rel(topdir, path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	item, err := reserve(trashdir, filepath.Base(path), infopath)
+	if err != nil {
+		return nil, err
+	}
+	item.Original = path
+	err = os.Rename(path, item.Trashed)
+	if err != nil {
+		os.Remove(item.info)
+		return nil, err
+	}
+	return item, nil
+}
+
+// reserve writes the info file under a free name, creating it is what
+// keeps others from picking the same name
+func reserve(trashdir, name, path string) (*Item, error) {
+	content := fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n",
+		escapePath(path), time.Now().Format("2006-01-02T15:04:05"))
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; i < 10000; i++ {
+		candidate := name
+		if i > 1 {
+			candidate = fmt.Sprintf("%s.%d%s", base, i, ext)
+		}
+		infofile := filepath.Join(trashdir, "info", candidate+".trashinfo")
+		f, err := os.OpenFile(infofile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		_, err = f.WriteString(content)
+		if closeerr := f.Close(); err == nil {
+			err = closeerr
+		}
+		if err != nil {
+			os.Remove(infofile)
+			return nil, err
+		}
+		trashed := filepath.Join(trashdir, "files", candidate)
+		if _, err := os.Lstat(trashed); err == nil {
+			// left over from someone not following the spec
+			os.Remove(infofile)
+			continue
+		}
+		return &Item{Trashed: trashed, info: infofile}, nil
+	}
+	return nil, fmt.Errorf("no free name in the trash for %s", name)
+}
+
+// escapePath escapes like an url path, but keeps the slashes
+func escapePath(path string) string {
+	return (&url.URL{Path: filepath.ToSlash(path)}).EscapedPath()
+}
+
+// Restore moves the file back to where it was, as long as nothing took its place
+func Restore(item *Item) error {
+	if _, err := os.Lstat(item.Original); err == nil {
+		return fmt.Errorf("%s exists again", item.Original)
+	}
+	err := os.MkdirAll(filepath.Dir(item.Original), 0o755)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(item.Trashed, item.Original)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(item.info)
+	if err != nil {
+		return fmt.Errorf("the trash entry of %s could not be removed: %w", item.Original, err)
+	}
+	return nil
+}
+
+func homeTrash() (string, error) {
+	data := os.Getenv("XDG_DATA_HOME")
+	if data == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		data = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(data, "Trash"), nil
+}
@@ -0,0 +1,9 @@
+//go:build windows || darwin || plan9
+// +build windows darwin plan9
+
+package trash
+
+// trashFor has nothing to offer, these systems have their own kind of trash
+func trashFor(path string) (string, string, error) {
+	return "", "", ErrUnsupported
+}
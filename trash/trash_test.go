@@ -0,0 +1,164 @@
+package trash
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEscapePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/home/me/a.png", "/home/me/a.png"},
+		{"/home/me/with space.png", "/home/me/with%20space.png"},
+		{"/home/me/100%.png", "/home/me/100%25.png"},
+		{"/home/me/what?#.png", "/home/me/what%3F%23.png"},
+		{"/home/me/grüße.png", "/home/me/gr%C3%BC%C3%9Fe.png"},
+		{"relative/dir/a.png", "relative/dir/a.png"},
+	}
+	for _, test := range tests {
+		if got := escapePath(test.path); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.path, got, test.want)
+		}
+	}
+}
+
+func makeTrash(t *testing.T) string {
+	t.Helper()
+	trashdir := t.TempDir()
+	for _, dir := range []string{"files", "info"} {
+		if err := os.Mkdir(filepath.Join(trashdir, dir), 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return trashdir
+}
+
+func TestReserve(t *testing.T) {
+	trashdir := makeTrash(t)
+	// someone else trashed an a.png already, and one was left without info
+	for _, file := range []string{"info/a.png.trashinfo", "files/a.png", "files/a.2.png"} {
+		if err := os.WriteFile(filepath.Join(trashdir, file), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"a.png", "a.3.png"},
+		{"a.png", "a.4.png"},
+		{"b.png", "b.png"},
+		{"noext", "noext"},
+		{"noext", "noext.2"},
+	}
+	for _, test := range tests {
+		item, err := reserve(trashdir, test.name, "/somewhere/"+test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := filepath.Base(item.Trashed); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+		if item.info != filepath.Join(trashdir, "info", test.want+".trashinfo") {
+			t.Errorf("%s: info file is %s", test.name, item.info)
+		}
+	}
+	// the one left without info gets its reservation cleaned up
+	if _, err := os.Lstat(filepath.Join(trashdir, "info", "a.2.png.trashinfo")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the skipped reservation is still there: %v", err)
+	}
+}
+
+func TestReserveInfo(t *testing.T) {
+	trashdir := makeTrash(t)
+	item, err := reserve(trashdir, "my photo.png", "/home/me/my photo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(item.info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(data), "\n")
+	if len(lines) != 4 || lines[0] != "[Trash Info]" || lines[1] != "Path=/home/me/my%20photo.png" ||
+		!strings.HasPrefix(lines[2], "DeletionDate=") || len(lines[2]) != len("DeletionDate=2006-01-02T15:04:05") {
+		t.Errorf("info file is %q", data)
+	}
+}
+
+func TestTrashAndRestore(t *testing.T) {
+	// on the same filesystem as the file, so the home trash is used
+	root := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", filepath.Join(root, "data"))
+	path := filepath.Join(root, "pictures", "a.png")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("picture"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := Trash(path)
+	if errors.Is(err, ErrUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Trashed != filepath.Join(root, "data", "Trash", "files", "a.png") {
+		t.Errorf("trashed to %s", item.Trashed)
+	}
+	data, err := os.ReadFile(item.info)
+	if err != nil || !strings.Contains(string(data), "Path="+path+"\n") {
+		t.Errorf("info file is %q, %v", data, err)
+	}
+	if _, err := os.Lstat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the file is still there: %v", err)
+	}
+
+	// something took its place meanwhile
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if Restore(item) == nil {
+		t.Error("restored over a new file")
+	}
+	os.Remove(path)
+
+	// restoring brings back the folder too
+	os.Remove(filepath.Dir(path))
+	if err := Restore(item); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "picture" {
+		t.Errorf("restored %q, %v", data, err)
+	}
+	if _, err := os.Lstat(item.info); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the info file is still there: %v", err)
+	}
+
+	if _, err := Trash(filepath.Join(root, "missing.png")); err == nil {
+		t.Error("trashed a file that does not exist")
+	}
+
+	// the file comes back even if its info can not be removed, but it is said so
+	item, err = Trash(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(item.info); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(item); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("restoring without info gives %v", err)
+	}
+	if _, err := os.Lstat(path); err != nil {
+		t.Errorf("the file is not back: %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+//go:build !windows && !darwin && !plan9
+// +build !windows,!darwin,!plan9
+
+package trash
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// trashFor picks the home trash if the file is on the same filesystem,
+// otherwise the one at the top of its mount. the top directory is empty
+// for the home trash.
+func trashFor(path string) (string, string, error) {
+	dev, err := device(filepath.Dir(path))
+	if err != nil {
+		return "", "", err
+	}
+
+	home, err := homeTrash()
+	if err != nil {
+		return "", "", err
+	}
+	// it has to exist to know where it is
+	err = os.MkdirAll(home, 0o700)
+	if err != nil {
+		return "", "", err
+	}
+	if homedev, err := device(home); err == nil && homedev == dev {
+		return home, "", nil
+	}
+
+	topdir, err := mountTop(filepath.Dir(path), dev)
+	if err != nil {
+		return "", "", err
+	}
+	uid := strconv.Itoa(os.Getuid())
+
+	// an admin provided .Trash has to be sticky and no link
+	shared := filepath.Join(topdir, ".Trash")
+	if info, err := os.Lstat(shared); err == nil && info.IsDir() && info.Mode()&os.ModeSticky != 0 {
+		dir := filepath.Join(shared, uid)
+		if err := os.Mkdir(dir, 0o700); err == nil || os.IsExist(err) {
+			if ownTrash(dir) == nil {
+				return dir, topdir, nil
+			}
+		}
+	}
+
+	dir := filepath.Join(topdir, ".Trash-"+uid)
+	err = os.Mkdir(dir, 0o700)
+	if err != nil && !os.IsExist(err) {
+		return "", "", fmt.Errorf("can not make a trash on %s: %w", topdir, err)
+	}
+	// the home trash is on another filesystem, so there is nothing to fall back to
+	err = ownTrash(dir)
+	if err != nil {
+		return "", "", err
+	}
+	return dir, topdir, nil
+}
+
+// ownTrash checks the trash is a real folder only we can get into,
+// anyone else could read or swap out what we put in there
+func ownTrash(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a usable trash", dir)
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ErrUnsupported
+	}
+	if int(st.Uid) != os.Getuid() || info.Mode().Perm() != 0o700 {
+		return fmt.Errorf("%s is not a usable trash, it has to be ours with mode 0700", dir)
+	}
+	return nil
+}
+
+func device(path string) (uint64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, ErrUnsupported
+	}
+	return uint64(st.Dev), nil
+}
+
+// mountTop walks up until the parent is on another device
+func mountTop(dir string, dev uint64) (string, error) {
+	for {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir, nil
+		}
+		parentdev, err := device(parent)
+		if err != nil {
+			return "", err
+		}
+		if parentdev != dev {
+			return dir, nil
+		}
+		dir = parent
+	}
+}
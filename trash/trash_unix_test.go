@@ -0,0 +1,52 @@
+//go:build !windows && !darwin && !plan9
+// +build !windows,!darwin,!plan9
+
+package trash
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOwnTrash(t *testing.T) {
+	root := t.TempDir()
+	mkdir := func(name string, perm os.FileMode) string {
+		dir := filepath.Join(root, name)
+		if err := os.Mkdir(dir, perm); err != nil {
+			t.Fatal(err)
+		}
+		// the umask has no say here
+		if err := os.Chmod(dir, perm); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+
+	if err := ownTrash(mkdir("good", 0o700)); err != nil {
+		t.Errorf("a private folder is refused: %v", err)
+	}
+	if ownTrash(mkdir("open", 0o755)) == nil {
+		t.Error("a folder others can read is used")
+	}
+	if ownTrash(mkdir("shared", 0o777|os.ModeSticky)) == nil {
+		t.Error("a folder everyone can write to is used")
+	}
+	link := filepath.Join(root, "link")
+	if err := os.Symlink(filepath.Join(root, "good"), link); err != nil {
+		t.Fatal(err)
+	}
+	if ownTrash(link) == nil {
+		t.Error("a link to a folder is used")
+	}
+	file := filepath.Join(root, "file")
+	if err := os.WriteFile(file, nil, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if ownTrash(file) == nil {
+		t.Error("a file is used")
+	}
+	if ownTrash(filepath.Join(root, "missing")) == nil {
+		t.Error("a missing folder is used")
+	}
+}
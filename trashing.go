@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+
+	afs "github.com/BieHDC/fic/archivefs"
+	md "github.com/BieHDC/fic/mediadata"
+	"github.com/BieHDC/fic/trash"
+)
+
+type trashedFile struct {
+	item    *trash.Item
+	sidecar *trash.Item // nil if it had none
+	id      string
+	// where it was in the player, if it was in there
+	inplayer        bool
+	index, position int
+}
+
+// trashHistory remembers what we moved to the trash, newest last
+type trashHistory struct {
+	mu    sync.Mutex
+	files []trashedFile
+}
+
+func (th *trashHistory) push(tf trashedFile, limit int) {
+	th.mu.Lock()
+	th.files = append(th.files, tf)
+	if over := len(th.files) - max(limit, 1); over > 0 {
+		th.files = th.files[over:]
+	}
+	th.mu.Unlock()
+}
+
+func (th *trashHistory) pop() (trashedFile, bool) {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	if len(th.files) < 1 {
+		return trashedFile{}, false
+	}
+	tf := th.files[len(th.files)-1]
+	th.files = th.files[:len(th.files)-1]
+	return tf, true
+}
+
+// confirmTrash asks before moving the file into the trash
+func (v *Viewer) confirmTrash(uri fyne.URI) error {
+	if afs.IsArchiveURI(uri) {
+		return errors.New("files inside of archives can not be deleted")
+	}
+	dialog.ShowConfirm("Move to Trash", fmt.Sprintf("Move %s to the trash?", uri.Name()), func(ok bool) {
+		if !ok {
+			return
+		}
+		if err := v.trashFile(uri); err != nil {
+			v.setStatus("Moving " + uri.Name() + " to the trash failed: " + err.Error())
+		}
+	}, v.window)
+	return nil
+}
+
+func (v *Viewer) trashFile(uri fyne.URI) error {
+	id := uri.String()
+	sidecar := md.SidecarPath(uri.Path())
+	item, err := trash.Trash(uri.Path())
+	if err != nil {
+		return err
+	}
+	tf := trashedFile{item: item, id: id}
+	if _, err := os.Lstat(sidecar); err == nil {
+		// without the file it is of no use, and restoring brings it back
+		tf.sidecar, _ = trash.Trash(sidecar)
+	}
+	v.InvalidateImage(id)
+	v.ratings.forget(id)
+	v.syncFileTree(item.Original)
+	tf.index, tf.position, tf.inplayer = v.imgplayer.RemoveData(id)
+	v.trashed.push(tf, int(v.undolimit))
+	v.setStatus("Moved " + uri.Name() + " to the trash")
+	return nil
+}
+
+// restoreTrashed brings back the file that was trashed last
+func (v *Viewer) restoreTrashed() {
+	tf, ok := v.trashed.pop()
+	if !ok {
+		v.setStatus("Nothing to restore")
+		return
+	}
+	err := trash.Restore(tf.item)
+	if _, staterr := os.Lstat(tf.item.Original); err != nil && staterr != nil {
+		v.setStatus("Restoring failed: " + err.Error())
+		return
+	}
+	status := "Restored " + filepath.Base(tf.item.Original)
+	if err != nil {
+		// it is back, but the trash still lists it
+		status += ", but " + err.Error()
+	}
+	if tf.sidecar != nil {
+		if err := trash.Restore(tf.sidecar); err != nil {
+			status += ", but not its sidecar: " + err.Error()
+		}
+	}
+	v.syncFileTree(tf.item.Original)
+	if tf.inplayer {
+		v.imgplayer.InsertData(tf.id, tf.index, tf.position)
+	}
+	v.setStatus(status)
+}
@@ -0,0 +1,339 @@
+package zv
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+type ZoomMode int
+
+const (
+	ZoomFit      ZoomMode = iota // the whole image in the window
+	ZoomFill                     // the whole window full of image
+	ZoomOriginal                 // one image pixel on one screen pixel
+	zoomFree                     // wherever the mouse wheel left it
+)
+
+const (
+	zoomStep     = 1.2 // per notch of the mouse wheel
+	maxZoom      = 32  // times the original size
+	scrollNotch  = 10  // what fyne reports for one notch
+	pixelatedAt  = 2   // show the pixels instead of smoothing them from this zoom on
+	minZoomOfFit = 0.25
+)
+
+type ZoomViewer struct {
+	widget.BaseWidget
+	//
+	img       *canvas.Image
+	srcwidth  int // of the full resolution image
+	srcheight int
+	imgwidth  int // of what we display right now
+	//
+	// layout runs wherever Refresh was called from, this keeps it
+	// and the events from changing what is below at the same time
+	lock   sync.Mutex
+	mode   ZoomMode
+	scale  float32       // canvas units per source pixel
+	offset fyne.Position // of the image inside the surface
+	//
+	loadFull      func() (image.Image, error)
+	fullrequested bool
+	fullerr       error // shown next to the zoom
+	// what the background load brings, taken over by layout
+	full    image.Image
+	loaderr error
+	stopped bool
+	//
+	surface *surface
+	zoom    *widget.Label
+	content fyne.CanvasObject
+}
+
+// img is what is cached, which may be smaller than the source.
+// loadFull is called once when zooming in further than that.
+func NewZoomViewer(img image.Image, srcwidth, srcheight int, loadFull func() (image.Image, error)) *ZoomViewer {
+	z := &ZoomViewer{}
+	z.ExtendBaseWidget(z)
+
+	z.img = canvas.NewImageFromImage(img)
+	z.img.FillMode = canvas.ImageFillStretch
+	z.img.ScaleMode = canvas.ImageScaleSmooth
+	z.imgwidth = img.Bounds().Dx()
+	z.srcwidth = max(srcwidth, img.Bounds().Dx(), 1)
+	z.srcheight = max(srcheight, img.Bounds().Dy(), 1)
+	z.loadFull = loadFull
+
+	z.surface = &surface{z: z}
+	z.surface.ExtendBaseWidget(z.surface)
+	// the scroller is only here to clip the image to our bounds
+	clip := container.NewScroll(z.surface)
+	clip.Direction = container.ScrollNone
+
+	z.zoom = widget.NewLabel("")
+	controls := container.NewHBox(
+		widget.NewButtonWithIcon("Fit", theme.ViewFullScreenIcon(), func() { z.SetMode(ZoomFit) }),
+		widget.NewButtonWithIcon("Fill", theme.ViewRestoreIcon(), func() { z.SetMode(ZoomFill) }),
+		widget.NewButtonWithIcon("1:1", theme.ZoomInIcon(), func() { z.SetMode(ZoomOriginal) }),
+		z.zoom,
+	)
+	z.content = container.NewBorder(nil, container.NewCenter(controls), nil, nil, clip)
+
+	return z
+}
+
+func (z *ZoomViewer) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(z.content)
+}
+
+// Stop drops the full resolution if it is still loading, the viewer is not shown anymore
+func (z *ZoomViewer) Stop() {
+	z.lock.Lock()
+	z.stopped = true
+	z.full = nil
+	z.lock.Unlock()
+}
+
+func (z *ZoomViewer) SetMode(mode ZoomMode) {
+	z.lock.Lock()
+	z.mode = mode
+	z.offset = fyne.Position{}
+	z.lock.Unlock()
+	z.surface.Refresh()
+}
+
+// canvasScale is how many screen pixels make up a canvas unit
+func (z *ZoomViewer) canvasScale() float32 {
+	c := fyne.CurrentApp().Driver().CanvasForObject(z)
+	if c == nil {
+		return 1
+	}
+	return c.Scale()
+}
+
+func (z *ZoomViewer) modeScale(mode ZoomMode, size fyne.Size) float32 {
+	scalex := size.Width / float32(z.srcwidth)
+	scaley := size.Height / float32(z.srcheight)
+	switch mode {
+	case ZoomFill:
+		return max(scalex, scaley)
+	case ZoomOriginal:
+		return 1 / z.canvasScale()
+	default:
+		return min(scalex, scaley)
+	}
+}
+
+// clamp keeps the image from being dragged out of view, an axis
+// that is smaller than the surface is centered instead
+func (z *ZoomViewer) clampNotLocked(size fyne.Size) {
+	clampaxis := func(offset, image, view float32) float32 {
+		if image <= view {
+			return (view - image) / 2
+		}
+		return min(max(offset, view-image), 0)
+	}
+	z.offset.X = clampaxis(z.offset.X, float32(z.srcwidth)*z.scale, size.Width)
+	z.offset.Y = clampaxis(z.offset.Y, float32(z.srcheight)*z.scale, size.Height)
+}
+
+func (z *ZoomViewer) layout(size fyne.Size) {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	if z.mode != zoomFree {
+		z.scale = z.modeScale(z.mode, size)
+		if z.offset.IsZero() {
+			// start in the middle, clamp centers what fits
+			z.offset = fyne.NewPos(
+				(size.Width-float32(z.srcwidth)*z.scale)/2,
+				(size.Height-float32(z.srcheight)*z.scale)/2,
+			)
+		}
+	}
+	z.clampNotLocked(size)
+
+	z.img.Move(z.offset)
+	z.img.Resize(fyne.NewSize(float32(z.srcwidth)*z.scale, float32(z.srcheight)*z.scale))
+
+	pixelzoom := z.scale * z.canvasScale()
+	scalemode := canvas.ImageScaleSmooth
+	if pixelzoom >= pixelatedAt {
+		scalemode = canvas.ImageScalePixels
+	}
+	swapped := z.takeFullNotLocked()
+	if z.img.ScaleMode != scalemode || swapped {
+		z.img.ScaleMode = scalemode
+		z.img.Refresh()
+	}
+	zoomtext := fmt.Sprintf("%0.0f%%", pixelzoom*100)
+	if z.fullerr != nil {
+		zoomtext += ", loading full resolution failed: " + z.fullerr.Error()
+	}
+	z.zoom.SetText(zoomtext)
+
+	z.maybeLoadFullNotLocked(pixelzoom)
+}
+
+// takeFullNotLocked swaps in the full resolution once it was
+// loaded, so the image is only ever changed where it is laid out
+func (z *ZoomViewer) takeFullNotLocked() bool {
+	full, err := z.full, z.loaderr
+	z.full, z.loaderr = nil, nil
+
+	if err != nil {
+		z.fullerr = err
+	}
+	if full == nil {
+		return false
+	}
+	z.img.Image = full
+	z.imgwidth = full.Bounds().Dx()
+	return true
+}
+
+// maybeLoadFullNotLocked swaps in the full resolution once we
+// show more screen pixels than the cached image has
+func (z *ZoomViewer) maybeLoadFullNotLocked(pixelzoom float32) {
+	if z.loadFull == nil || z.fullrequested || z.imgwidth >= z.srcwidth {
+		return
+	}
+	if pixelzoom*float32(z.srcwidth) <= float32(z.imgwidth) {
+		return
+	}
+	z.fullrequested = true
+	z.zoom.SetText("Loading full resolution...")
+	go func() {
+		full, err := z.loadFull()
+		z.lock.Lock()
+		if z.stopped {
+			// another file is shown by now
+			z.lock.Unlock()
+			return
+		}
+		z.full, z.loaderr = full, err
+		z.lock.Unlock()
+		// layout takes it over
+		z.surface.Refresh()
+	}()
+}
+
+func StopAllCanvasObjectsThatAreZoomViewers(o fyne.CanvasObject) {
+	if viewer, ok := o.(*ZoomViewer); ok {
+		viewer.Stop()
+		return
+	}
+	if cont, ok := o.(*fyne.Container); ok {
+		for _, obj := range cont.Objects {
+			StopAllCanvasObjectsThatAreZoomViewers(obj)
+		}
+	}
+}
+
+func (z *ZoomViewer) zoomAt(pos fyne.Position, factor float32) {
+	z.lock.Lock()
+	zoomed := z.zoomAtNotLocked(pos, factor)
+	z.lock.Unlock()
+	if zoomed {
+		z.surface.Refresh()
+	}
+}
+
+// zoomAtNotLocked changes the zoom while keeping the image point under the cursor where it is
+func (z *ZoomViewer) zoomAtNotLocked(pos fyne.Position, factor float32) bool {
+	size := z.surface.Size()
+	minscale := z.modeScale(ZoomFit, size) * minZoomOfFit
+	maxscale := maxZoom / z.canvasScale()
+	newscale := min(max(z.scale*factor, minscale), maxscale)
+	if newscale == z.scale {
+		return false
+	}
+
+	imagex := (pos.X - z.offset.X) / z.scale
+	imagey := (pos.Y - z.offset.Y) / z.scale
+	z.scale = newscale
+	z.offset = fyne.NewPos(pos.X-imagex*newscale, pos.Y-imagey*newscale)
+	z.mode = zoomFree
+	return true
+}
+
+func (z *ZoomViewer) pan(delta fyne.Delta) {
+	z.lock.Lock()
+	z.offset = z.offset.Add(delta)
+	z.lock.Unlock()
+	z.surface.Refresh()
+}
+
+// toggleOriginal jumps between fit and the pixels under the cursor
+func (z *ZoomViewer) toggleOriginal(pos fyne.Position) {
+	z.lock.Lock()
+	if z.mode != ZoomFit {
+		z.lock.Unlock()
+		z.SetMode(ZoomFit)
+		return
+	}
+	z.zoomAtNotLocked(pos, z.modeScale(ZoomOriginal, z.surface.Size())/z.scale)
+	z.mode = ZoomOriginal
+	z.lock.Unlock()
+	z.surface.Refresh()
+}
+
+// surface sits inside the clipping scroller, which would
+// swallow the mouse wheel if it was us handling it outside
+type surface struct {
+	widget.BaseWidget
+	z *ZoomViewer
+}
+
+var _ fyne.Scrollable = (*surface)(nil)
+var _ fyne.Draggable = (*surface)(nil)
+var _ fyne.DoubleTappable = (*surface)(nil)
+
+func (s *surface) Scrolled(ev *fyne.ScrollEvent) {
+	factor := float32(math.Pow(zoomStep, float64(ev.Scrolled.DY/scrollNotch)))
+	s.z.zoomAt(ev.Position, factor)
+}
+
+func (s *surface) Dragged(ev *fyne.DragEvent) {
+	s.z.pan(ev.Dragged)
+}
+
+func (s *surface) DragEnd() {}
+
+func (s *surface) DoubleTapped(ev *fyne.PointEvent) {
+	s.z.toggleOriginal(ev.Position)
+}
+
+func (s *surface) CreateRenderer() fyne.WidgetRenderer {
+	return &surfaceRenderer{s: s}
+}
+
+type surfaceRenderer struct {
+	s *surface
+}
+
+func (r *surfaceRenderer) Layout(size fyne.Size) {
+	r.s.z.layout(size)
+}
+
+func (r *surfaceRenderer) MinSize() fyne.Size {
+	return fyne.NewSize(0, 0)
+}
+
+func (r *surfaceRenderer) Refresh() {
+	r.Layout(r.s.Size())
+	canvas.Refresh(r.s)
+}
+
+func (r *surfaceRenderer) Objects() []fyne.CanvasObject {
+	return []fyne.CanvasObject{r.s.z.img}
+}
+
+func (r *surfaceRenderer) Destroy() {}
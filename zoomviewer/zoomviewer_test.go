@@ -0,0 +1,64 @@
+package zv
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/test"
+)
+
+func newTestViewer(t *testing.T, loaded chan struct{}) *ZoomViewer {
+	test.NewApp()
+	small := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	z := NewZoomViewer(small, 1000, 1000, func() (image.Image, error) {
+		<-loaded
+		return image.NewRGBA(image.Rect(0, 0, 1000, 1000)), nil
+	})
+	w := test.NewWindow(z)
+	t.Cleanup(w.Close)
+	w.Resize(fyne.NewSize(200, 200))
+	return z
+}
+
+// waitFor polls, as the full resolution arrives from another goroutine
+func waitFor(t *testing.T, z *ZoomViewer, width int) bool {
+	for range 100 {
+		z.surface.Refresh()
+		z.lock.Lock()
+		imgwidth := z.imgwidth
+		z.lock.Unlock()
+		if imgwidth == width {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestLoadFull(t *testing.T) {
+	loaded := make(chan struct{})
+	z := newTestViewer(t, loaded)
+	// the fit is larger than the 10 pixels that are cached
+	z.lock.Lock()
+	requested := z.fullrequested
+	z.lock.Unlock()
+	if !requested {
+		t.Fatal("the full resolution was not requested")
+	}
+	close(loaded)
+	if !waitFor(t, z, 1000) {
+		t.Error("the full resolution was not swapped in")
+	}
+}
+
+func TestLoadFullAfterStop(t *testing.T) {
+	loaded := make(chan struct{})
+	z := newTestViewer(t, loaded)
+	z.Stop()
+	close(loaded)
+	if waitFor(t, z, 1000) {
+		t.Error("the full resolution was swapped in after the viewer was stopped")
+	}
+}